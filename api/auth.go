@@ -0,0 +1,281 @@
+package api
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/loerac/vaultDepot/models"
+)
+
+type contextKey string
+
+const (
+    userKey    contextKey = "user"
+    sessionKey contextKey = "session"
+)
+
+/**
+ * Body of signup and login requests
+ **/
+type credentialsJSON struct {
+    Username  string `json:"username"`
+    Password  string `json:"password"`
+    SecretKey string `json:"secret_key"`
+
+    /* Two-factor or recovery code, only for login to accounts with two-factor login */
+    OTP       string `json:"otp,omitempty"`
+}
+
+/**
+ * Session as shown to the client, the token only when it's created
+ **/
+type sessionJSON struct {
+    ID         uint      `json:"id"`
+    Token      string    `json:"token,omitempty"`
+    Label      string    `json:"label"`
+    CreatedAt  time.Time `json:"created_at"`
+    LastUsedAt time.Time `json:"last_used_at"`
+    ExpiresAt  time.Time `json:"expires_at"`
+    Current    bool      `json:"current,omitempty"`
+}
+
+func newSessionJSON(session models.Session) sessionJSON {
+    return sessionJSON{
+        ID: session.ID,
+        Token: session.Remember,
+        Label: session.Label,
+        CreatedAt: session.CreatedAt,
+        LastUsedAt: session.LastUsedAt,
+        ExpiresAt: session.ExpiresAt,
+    }
+}
+
+/**
+ * @brief:  Get the user of an authenticated request (see requireUser)
+ *
+ * @arg:    r - Request
+ *
+ * @return: User
+ **/
+func requestUser(r *http.Request) models.User {
+    return *r.Context().Value(userKey).(*models.User)
+}
+
+/**
+ * @brief:  Get the session of an authenticated request (see requireUser)
+ *
+ * @arg:    r - Request
+ *
+ * @return: Session
+ **/
+func requestSession(r *http.Request) models.Session {
+    return *r.Context().Value(sessionKey).(*models.Session)
+}
+
+/**
+ * @brief:  Only let requests with an active session's bearer token through,
+ *          with the user and session in the request context
+ *
+ * @arg:    next - Handler of the route
+ *
+ * @return: Handler
+ **/
+func (s *Server) requireSession(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        auth := r.Header.Get("Authorization")
+        if !strings.HasPrefix(auth, "Bearer ") {
+            writeError(w, ErrAuthRequired)
+            return
+        }
+
+        session, user, err := models.ByRemember(s.db, strings.TrimPrefix(auth, "Bearer "))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+
+        ctx := context.WithValue(r.Context(), userKey, user)
+        ctx = context.WithValue(ctx, sessionKey, session)
+        next(w, r.WithContext(ctx))
+    }
+}
+
+/**
+ * @brief:  Only let sessions of local accounts through, remote accounts
+ *          have no entries the server could decrypt
+ *
+ * @arg:    next - Handler of the route
+ *
+ * @return: Handler
+ **/
+func (s *Server) requireUser(next http.HandlerFunc) http.HandlerFunc {
+    return s.requireSession(func(w http.ResponseWriter, r *http.Request) {
+        if requestUser(r).Remote {
+            writeError(w, models.ErrRemoteAccount)
+            return
+        }
+
+        next(w, r)
+    })
+}
+
+/**
+ * @brief:  Get the address a request came from, logins are throttled per
+ *          address
+ *
+ * @arg:    r - Request
+ *
+ * @return: IP of the client
+ **/
+func remoteAddr(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+
+    return host
+}
+
+/**
+ * @brief:  Name a session after the address it was started from
+ *
+ * @arg:    r - Login or signup request
+ *
+ * @return: Label of the session
+ **/
+func sessionLabel(r *http.Request) string {
+    return "api " + remoteAddr(r)
+}
+
+/**
+ * @brief:  Start a session for the user and answer with its token
+ *
+ * @arg:    w - Response
+ * @arg:    r - Login or signup request
+ * @arg:    user - Authenticated user
+ * @arg:    status - HTTP status of the response
+ **/
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user models.User, status int) {
+    session, err := models.CreateSession(s.db, user, sessionLabel(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, status, newSessionJSON(session))
+}
+
+/**
+ * POST /api/signup
+ **/
+func (s *Server) signup(w http.ResponseWriter, r *http.Request) {
+    var form credentialsJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    if _, err := models.ByUsername(s.db, form.Username); err == nil {
+        writeError(w, models.ErrUsernameTaken)
+        return
+    } else if err != models.ErrNotFound {
+        writeError(w, err)
+        return
+    }
+
+    user := models.User{
+        Username: strings.TrimSpace(form.Username),
+        Password: form.Password,
+        SecretKey: form.SecretKey,
+    }
+    if user.Username == "" {
+        writeError(w, models.ErrUsernameRequired)
+        return
+    }
+    if err := models.CreateUser(s.db, &user); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    s.startSession(w, r, user, http.StatusCreated)
+}
+
+/**
+ * POST /api/login
+ **/
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+    var form credentialsJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user, err := models.Authenticate(s.db, models.User{
+        Username: strings.TrimSpace(form.Username),
+        Password: form.Password,
+        SecretKey: form.SecretKey,
+        OTP: form.OTP,
+    }, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    s.startSession(w, r, *user, http.StatusOK)
+}
+
+/**
+ * POST /api/logout
+ **/
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+    session := requestSession(r)
+    if err := models.RevokeSession(s.db, session.UserID, session.ID); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * GET /api/sessions
+ **/
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+    current := requestSession(r)
+    sessions, err := models.ActiveSessions(s.db, current.UserID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    body := make([]sessionJSON, len(sessions))
+    for i, session := range sessions {
+        body[i] = newSessionJSON(session)
+        body[i].Current = session.ID == current.ID
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * DELETE /api/sessions/{id}
+ **/
+func (s *Server) revokeSession(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+    if err != nil {
+        writeError(w, models.ErrIDInvalid)
+        return
+    }
+
+    if err := models.RevokeSession(s.db, requestUser(r).ID, uint(id)); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
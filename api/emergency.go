@@ -0,0 +1,252 @@
+package api
+
+import (
+    "net/http"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Emergency access between the user and another user
+ **/
+type emergencyJSON struct {
+    Username    string     `json:"username"`
+    Access      string     `json:"access"`
+    WaitDays    int        `json:"wait_days"`
+    Status      string     `json:"status"`
+    RequestedAt *time.Time `json:"requested_at,omitempty"`
+    GrantsAt    *time.Time `json:"grants_at,omitempty"`
+}
+
+/**
+ * Body of PUT /api/emergency/contacts/{username}
+ **/
+type emergencyContactJSON struct {
+    Access   string `json:"access"`
+    WaitDays int    `json:"wait_days"`
+}
+
+/**
+ * Body of POST /api/emergency/trusted/{username}/takeover
+ **/
+type takeoverJSON struct {
+    Password  string `json:"password"`
+    SecretKey string `json:"secret_key"`
+}
+
+/**
+ * @brief:  Get an emergency access, granted once the waiting period is over
+ *
+ * @arg:    access - Emergency access with the username of the other user
+ *
+ * @return: Emergency access
+ **/
+func newEmergencyJSON(access models.EmergencyAccess) emergencyJSON {
+    body := emergencyJSON{
+        Username: access.Username,
+        Access: access.Access,
+        WaitDays: access.WaitDays,
+        Status: access.Status,
+        RequestedAt: access.RequestedAt,
+    }
+    if access.Granted(time.Now()) {
+        body.Status = models.EmergencyGranted
+    }
+    if access.RequestedAt != nil {
+        grantsAt := access.GrantsAt()
+        body.GrantsAt = &grantsAt
+    }
+
+    return body
+}
+
+/**
+ * @brief:  Answer with emergency accesses
+ *
+ * @arg:    w - Response
+ * @arg:    contacts - Emergency accesses with the usernames of the other users
+ **/
+func writeEmergency(w http.ResponseWriter, contacts []models.EmergencyAccess) {
+    body := make([]emergencyJSON, len(contacts))
+    for i, contact := range contacts {
+        body[i] = newEmergencyJSON(contact)
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * GET /api/emergency/contacts
+ **/
+func (s *Server) listEmergencyContacts(w http.ResponseWriter, r *http.Request) {
+    contacts, err := models.EmergencyContacts(s.db, requestUser(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeEmergency(w, contacts)
+}
+
+/**
+ * PUT /api/emergency/contacts/{username}
+ *
+ * Trusts the user, or changes what they can do, dropping any request
+ **/
+func (s *Server) putEmergencyContact(w http.ResponseWriter, r *http.Request) {
+    var form emergencyContactJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    err := models.AddEmergencyContact(s.db, requestUser(r), mux.Vars(r)["username"], form.Access,
+        form.WaitDays, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * DELETE /api/emergency/contacts/{username}
+ **/
+func (s *Server) removeEmergencyContact(w http.ResponseWriter, r *http.Request) {
+    err := models.RemoveEmergencyContact(s.db, requestUser(r), mux.Vars(r)["username"], remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * POST /api/emergency/contacts/{username}/approve
+ **/
+func (s *Server) approveEmergency(w http.ResponseWriter, r *http.Request) {
+    err := models.ApproveEmergencyAccess(s.db, requestUser(r), mux.Vars(r)["username"], remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * POST /api/emergency/contacts/{username}/reject
+ *
+ * Rejects a request, or takes back access that was granted
+ **/
+func (s *Server) rejectEmergency(w http.ResponseWriter, r *http.Request) {
+    err := models.RejectEmergencyAccess(s.db, requestUser(r), mux.Vars(r)["username"], remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * GET /api/emergency/trusted
+ **/
+func (s *Server) listTrustedBy(w http.ResponseWriter, r *http.Request) {
+    contacts, err := models.TrustedBy(s.db, requestUser(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeEmergency(w, contacts)
+}
+
+/**
+ * POST /api/emergency/trusted/{username}/request
+ **/
+func (s *Server) requestEmergency(w http.ResponseWriter, r *http.Request) {
+    access, err := models.RequestEmergencyAccess(s.db, requestUser(r), mux.Vars(r)["username"], remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newEmergencyJSON(access))
+}
+
+/**
+ * GET /api/emergency/trusted/{username}/vault
+ **/
+func (s *Server) listEmergencyVault(w http.ResponseWriter, r *http.Request) {
+    grantor, err := models.OpenEmergencyVault(s.db, requestUser(r), mux.Vars(r)["username"], remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vaults, err := models.FindAll(s.db, grantor.ID)
+    if err != nil && err != models.ErrNotFound {
+        writeError(w, err)
+        return
+    }
+    if err := models.DecryptMetadata(vaults, *grantor); err != nil {
+        writeError(w, err)
+        return
+    }
+    models.SortVaults(vaults)
+
+    writeEntries(w, vaults)
+}
+
+/**
+ * GET /api/emergency/trusted/{username}/vault/{id}
+ **/
+func (s *Server) getEmergencyEntry(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    grantor, err := models.OpenEmergencyVault(s.db, requestUser(r), mux.Vars(r)["username"], remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err := models.ByID(s.db, id, *grantor)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newEntryJSON(vault))
+}
+
+/**
+ * POST /api/emergency/trusted/{username}/takeover
+ *
+ * Resets the password and secret key of the account, turns its two-factor
+ * login off and revokes its sessions
+ **/
+func (s *Server) takeOverAccount(w http.ResponseWriter, r *http.Request) {
+    var form takeoverJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    reset := models.User{Password: form.Password, SecretKey: form.SecretKey}
+    err := models.TakeOverAccount(s.db, requestUser(r), mux.Vars(r)["username"], reset, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+    "strings"
+)
+
+type apiError string
+
+var (
+    /* Return when a request has no valid bearer token */
+    ErrAuthRequired apiError = "api: Log in to continue"
+
+    /* Return when a request body isn't the expected JSON */
+    ErrBodyInvalid apiError = "api: Request body is not valid"
+)
+
+func (err apiError) Error() string {
+    return string(err)
+}
+
+func (err apiError) Public() string {
+    str := strings.Replace(string(err), "api: ", "", 1)
+    return str
+}
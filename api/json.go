@@ -0,0 +1,110 @@
+package api
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Largest JSON body accepted
+ **/
+const maxJSONBody = 1 << 20
+
+/**
+ * Errors that can be shown to the client as is
+ **/
+type publicError interface {
+    error
+    Public() string
+}
+
+/**
+ * Body of an error response
+ **/
+type errorJSON struct {
+    Error string `json:"error"`
+}
+
+/**
+ * @brief:  Decode a JSON request body, unknown fields are rejected
+ *
+ * @param:  w - Response, limits the body
+ * @param:  r - Request
+ * @param:  dst - Where to decode the body
+ *
+ * @return: nil on success, else ErrBodyInvalid
+ **/
+func readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+    dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBody))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(dst); err != nil {
+        return ErrBodyInvalid
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Write a JSON response
+ *
+ * @param:  w - Response
+ * @param:  status - HTTP status
+ * @param:  body - Encoded as JSON
+ **/
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(body); err != nil {
+        log.Println("api: writing response:", err)
+    }
+}
+
+/**
+ * @brief:  Get the HTTP status of an error
+ *
+ * @param:  err - Error of a request
+ *
+ * @return: HTTP status
+ **/
+func errorStatus(err error) int {
+    switch err {
+    case models.ErrNotFound:
+        return http.StatusNotFound
+    case models.ErrLoginFailed, models.ErrSessionInvalid, ErrAuthRequired,
+        models.ErrTwoFactorRequired, models.ErrTwoFactorInvalid, models.ErrRecoveryFailed:
+        return http.StatusUnauthorized
+    case models.ErrLoginThrottled:
+        return http.StatusTooManyRequests
+    case models.ErrRemoteAccount, models.ErrShareReadOnly, models.ErrRoleForbidden,
+        models.ErrEmergencyWaiting, models.ErrEmergencyNoTakeover:
+        return http.StatusForbidden
+    case models.ErrUsernameTaken, models.ErrTwoFactorEnabled, models.ErrTwoFactorDisabled,
+        models.ErrShareChanged, models.ErrOrgNameTaken, models.ErrCollectionTaken, models.ErrMemberExists,
+        models.ErrLastOwner, models.ErrCollectionNotEmpty, models.ErrOrgRekeyed,
+        models.ErrEmergencyRequested, models.ErrEmergencyNotRequested:
+        return http.StatusConflict
+    }
+
+    return http.StatusBadRequest
+}
+
+/**
+ * @brief:  Write an error response. Errors with a public message (see
+ *          modelError.Public) are shown to the client, anything else is
+ *          logged and answered with a generic message.
+ *
+ * @param:  w - Response
+ * @param:  err - Error of the request
+ **/
+func writeError(w http.ResponseWriter, err error) {
+    if public, ok := err.(publicError); ok {
+        writeJSON(w, errorStatus(err), errorJSON{Error: public.Public()})
+        return
+    }
+
+    log.Println("api:", err)
+    writeJSON(w, http.StatusInternalServerError, errorJSON{Error: "Something went wrong, please try again"})
+}
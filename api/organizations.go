@@ -0,0 +1,438 @@
+package api
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gorilla/mux"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Organization of the user, with their role in it
+ **/
+type organizationJSON struct {
+    ID   uint   `json:"id"`
+    Name string `json:"name"`
+    Role string `json:"role"`
+}
+
+/**
+ * Member of an organization
+ **/
+type memberJSON struct {
+    Username string `json:"username"`
+    Role     string `json:"role"`
+}
+
+/**
+ * Body of PUT /api/orgs/{org}/members/{username}
+ **/
+type roleJSON struct {
+    Role string `json:"role"`
+}
+
+/**
+ * Collection of an organization, and the body of POST /api/orgs/{org}/collections
+ **/
+type collectionJSON struct {
+    ID   uint   `json:"id,omitempty"`
+    Name string `json:"name"`
+}
+
+/**
+ * Body of POST /api/orgs
+ **/
+type organizationNameJSON struct {
+    Name string `json:"name"`
+}
+
+/**
+ * Entry of an organization, with its collection
+ **/
+type orgEntryJSON struct {
+    entryJSON
+    CollectionID uint `json:"collection_id"`
+}
+
+/**
+ * @brief:  Open the organization of the request for the user
+ *
+ * @arg:    r - Request of a /orgs/{org} route
+ *
+ * @return: Organization with its key on success, ErrNotFound if the user
+ *          isn't a member, else error
+ **/
+func (s *Server) requestOrg(r *http.Request) (models.Organization, error) {
+    id, err := strconv.ParseUint(mux.Vars(r)["org"], 10, 32)
+    if err != nil || id == 0 {
+        return models.Organization{}, models.ErrIDInvalid
+    }
+
+    return models.OpenOrganization(s.db, uint(id), requestUser(r))
+}
+
+/**
+ * @brief:  Get an entry of an organization
+ *
+ * @arg:    entry - Entry, a summary or whole
+ * @arg:    vault - Entry of the organization
+ *
+ * @return: Entry with its collection
+ **/
+func newOrgEntryJSON(entry entryJSON, vault models.Vault) orgEntryJSON {
+    return orgEntryJSON{entryJSON: entry, CollectionID: vault.CollectionID}
+}
+
+/**
+ * GET /api/orgs
+ **/
+func (s *Server) listOrgs(w http.ResponseWriter, r *http.Request) {
+    orgs, err := models.Organizations(s.db, requestUser(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    body := make([]organizationJSON, len(orgs))
+    for i, org := range orgs {
+        body[i] = organizationJSON{ID: org.ID, Name: org.Name, Role: org.Role}
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * POST /api/orgs
+ *
+ * Creates an organization with the user as its owner
+ **/
+func (s *Server) createOrg(w http.ResponseWriter, r *http.Request) {
+    var form organizationNameJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    org, err := models.CreateOrganization(s.db, form.Name, requestUser(r), remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, organizationJSON{ID: org.ID, Name: org.Name, Role: org.Role})
+}
+
+/**
+ * GET /api/orgs/{org}/members
+ **/
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request) {
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    members, err := models.Members(s.db, org)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    body := make([]memberJSON, len(members))
+    for i, member := range members {
+        body[i] = memberJSON{Username: member.Username, Role: member.Role}
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * PUT /api/orgs/{org}/members/{username}
+ *
+ * Adds the user to the organization, or changes the role of a member
+ **/
+func (s *Server) putMember(w http.ResponseWriter, r *http.Request) {
+    var form roleJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user, username := requestUser(r), mux.Vars(r)["username"]
+    err = models.AddMember(s.db, org, user, username, form.Role, remoteAddr(r))
+    if err == models.ErrMemberExists {
+        err = models.SetMemberRole(s.db, org, user, username, form.Role, remoteAddr(r))
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * DELETE /api/orgs/{org}/members/{username}
+ *
+ * Removes the member, or has the user leave, and gives the organization a
+ * new key
+ **/
+func (s *Server) removeMember(w http.ResponseWriter, r *http.Request) {
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    err = models.RemoveMember(s.db, org, requestUser(r), mux.Vars(r)["username"], remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * GET /api/orgs/{org}/collections
+ **/
+func (s *Server) listCollections(w http.ResponseWriter, r *http.Request) {
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    collections, err := models.Collections(s.db, org)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    body := make([]collectionJSON, len(collections))
+    for i, collection := range collections {
+        body[i] = collectionJSON{ID: collection.ID, Name: collection.Name}
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * POST /api/orgs/{org}/collections
+ **/
+func (s *Server) createCollection(w http.ResponseWriter, r *http.Request) {
+    var form collectionJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    collection, err := models.CreateCollection(s.db, org, requestUser(r), form.Name, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, collectionJSON{ID: collection.ID, Name: collection.Name})
+}
+
+/**
+ * DELETE /api/orgs/{org}/collections/{id}
+ *
+ * Only empty collections can be deleted
+ **/
+func (s *Server) deleteCollection(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    if err := models.DeleteCollection(s.db, org, requestUser(r), id, remoteAddr(r)); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * GET /api/orgs/{org}/collections/{id}/entries
+ **/
+func (s *Server) listCollectionEntries(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vaults, err := models.CollectionEntries(s.db, org, id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    body := make([]orgEntryJSON, len(vaults))
+    for i, vault := range vaults {
+        body[i] = newOrgEntryJSON(newEntrySummary(vault), vault)
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * POST /api/orgs/{org}/collections/{id}/entries
+ **/
+func (s *Server) createOrgEntry(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    var entry entryJSON
+    if err := readJSON(w, r, &entry); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault := models.Vault{CollectionID: id}
+    entry.apply(&vault)
+    if err := models.CreateOrgEntry(s.db, org, requestUser(r), &vault, remoteAddr(r)); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err = models.OrgEntryByID(s.db, org, vault.ID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, newOrgEntryJSON(newEntryJSON(vault), vault))
+}
+
+/**
+ * GET /api/orgs/{org}/entries/{id}
+ **/
+func (s *Server) getOrgEntry(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err := models.OrgEntryByID(s.db, org, id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newOrgEntryJSON(newEntryJSON(vault), vault))
+}
+
+/**
+ * PUT /api/orgs/{org}/entries/{id}
+ *
+ * Replaces the entry with the one sent, it stays in its collection
+ **/
+func (s *Server) updateOrgEntry(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    var entry entryJSON
+    if err := readJSON(w, r, &entry); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err := models.OrgEntryByID(s.db, org, id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    entry.apply(&vault)
+    if err := models.UpdateOrgEntry(s.db, org, requestUser(r), &vault, remoteAddr(r)); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err = models.OrgEntryByID(s.db, org, id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newOrgEntryJSON(newEntryJSON(vault), vault))
+}
+
+/**
+ * DELETE /api/orgs/{org}/entries/{id}
+ **/
+func (s *Server) deleteOrgEntry(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    org, err := s.requestOrg(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    if err := models.DeleteOrgEntry(s.db, org, requestUser(r), id, remoteAddr(r)); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
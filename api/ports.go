@@ -0,0 +1,177 @@
+package api
+
+import (
+    "io/ioutil"
+    "net/http"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/loerac/vaultDepot/manager"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Largest file accepted by an import
+ **/
+const maxImportBody = 32 << 20
+
+/**
+ * Row of an import that failed
+ **/
+type rowErrorJSON struct {
+    Row   int    `json:"row"`
+    Error string `json:"error"`
+}
+
+/**
+ * Outcome of an import
+ **/
+type importJSON struct {
+    New       int            `json:"new"`
+    Changed   int            `json:"changed"`
+    Identical int            `json:"identical"`
+    Duplicate int            `json:"duplicate"`
+    Created   int            `json:"created"`
+    Updated   int            `json:"updated"`
+    DryRun    bool           `json:"dry_run,omitempty"`
+    Failed    []rowErrorJSON `json:"failed,omitempty"`
+    Error     string         `json:"error,omitempty"`
+}
+
+/**
+ * Body of an export request
+ **/
+type exportJSON struct {
+    /* Encrypts the export (see compat.SealArchive), unless plaintext */
+    Passphrase string `json:"passphrase"`
+
+    Plaintext  bool   `json:"plaintext"`
+}
+
+/**
+ * @brief:  Get the public message of an import row error
+ *
+ * @arg:    err - Error of the row
+ *
+ * @return: Message
+ **/
+func rowMessage(err error) string {
+    if public, ok := err.(publicError); ok {
+        return public.Public()
+    }
+
+    return err.Error()
+}
+
+/**
+ * POST /api/import?merge=&dry_run=&map=
+ *
+ * The body is a CSV or an encrypted export, decrypted with the passphrase in
+ * the X-Archive-Passphrase header. Nothing is imported if any row fails.
+ **/
+func (s *Server) importEntries(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    columnMap, err := manager.ParseColumnMap(query.Get("map"))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    strategy := manager.MergeSkip
+    if option := query.Get("merge"); option != "" {
+        if strategy, err = manager.ParseMergeStrategy(option); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+
+    options := manager.ImportOptions{
+        ColumnMap: columnMap,
+        Strategy: strategy,
+        DryRun: query.Get("dry_run") == "true",
+    }
+
+    data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBody))
+    if err != nil {
+        writeError(w, ErrBodyInvalid)
+        return
+    }
+
+    if compat.IsArchive(data) {
+        data, err = compat.OpenArchive(r.Header.Get("X-Archive-Passphrase"), data)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+
+    result, err := manager.ImportCSV(s.db, requestUser(r), data, options)
+    if err != nil && err != manager.ErrImportFailed {
+        writeError(w, err)
+        return
+    }
+
+    body := importJSON{
+        New: result.New,
+        Changed: result.Changed,
+        Identical: result.Identical,
+        Duplicate: result.Duplicate,
+        Created: result.Created,
+        Updated: result.Updated,
+        DryRun: options.DryRun,
+    }
+    for _, row := range result.Failed {
+        body.Failed = append(body.Failed, rowErrorJSON{Row: row.Line, Error: rowMessage(row.Err)})
+    }
+
+    status := http.StatusOK
+    if err == manager.ErrImportFailed {
+        status = http.StatusUnprocessableEntity
+        body.Error = manager.ErrImportFailed.Public()
+    }
+
+    writeJSON(w, status, body)
+}
+
+/**
+ * POST /api/export
+ *
+ * Answers with a passphrase encrypted export, or a CSV if plaintext is set
+ **/
+func (s *Server) exportEntries(w http.ResponseWriter, r *http.Request) {
+    var form exportJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+    if !form.Plaintext && len(form.Passphrase) < 8 {
+        writeError(w, manager.ErrPassphraseTooShort)
+        return
+    }
+
+    user := requestUser(r)
+    vaults, err := models.FindAll(s.db, user.ID)
+    if err != nil && err != models.ErrNotFound {
+        writeError(w, err)
+        return
+    }
+
+    data, err := manager.ExportCSV(vaults, user)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    filename, contentType := "vault.csv", "text/csv; charset=utf-8"
+    if !form.Plaintext {
+        data, err = compat.SealArchive(form.Passphrase, data)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        filename, contentType = "vault.vdx", "application/octet-stream"
+    }
+
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Content-Disposition", "attachment; filename=\"" + filename + "\"")
+    w.Write(data)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+    "net/http"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Recovery key and its printable emergency kit, or the shares it was split
+ * into, only shown when they're created
+ **/
+type recoveryKeyJSON struct {
+    RecoveryKey string      `json:"recovery_key,omitempty"`
+    Kit         string      `json:"kit,omitempty"`
+    Shares      []shareJSON `json:"shares,omitempty"`
+}
+
+/**
+ * Share of a recovery key and its printable emergency kit
+ **/
+type shareJSON struct {
+    Share string `json:"share"`
+    Kit   string `json:"kit"`
+}
+
+/**
+ * Optional body of POST /api/recovery-key, to split the key
+ **/
+type splitJSON struct {
+    Shares    int `json:"shares"`
+    Threshold int `json:"threshold"`
+}
+
+/**
+ * Body of POST /api/recover, with the recovery key or its shares
+ **/
+type recoverJSON struct {
+    Username       string    `json:"username"`
+    RecoveryKey    string    `json:"recovery_key"`
+    RecoveryShares []string  `json:"recovery_shares"`
+    Password       string    `json:"password"`
+    SecretKey      string    `json:"secret_key"`
+
+    /* Shares to split the new recovery key into */
+    Split          splitJSON `json:"split"`
+}
+
+/**
+ * @brief:  Check the counts of a split before a recovery key is replaced,
+ *          so the new key isn't lost to a bad request
+ *
+ * @arg:    split - Shares to split the key into, none to keep it whole
+ *
+ * @return: nil if they're fine, else compat.ErrSharesCount
+ **/
+func (split splitJSON) valid() error {
+    if split.Shares == 0 && split.Threshold == 0 {
+        return nil
+    }
+    if split.Threshold < 2 || split.Shares < split.Threshold || split.Shares > compat.MaxShares {
+        return compat.ErrSharesCount
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Show a new recovery key, whole or split into shares
+ *
+ * @arg:    username - Account of the key
+ * @arg:    words - Recovery words
+ * @arg:    split - Shares to split it into, none to keep it whole
+ *
+ * @return: Body of the response on success, else error
+ **/
+func newRecoveryKeyJSON(username string, words string, split splitJSON) (recoveryKeyJSON, error) {
+    if split.Shares == 0 && split.Threshold == 0 {
+        return recoveryKeyJSON{RecoveryKey: words, Kit: models.EmergencyKit(username, words)}, nil
+    }
+
+    shares, err := models.SplitRecoveryKey(words, split.Shares, split.Threshold)
+    if err != nil {
+        return recoveryKeyJSON{}, err
+    }
+
+    body := recoveryKeyJSON{Shares: make([]shareJSON, len(shares))}
+    for i, share := range shares {
+        kit, err := models.ShareKit(username, share, len(shares))
+        if err != nil {
+            return recoveryKeyJSON{}, err
+        }
+        body.Shares[i] = shareJSON{Share: share, Kit: kit}
+    }
+
+    return body, nil
+}
+
+/**
+ * POST /api/recovery-key
+ **/
+func (s *Server) createRecoveryKey(w http.ResponseWriter, r *http.Request) {
+    var split splitJSON
+    if r.ContentLength != 0 {
+        if err := readJSON(w, r, &split); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+
+    if err := split.valid(); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user := requestUser(r)
+    words, err := models.CreateRecoveryKey(s.db, &user, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    body, err := newRecoveryKeyJSON(user.Username, words, split)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, body)
+}
+
+/**
+ * POST /api/recover
+ **/
+func (s *Server) recoverAccount(w http.ResponseWriter, r *http.Request) {
+    var form recoverJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    if err := form.Split.valid(); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    words := form.RecoveryKey
+    if len(form.RecoveryShares) > 0 {
+        var err error
+        if words, err = models.CombineRecoveryShares(form.RecoveryShares); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
+
+    reset := models.User{
+        Password: form.Password,
+        SecretKey: form.SecretKey,
+    }
+    user, newWords, err := models.RecoverAccount(s.db, form.Username, words, reset, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    body, err := newRecoveryKeyJSON(user.Username, newWords, form.Split)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Body of remote signup and login requests, the auth hash is derived by the
+ * client (see compat.DeriveAccountKeys)
+ **/
+type remoteCredentialsJSON struct {
+    Username string `json:"username"`
+    AuthHash string `json:"auth_hash"`
+
+    /* Two-factor or recovery code, only for login to accounts with two-factor login */
+    OTP      string `json:"otp,omitempty"`
+}
+
+/**
+ * Entry sealed by a remote client, the cipher is base64 in JSON
+ **/
+type sealedJSON struct {
+    ID        uint      `json:"id,omitempty"`
+    Cipher    []byte    `json:"cipher"`
+    CreatedAt time.Time `json:"created_at,omitempty"`
+    UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+func newSealedJSON(entry models.RemoteEntry) sealedJSON {
+    return sealedJSON{
+        ID: entry.ID,
+        Cipher: entry.Cipher,
+        CreatedAt: entry.CreatedAt,
+        UpdatedAt: entry.UpdatedAt,
+    }
+}
+
+/**
+ * @brief:  Only let sessions of remote accounts through
+ *
+ * @arg:    next - Handler of the route
+ *
+ * @return: Handler
+ **/
+func (s *Server) requireRemote(next http.HandlerFunc) http.HandlerFunc {
+    return s.requireSession(func(w http.ResponseWriter, r *http.Request) {
+        if !requestUser(r).Remote {
+            writeError(w, ErrAuthRequired)
+            return
+        }
+
+        next(w, r)
+    })
+}
+
+/**
+ * POST /api/remote/signup
+ **/
+func (s *Server) remoteSignup(w http.ResponseWriter, r *http.Request) {
+    var form remoteCredentialsJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user := models.User{Username: strings.TrimSpace(form.Username), Password: form.AuthHash}
+    if user.Username == "" {
+        writeError(w, models.ErrUsernameRequired)
+        return
+    }
+    if _, err := models.ByUsername(s.db, user.Username); err == nil {
+        writeError(w, models.ErrUsernameTaken)
+        return
+    } else if err != models.ErrNotFound {
+        writeError(w, err)
+        return
+    }
+
+    if err := models.CreateRemoteUser(s.db, &user); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    s.startSession(w, r, user, http.StatusCreated)
+}
+
+/**
+ * POST /api/remote/login
+ **/
+func (s *Server) remoteLogin(w http.ResponseWriter, r *http.Request) {
+    var form remoteCredentialsJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user, err := models.AuthenticateRemote(s.db, strings.TrimSpace(form.Username), form.AuthHash, form.OTP, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    s.startSession(w, r, *user, http.StatusOK)
+}
+
+/**
+ * GET /api/remote/entries
+ **/
+func (s *Server) listSealed(w http.ResponseWriter, r *http.Request) {
+    entries, err := models.RemoteEntries(s.db, requestUser(r).ID)
+    if err != nil && err != models.ErrNotFound {
+        writeError(w, err)
+        return
+    }
+
+    body := make([]sealedJSON, len(entries))
+    for i, entry := range entries {
+        body[i] = newSealedJSON(entry)
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * POST /api/remote/entries
+ **/
+func (s *Server) createSealed(w http.ResponseWriter, r *http.Request) {
+    var form sealedJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    entry := models.RemoteEntry{UserID: requestUser(r).ID, Cipher: form.Cipher}
+    if err := models.SaveRemoteEntry(s.db, &entry); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, newSealedJSON(entry))
+}
+
+/**
+ * PUT /api/remote/entries/{id}
+ **/
+func (s *Server) updateSealed(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    var form sealedJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    entry := models.RemoteEntry{UserID: requestUser(r).ID, Cipher: form.Cipher}
+    entry.ID = id
+    if err := models.SaveRemoteEntry(s.db, &entry); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newSealedJSON(entry))
+}
+
+/**
+ * DELETE /api/remote/entries/{id}
+ **/
+func (s *Server) deleteSealed(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    if err := models.DeleteRemoteEntry(s.db, requestUser(r).ID, id); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+    "net/http"
+
+    "github.com/gorilla/mux"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * JSON API of the vault, every route but signup and login needs a bearer
+ * remember token (see models.CreateSession)
+ **/
+type Server struct {
+    db      *gorm.DB
+    router  *mux.Router
+}
+
+/**
+ * @brief:  Create the API server and its routes
+ *
+ * @arg:    db - Connection to the database
+ *
+ * @return: Server
+ **/
+func NewServer(db *gorm.DB) *Server {
+    s := &Server{db: db, router: mux.NewRouter()}
+
+    r := s.router.PathPrefix("/api").Subrouter()
+    r.HandleFunc("/signup", s.signup).Methods("POST")
+    r.HandleFunc("/login", s.login).Methods("POST")
+    r.HandleFunc("/logout", s.requireSession(s.logout)).Methods("POST")
+    r.HandleFunc("/sessions", s.requireSession(s.listSessions)).Methods("GET")
+    r.HandleFunc("/sessions/{id:[0-9]+}", s.requireSession(s.revokeSession)).Methods("DELETE")
+
+    r.HandleFunc("/2fa", s.requireSession(s.twoFactorStatus)).Methods("GET")
+    r.HandleFunc("/2fa", s.requireSession(s.beginTwoFactor)).Methods("POST")
+    r.HandleFunc("/2fa/confirm", s.requireSession(s.confirmTwoFactor)).Methods("POST")
+    r.HandleFunc("/2fa/recovery-codes", s.requireSession(s.regenerateRecoveryCodes)).Methods("POST")
+    r.HandleFunc("/2fa/disable", s.requireSession(s.disableTwoFactor)).Methods("POST")
+
+    r.HandleFunc("/recovery-key", s.requireUser(s.createRecoveryKey)).Methods("POST")
+    r.HandleFunc("/recover", s.recoverAccount).Methods("POST")
+
+    r.HandleFunc("/vault", s.requireUser(s.listEntries)).Methods("GET")
+    r.HandleFunc("/vault", s.requireUser(s.createEntry)).Methods("POST")
+    r.HandleFunc("/vault/match", s.requireUser(s.matchEntries)).Methods("GET")
+    r.HandleFunc("/vault/{id:[0-9]+}", s.requireUser(s.getEntry)).Methods("GET")
+    r.HandleFunc("/vault/{id:[0-9]+}", s.requireUser(s.updateEntry)).Methods("PUT")
+    r.HandleFunc("/vault/{id:[0-9]+}", s.requireUser(s.deleteEntry)).Methods("DELETE")
+    r.HandleFunc("/vault/{id:[0-9]+}/shares", s.requireUser(s.listShares)).Methods("GET")
+    r.HandleFunc("/vault/{id:[0-9]+}/shares/{username}", s.requireUser(s.shareEntry)).Methods("PUT")
+    r.HandleFunc("/vault/{id:[0-9]+}/shares/{username}", s.requireUser(s.revokeShare)).Methods("DELETE")
+
+    r.HandleFunc("/shared", s.requireUser(s.listShared)).Methods("GET")
+    r.HandleFunc("/shared/{id:[0-9]+}", s.requireUser(s.getShared)).Methods("GET")
+    r.HandleFunc("/shared/{id:[0-9]+}", s.requireUser(s.updateShared)).Methods("PUT")
+
+    r.HandleFunc("/orgs", s.requireUser(s.listOrgs)).Methods("GET")
+    r.HandleFunc("/orgs", s.requireUser(s.createOrg)).Methods("POST")
+    r.HandleFunc("/orgs/{org:[0-9]+}/members", s.requireUser(s.listMembers)).Methods("GET")
+    r.HandleFunc("/orgs/{org:[0-9]+}/members/{username}", s.requireUser(s.putMember)).Methods("PUT")
+    r.HandleFunc("/orgs/{org:[0-9]+}/members/{username}", s.requireUser(s.removeMember)).Methods("DELETE")
+    r.HandleFunc("/orgs/{org:[0-9]+}/collections", s.requireUser(s.listCollections)).Methods("GET")
+    r.HandleFunc("/orgs/{org:[0-9]+}/collections", s.requireUser(s.createCollection)).Methods("POST")
+    r.HandleFunc("/orgs/{org:[0-9]+}/collections/{id:[0-9]+}", s.requireUser(s.deleteCollection)).Methods("DELETE")
+    r.HandleFunc("/orgs/{org:[0-9]+}/collections/{id:[0-9]+}/entries", s.requireUser(s.listCollectionEntries)).Methods("GET")
+    r.HandleFunc("/orgs/{org:[0-9]+}/collections/{id:[0-9]+}/entries", s.requireUser(s.createOrgEntry)).Methods("POST")
+    r.HandleFunc("/orgs/{org:[0-9]+}/entries/{id:[0-9]+}", s.requireUser(s.getOrgEntry)).Methods("GET")
+    r.HandleFunc("/orgs/{org:[0-9]+}/entries/{id:[0-9]+}", s.requireUser(s.updateOrgEntry)).Methods("PUT")
+    r.HandleFunc("/orgs/{org:[0-9]+}/entries/{id:[0-9]+}", s.requireUser(s.deleteOrgEntry)).Methods("DELETE")
+
+    r.HandleFunc("/emergency/contacts", s.requireUser(s.listEmergencyContacts)).Methods("GET")
+    r.HandleFunc("/emergency/contacts/{username}", s.requireUser(s.putEmergencyContact)).Methods("PUT")
+    r.HandleFunc("/emergency/contacts/{username}", s.requireUser(s.removeEmergencyContact)).Methods("DELETE")
+    r.HandleFunc("/emergency/contacts/{username}/approve", s.requireUser(s.approveEmergency)).Methods("POST")
+    r.HandleFunc("/emergency/contacts/{username}/reject", s.requireUser(s.rejectEmergency)).Methods("POST")
+    r.HandleFunc("/emergency/trusted", s.requireUser(s.listTrustedBy)).Methods("GET")
+    r.HandleFunc("/emergency/trusted/{username}/request", s.requireUser(s.requestEmergency)).Methods("POST")
+    r.HandleFunc("/emergency/trusted/{username}/vault", s.requireUser(s.listEmergencyVault)).Methods("GET")
+    r.HandleFunc("/emergency/trusted/{username}/vault/{id:[0-9]+}", s.requireUser(s.getEmergencyEntry)).Methods("GET")
+    r.HandleFunc("/emergency/trusted/{username}/takeover", s.requireUser(s.takeOverAccount)).Methods("POST")
+
+    r.HandleFunc("/import", s.requireUser(s.importEntries)).Methods("POST")
+    r.HandleFunc("/export", s.requireUser(s.exportEntries)).Methods("POST")
+
+    r.HandleFunc("/remote/signup", s.remoteSignup).Methods("POST")
+    r.HandleFunc("/remote/login", s.remoteLogin).Methods("POST")
+    r.HandleFunc("/remote/entries", s.requireRemote(s.listSealed)).Methods("GET")
+    r.HandleFunc("/remote/entries", s.requireRemote(s.createSealed)).Methods("POST")
+    r.HandleFunc("/remote/entries/{id:[0-9]+}", s.requireRemote(s.updateSealed)).Methods("PUT")
+    r.HandleFunc("/remote/entries/{id:[0-9]+}", s.requireRemote(s.deleteSealed)).Methods("DELETE")
+
+    return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    s.router.ServeHTTP(w, r)
+}
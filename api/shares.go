@@ -0,0 +1,212 @@
+package api
+
+import (
+    "net/http"
+
+    "github.com/gorilla/mux"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * User an entry is shared with, or who shared it
+ **/
+type entryShareJSON struct {
+    Username   string `json:"username"`
+    Permission string `json:"permission"`
+}
+
+/**
+ * Body of PUT /api/vault/{id}/shares/{username}
+ **/
+type permissionJSON struct {
+    Permission string `json:"permission"`
+}
+
+/**
+ * Entry shared with the user, with who shared it
+ **/
+type sharedEntryJSON struct {
+    entryJSON
+    SharedBy entryShareJSON `json:"shared_by"`
+}
+
+/**
+ * @brief:  Get an entry shared with the user
+ *
+ * @arg:    entry - Entry, a summary or whole
+ * @arg:    vault - Entry opened through its share
+ *
+ * @return: Entry with who shared it
+ **/
+func newSharedEntryJSON(entry entryJSON, vault models.Vault) sharedEntryJSON {
+    return sharedEntryJSON{
+        entryJSON: entry,
+        SharedBy: entryShareJSON{Username: vault.Share.Username, Permission: vault.Share.Permission},
+    }
+}
+
+/**
+ * @brief:  Find the entry of the request, owned by the user
+ *
+ * @arg:    r - Request of a /vault/{id}/shares route
+ *
+ * @return: Decrypted entry on success, else error
+ **/
+func (s *Server) ownedEntry(r *http.Request) (models.Vault, error) {
+    id, err := entryID(r)
+    if err != nil {
+        return models.Vault{}, err
+    }
+
+    return models.ByID(s.db, id, requestUser(r))
+}
+
+/**
+ * GET /api/vault/{id}/shares
+ **/
+func (s *Server) listShares(w http.ResponseWriter, r *http.Request) {
+    vault, err := s.ownedEntry(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    shares, err := models.EntryShares(s.db, vault)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    body := make([]entryShareJSON, len(shares))
+    for i, share := range shares {
+        body[i] = entryShareJSON{Username: share.Username, Permission: share.Permission}
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * PUT /api/vault/{id}/shares/{username}
+ *
+ * Shares the entry, or changes the permission of its share
+ **/
+func (s *Server) shareEntry(w http.ResponseWriter, r *http.Request) {
+    var form permissionJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err := s.ownedEntry(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    err = models.ShareEntry(s.db, vault, requestUser(r), mux.Vars(r)["username"], form.Permission, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * DELETE /api/vault/{id}/shares/{username}
+ *
+ * Stops sharing the entry and gives it a new item key
+ **/
+func (s *Server) revokeShare(w http.ResponseWriter, r *http.Request) {
+    vault, err := s.ownedEntry(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    err = models.RevokeShare(s.db, vault, requestUser(r), mux.Vars(r)["username"], remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * GET /api/shared
+ **/
+func (s *Server) listShared(w http.ResponseWriter, r *http.Request) {
+    vaults, err := models.SharedEntries(s.db, requestUser(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    body := make([]sharedEntryJSON, len(vaults))
+    for i, vault := range vaults {
+        body[i] = newSharedEntryJSON(newEntrySummary(vault), vault)
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * GET /api/shared/{id}
+ **/
+func (s *Server) getShared(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err := models.SharedByID(s.db, id, requestUser(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newSharedEntryJSON(newEntryJSON(vault), vault))
+}
+
+/**
+ * PUT /api/shared/{id}
+ *
+ * Replaces an entry shared read-write with the one sent, its folder stays
+ * the owner's
+ **/
+func (s *Server) updateShared(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    var entry entryJSON
+    if err := readJSON(w, r, &entry); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user := requestUser(r)
+    vault, err := models.SharedByID(s.db, id, user)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    entry.apply(&vault)
+    if err := models.UpdateSharedEntry(s.db, &vault, user); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err = models.SharedByID(s.db, id, user)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newSharedEntryJSON(newEntryJSON(vault), vault))
+}
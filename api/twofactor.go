@@ -0,0 +1,111 @@
+package api
+
+import (
+    "net/http"
+
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Whether an account has two-factor login
+ **/
+type twoFactorJSON struct {
+    Enabled bool   `json:"enabled"`
+
+    /* otpauth URI of the secret being enrolled, only when it's created */
+    URI     string `json:"uri,omitempty"`
+}
+
+/**
+ * Body of requests that need a two-factor or recovery code
+ **/
+type codeJSON struct {
+    Code string `json:"code"`
+}
+
+/**
+ * Recovery codes, only shown when they're created
+ **/
+type recoveryCodesJSON struct {
+    RecoveryCodes []string `json:"recovery_codes"`
+}
+
+/**
+ * GET /api/2fa
+ **/
+func (s *Server) twoFactorStatus(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, twoFactorJSON{Enabled: requestUser(r).TwoFactorEnabled()})
+}
+
+/**
+ * POST /api/2fa
+ **/
+func (s *Server) beginTwoFactor(w http.ResponseWriter, r *http.Request) {
+    user := requestUser(r)
+    uri, err := models.BeginTwoFactor(s.db, &user)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, twoFactorJSON{URI: uri})
+}
+
+/**
+ * POST /api/2fa/confirm
+ **/
+func (s *Server) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+    var form codeJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user := requestUser(r)
+    codes, err := models.ConfirmTwoFactor(s.db, &user, form.Code, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, recoveryCodesJSON{RecoveryCodes: codes})
+}
+
+/**
+ * POST /api/2fa/recovery-codes
+ **/
+func (s *Server) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+    var form codeJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user := requestUser(r)
+    codes, err := models.RegenerateRecoveryCodes(s.db, &user, form.Code, remoteAddr(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, recoveryCodesJSON{RecoveryCodes: codes})
+}
+
+/**
+ * POST /api/2fa/disable
+ **/
+func (s *Server) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+    var form codeJSON
+    if err := readJSON(w, r, &form); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user := requestUser(r)
+    if err := models.DisableTwoFactor(s.db, &user, form.Code, remoteAddr(r)); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
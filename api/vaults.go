@@ -0,0 +1,319 @@
+package api
+
+import (
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Custom field of an entry
+ **/
+type fieldJSON struct {
+    Name   string `json:"name"`
+    Value  string `json:"value"`
+    Hidden bool   `json:"hidden,omitempty"`
+}
+
+/**
+ * Vault entry as sent and received by the client. Lists only hold the
+ * metadata, the secrets are sent when a single entry is read.
+ **/
+type entryJSON struct {
+    ID          uint              `json:"id,omitempty"`
+    Type        string            `json:"type"`
+    Application string            `json:"application"`
+    Email       string            `json:"email,omitempty"`
+    Username    string            `json:"username,omitempty"`
+    Password    string            `json:"password,omitempty"`
+    URIs        []models.URI      `json:"uris,omitempty"`
+    Folder      string            `json:"folder,omitempty"`
+    Tags        []string          `json:"tags,omitempty"`
+    Notes       string            `json:"notes,omitempty"`
+    TOTP        string            `json:"totp,omitempty"`
+    Fields      map[string]string `json:"fields,omitempty"`
+    Custom      []fieldJSON       `json:"custom,omitempty"`
+    CreatedAt   time.Time         `json:"created_at,omitempty"`
+    UpdatedAt   time.Time         `json:"updated_at,omitempty"`
+}
+
+/**
+ * @brief:  Get the metadata of an entry, to list it
+ *
+ * @arg:    vault - Vault with decrypted metadata
+ *
+ * @return: Entry without secrets
+ **/
+func newEntrySummary(vault models.Vault) entryJSON {
+    return entryJSON{
+        ID: vault.ID,
+        Type: vault.ItemType(),
+        Application: vault.Application,
+        Email: vault.Email,
+        Username: vault.Username,
+        URIs: vault.URIs,
+        Folder: vault.Folder,
+        Tags: vault.Tags,
+        CreatedAt: vault.CreatedAt,
+        UpdatedAt: vault.UpdatedAt,
+    }
+}
+
+/**
+ * @brief:  Get a whole entry
+ *
+ * @arg:    vault - Decrypted vault
+ *
+ * @return: Entry with its secrets
+ **/
+func newEntryJSON(vault models.Vault) entryJSON {
+    entry := newEntrySummary(vault)
+    entry.Password = vault.Password
+    entry.Notes = vault.Notes
+    entry.TOTP = vault.TOTP
+    entry.Fields = vault.Payload
+    for _, field := range vault.Fields {
+        entry.Custom = append(entry.Custom, fieldJSON{Name: field.Name, Value: field.Value, Hidden: field.Hidden})
+    }
+
+    return entry
+}
+
+/**
+ * @brief:  Copy what the client sent onto a vault, validation is left to
+ *          models.ValidateVaultEntry
+ *
+ * @arg:    vault - Vault to fill
+ **/
+func (entry entryJSON) apply(vault *models.Vault) {
+    vault.Type = entry.Type
+    vault.Application = entry.Application
+    vault.Email = entry.Email
+    vault.Username = entry.Username
+    vault.Password = entry.Password
+    vault.URIs = entry.URIs
+    vault.Folder = entry.Folder
+    vault.Tags = entry.Tags
+    vault.Notes = entry.Notes
+    vault.TOTP = entry.TOTP
+    vault.Payload = entry.Fields
+
+    vault.Fields = nil
+    for _, field := range entry.Custom {
+        vault.Fields = append(vault.Fields, models.Field{Name: field.Name, Value: field.Value, Hidden: field.Hidden})
+    }
+}
+
+/**
+ * @brief:  Get the entry ID in the request path
+ *
+ * @arg:    r - Request of a /vault/{id} route
+ *
+ * @return: ID on success, else ErrIDInvalid
+ **/
+func entryID(r *http.Request) (uint, error) {
+    id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+    if err != nil || id == 0 {
+        return 0, models.ErrIDInvalid
+    }
+
+    return uint(id), nil
+}
+
+/**
+ * @brief:  Find the user's entries with their metadata decrypted, sorted by
+ *          folder and name
+ *
+ * @arg:    r - Authenticated request
+ *
+ * @return: Entries on success, else error
+ **/
+func (s *Server) findEntries(r *http.Request) ([]models.Vault, error) {
+    user := requestUser(r)
+    vaults, err := models.FindAll(s.db, user.ID)
+    if err != nil && err != models.ErrNotFound {
+        return nil, err
+    }
+
+    if err := models.DecryptMetadata(vaults, user); err != nil {
+        return nil, err
+    }
+    models.SortVaults(vaults)
+
+    return vaults, nil
+}
+
+/**
+ * @brief:  Answer with the metadata of entries
+ *
+ * @arg:    w - Response
+ * @arg:    vaults - Vaults with decrypted metadata
+ **/
+func writeEntries(w http.ResponseWriter, vaults []models.Vault) {
+    body := make([]entryJSON, len(vaults))
+    for i, vault := range vaults {
+        body[i] = newEntrySummary(vault)
+    }
+
+    writeJSON(w, http.StatusOK, body)
+}
+
+/**
+ * GET /api/vault?folder=&tag=&q=
+ *
+ * q looks up an exact application or email (see models.FindExact)
+ **/
+func (s *Server) listEntries(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    filter := models.VaultFilter{Folder: query.Get("folder"), Tag: query.Get("tag")}
+
+    var vaults []models.Vault
+    var err error
+    if term := query.Get("q"); term != "" {
+        vaults, err = models.FindExact(s.db, requestUser(r), term)
+        if err == models.ErrNotFound {
+            vaults, err = nil, nil
+        }
+    } else {
+        vaults, err = s.findEntries(r)
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeEntries(w, models.FilterVaults(vaults, filter))
+}
+
+/**
+ * GET /api/vault/match?url=
+ **/
+func (s *Server) matchEntries(w http.ResponseWriter, r *http.Request) {
+    vaults, err := s.findEntries(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    matched, err := models.MatchURL(vaults, r.URL.Query().Get("url"))
+    if err != nil && err != models.ErrNotFound {
+        writeError(w, err)
+        return
+    }
+
+    writeEntries(w, matched)
+}
+
+/**
+ * GET /api/vault/{id}
+ **/
+func (s *Server) getEntry(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err := models.ByID(s.db, id, requestUser(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newEntryJSON(vault))
+}
+
+/**
+ * POST /api/vault
+ **/
+func (s *Server) createEntry(w http.ResponseWriter, r *http.Request) {
+    var entry entryJSON
+    if err := readJSON(w, r, &entry); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user := requestUser(r)
+    vault := models.Vault{UserID: user.ID}
+    entry.apply(&vault)
+    if err := models.CreateVaultEntry(s.db, &vault, user); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err := models.ByID(s.db, vault.ID, user)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, newEntryJSON(vault))
+}
+
+/**
+ * PUT /api/vault/{id}
+ *
+ * Replaces the entry with the one sent
+ **/
+func (s *Server) updateEntry(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    var entry entryJSON
+    if err := readJSON(w, r, &entry); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    user := requestUser(r)
+    vault, err := models.ByID(s.db, id, user)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    entry.apply(&vault)
+    if err := models.UpdateVaultEntry(s.db, &vault, user); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    vault, err = models.ByID(s.db, id, user)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, newEntryJSON(vault))
+}
+
+/**
+ * DELETE /api/vault/{id}
+ **/
+func (s *Server) deleteEntry(w http.ResponseWriter, r *http.Request) {
+    id, err := entryID(r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    /* Only the owner may delete it */
+    vault, err := models.ByID(s.db, id, requestUser(r))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    if err := models.DeleteID(s.db, vault.ID); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,269 @@
+package client
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/loerac/vaultDepot/compat"
+)
+
+/**
+ * Largest response read from the server
+ **/
+const maxResponse = 64 << 20
+
+/**
+ * Client of a vaultDepot server (see api.NewServer). Entries are sealed and
+ * opened here, the server only stores ciphertext and is sent an auth hash
+ * instead of the password and secret key.
+ **/
+type Client struct {
+    server  *url.URL
+    http    *http.Client
+
+    /* Remember token of the session, set by Login and Signup */
+    token   string
+
+    /* Seals the entries, derived from the credentials */
+    aes     compat.AES
+
+    /* Login waiting for a two-factor code, see SecondFactor */
+    pending *pendingLogin
+}
+
+/**
+ * Body of remote signup and login requests
+ **/
+type credentialsJSON struct {
+    Username string `json:"username"`
+    AuthHash string `json:"auth_hash"`
+    OTP      string `json:"otp,omitempty"`
+}
+
+/**
+ * Signup or login with the keys already derived, kept so a two-factor code
+ * doesn't mean deriving them again
+ **/
+type pendingLogin struct {
+    path    string
+    form    credentialsJSON
+    encryptionKey string
+}
+
+/**
+ * Session the server answers a login with
+ **/
+type sessionJSON struct {
+    Token string `json:"token"`
+}
+
+/**
+ * Error the server answers with
+ **/
+type errorJSON struct {
+    Error string `json:"error"`
+}
+
+/**
+ * @brief:  Create a client of a server. Credentials are only sent over
+ *          HTTPS, or plain HTTP to this machine.
+ *
+ * @arg:    server - URL of the server, e.g. "https://vault.example.com"
+ *
+ * @return: Client on success, else ErrInsecureServer
+ **/
+func New(server string) (*Client, error) {
+    base, err := url.Parse(strings.TrimRight(strings.TrimSpace(server), "/"))
+    if err != nil || base.Host == "" {
+        return nil, ErrInsecureServer
+    }
+
+    switch base.Scheme {
+    case "https":
+    case "http":
+        if !isLoopback(base.Hostname()) {
+            return nil, ErrInsecureServer
+        }
+    default:
+        return nil, ErrInsecureServer
+    }
+
+    return &Client{server: base, http: &http.Client{Timeout: 60 * time.Second}}, nil
+}
+
+/**
+ * @brief:  Check if a host is this machine
+ *
+ * @arg:    host - Host name or IP
+ *
+ * @return: true for localhost and loopback IPs, else false
+ **/
+func isLoopback(host string) bool {
+    if host == "localhost" {
+        return true
+    }
+
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+/**
+ * @brief:  Create an account on the server and log into it
+ *
+ * @arg:    username - Username of the account
+ * @arg:    password - Password, never sent
+ * @arg:    secretKey - Secret key, never sent
+ *
+ * @return: nil on success, else error
+ **/
+func (c *Client) Signup(username, password, secretKey string) error {
+    return c.authenticate("/api/remote/signup", username, password, secretKey)
+}
+
+/**
+ * @brief:  Log into an account on the server
+ *
+ * @arg:    username - Username of the account
+ * @arg:    password - Password, never sent
+ * @arg:    secretKey - Secret key, never sent
+ *
+ * @return: nil on success, models.ErrTwoFactorRequired if the account
+ *          has two-factor login, else error
+ **/
+func (c *Client) Login(username, password, secretKey string) error {
+    return c.authenticate("/api/remote/login", username, password, secretKey)
+}
+
+/**
+ * @brief:  Finish a login that answered models.ErrTwoFactorRequired
+ *
+ * @arg:    code - Two-factor or recovery code
+ *
+ * @return: nil on success, models.ErrTwoFactorInvalid if the code is
+ *          wrong (it can be tried again), else error
+ **/
+func (c *Client) SecondFactor(code string) error {
+    if c.pending == nil {
+        return ErrLoginRequired
+    }
+
+    c.pending.form.OTP = code
+    return c.startSession()
+}
+
+/**
+ * @brief:  Derive the account keys and start a session with the auth hash
+ *
+ * @arg:    path - Signup or login route
+ * @arg:    username - Username of the account
+ * @arg:    password - Password of the account
+ * @arg:    secretKey - Secret key of the account
+ *
+ * @return: nil on success, else error
+ **/
+func (c *Client) authenticate(path, username, password, secretKey string) error {
+    keys := compat.DeriveAccountKeys(username, password, secretKey)
+
+    c.pending = &pendingLogin{
+        path: path,
+        form: credentialsJSON{Username: strings.TrimSpace(username), AuthHash: keys.AuthHash},
+        encryptionKey: keys.EncryptionKey,
+    }
+    return c.startSession()
+}
+
+/**
+ * @brief:  Send the pending signup or login and keep its session
+ *
+ * @return: nil on success, models.ErrTwoFactorRequired if the server asks
+ *          for a code, else error
+ **/
+func (c *Client) startSession() error {
+    var session sessionJSON
+    if err := c.do("POST", c.pending.path, c.pending.form, &session); err != nil {
+        return knownError(err)
+    }
+
+    c.token = session.Token
+    c.aes = compat.NewAES(c.pending.encryptionKey)
+    c.pending = nil
+
+    return nil
+}
+
+/**
+ * @brief:  End the session on the server
+ *
+ * @return: nil on success, else error
+ **/
+func (c *Client) Logout() error {
+    if c.token == "" {
+        return nil
+    }
+
+    err := c.do("POST", "/api/logout", nil, nil)
+    c.token = ""
+
+    return err
+}
+
+/**
+ * @brief:  Send a request to the server
+ *
+ * @arg:    method - HTTP method
+ * @arg:    path - Route, e.g. "/api/remote/entries"
+ * @arg:    body - Sent as JSON, unless nil
+ * @arg:    dst - Where to decode the JSON response, unless nil
+ *
+ * @return: nil on success, the server's error if it answered with one,
+ *          else error
+ **/
+func (c *Client) do(method, path string, body interface{}, dst interface{}) error {
+    var reader io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reader = bytes.NewReader(data)
+    }
+
+    req, err := http.NewRequest(method, c.server.String() + path, reader)
+    if err != nil {
+        return err
+    }
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if c.token != "" {
+        req.Header.Set("Authorization", "Bearer " + c.token)
+    }
+
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    decoder := json.NewDecoder(io.LimitReader(resp.Body, maxResponse))
+    if resp.StatusCode >= 300 {
+        var answer errorJSON
+        if decoder.Decode(&answer) != nil || answer.Error == "" {
+            answer.Error = strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+        }
+        return serverError(answer.Error)
+    }
+
+    if dst == nil {
+        return nil
+    }
+
+    return decoder.Decode(dst)
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+    "encoding/json"
+    "strconv"
+    "time"
+
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Entry as stored on the server, the cipher is a sealed plainEntry
+ **/
+type sealedJSON struct {
+    ID        uint      `json:"id,omitempty"`
+    Cipher    []byte    `json:"cipher"`
+    CreatedAt time.Time `json:"created_at,omitempty"`
+    UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+/**
+ * Custom field of an entry
+ **/
+type plainField struct {
+    Name   string `json:"name"`
+    Value  string `json:"value"`
+    Hidden bool   `json:"hidden,omitempty"`
+}
+
+/**
+ * Everything about an entry, sealed as a whole so the server doesn't learn
+ * its type, folder or anything else
+ **/
+type plainEntry struct {
+    Type        string            `json:"type"`
+    Application string            `json:"application"`
+    Email       string            `json:"email,omitempty"`
+    Username    string            `json:"username,omitempty"`
+    Password    string            `json:"password,omitempty"`
+    URIs        []models.URI      `json:"uris,omitempty"`
+    Folder      string            `json:"folder,omitempty"`
+    Tags        []string          `json:"tags,omitempty"`
+    Notes       string            `json:"notes,omitempty"`
+    TOTP        string            `json:"totp,omitempty"`
+    Fields      map[string]string `json:"fields,omitempty"`
+    Custom      []plainField      `json:"custom,omitempty"`
+}
+
+/**
+ * @brief:  Seal a normalized entry with the encryption key
+ *
+ * @arg:    vault - Entry to seal
+ *
+ * @return: Cipher on success, else error
+ **/
+func (c *Client) seal(vault models.Vault) ([]byte, error) {
+    entry := plainEntry{
+        Type: vault.ItemType(),
+        Application: vault.Application,
+        Email: vault.Email,
+        Username: vault.Username,
+        Password: vault.Password,
+        URIs: vault.URIs,
+        Folder: vault.Folder,
+        Tags: vault.Tags,
+        Notes: vault.Notes,
+        TOTP: vault.TOTP,
+        Fields: vault.Payload,
+    }
+    for _, field := range vault.Fields {
+        entry.Custom = append(entry.Custom, plainField{Name: field.Name, Value: field.Value, Hidden: field.Hidden})
+    }
+
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return nil, err
+    }
+
+    return c.aes.Encrypt(string(data))
+}
+
+/**
+ * @brief:  Open an entry sealed by seal
+ *
+ * @arg:    sealed - Entry from the server
+ *
+ * @return: Decrypted vault on success, else ErrSealedInvalid
+ **/
+func (c *Client) open(sealed sealedJSON) (models.Vault, error) {
+    data, err := c.aes.Decrypt(sealed.Cipher)
+    if err != nil {
+        return models.Vault{}, ErrSealedInvalid
+    }
+
+    var entry plainEntry
+    if err := json.Unmarshal([]byte(data), &entry); err != nil {
+        return models.Vault{}, ErrSealedInvalid
+    }
+
+    vault := models.Vault{
+        Type: entry.Type,
+        Application: entry.Application,
+        Email: entry.Email,
+        Username: entry.Username,
+        Password: entry.Password,
+        URIs: entry.URIs,
+        Folder: entry.Folder,
+        Tags: entry.Tags,
+        Notes: entry.Notes,
+        TOTP: entry.TOTP,
+        Payload: entry.Fields,
+    }
+    for _, field := range entry.Custom {
+        vault.Fields = append(vault.Fields, models.Field{Name: field.Name, Value: field.Value, Hidden: field.Hidden})
+    }
+    vault.ID = sealed.ID
+    vault.CreatedAt = sealed.CreatedAt
+    vault.UpdatedAt = sealed.UpdatedAt
+
+    return vault, nil
+}
+
+/**
+ * @brief:  Download and open every entry of the account
+ *
+ * @return: Decrypted entries sorted by folder and name on success, else
+ *          error
+ **/
+func (c *Client) Entries() ([]models.Vault, error) {
+    if c.token == "" {
+        return nil, ErrLoginRequired
+    }
+
+    var sealed []sealedJSON
+    if err := c.do("GET", "/api/remote/entries", nil, &sealed); err != nil {
+        return nil, err
+    }
+
+    vaults := make([]models.Vault, len(sealed))
+    for i := range sealed {
+        vault, err := c.open(sealed[i])
+        if err != nil {
+            return nil, err
+        }
+        vaults[i] = vault
+    }
+    models.SortVaults(vaults)
+
+    return vaults, nil
+}
+
+/**
+ * @brief:  Validate, seal and upload an entry. Entries without an ID are
+ *          added, others replaced.
+ *
+ * @arg:    vault - Entry to save, its ID and times are set on success
+ *
+ * @return: nil on success, else error
+ **/
+func (c *Client) Save(vault *models.Vault) error {
+    if c.token == "" {
+        return ErrLoginRequired
+    }
+
+    if err := models.NormalizeVaultEntry(vault); err != nil {
+        return err
+    }
+
+    cipher, err := c.seal(*vault)
+    if err != nil {
+        return err
+    }
+
+    var saved sealedJSON
+    if vault.ID == 0 {
+        err = c.do("POST", "/api/remote/entries", sealedJSON{Cipher: cipher}, &saved)
+    } else {
+        err = c.do("PUT", "/api/remote/entries/" + strconv.FormatUint(uint64(vault.ID), 10), sealedJSON{Cipher: cipher}, &saved)
+    }
+    if err != nil {
+        return err
+    }
+
+    vault.ID = saved.ID
+    vault.CreatedAt = saved.CreatedAt
+    vault.UpdatedAt = saved.UpdatedAt
+
+    return nil
+}
+
+/**
+ * @brief:  Delete an entry
+ *
+ * @arg:    id - ID of the entry
+ *
+ * @return: nil on success, else error
+ **/
+func (c *Client) Delete(id uint) error {
+    if c.token == "" {
+        return ErrLoginRequired
+    }
+
+    return c.do("DELETE", "/api/remote/entries/" + strconv.FormatUint(uint64(id), 10), nil, nil)
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+    "strings"
+
+    "github.com/loerac/vaultDepot/models"
+)
+
+type clientError string
+
+var (
+    /* Return when the server URL would send credentials in the clear */
+    ErrInsecureServer clientError = "client: Server must be an https:// URL, http:// is only allowed for localhost"
+
+    /* Return when a request needs a session and the client isn't logged in */
+    ErrLoginRequired clientError = "client: Log in to continue"
+
+    /* Return when a sealed entry can't be opened with the encryption key */
+    ErrSealedInvalid clientError = "client: Entry could not be decrypted, was it sealed with other credentials?"
+)
+
+func (err clientError) Error() string {
+    return string(err)
+}
+
+func (err clientError) Public() string {
+    str := strings.Replace(string(err), "client: ", "", 1)
+    return str
+}
+
+/**
+ * Error answered by the server, with its message
+ **/
+type serverError string
+
+func (err serverError) Error() string {
+    return "client: Server: " + string(err)
+}
+
+func (err serverError) Public() string {
+    return string(err)
+}
+
+/**
+ * Model errors the server answers with that callers act on
+ **/
+var knownErrors = []publicError{
+    models.ErrTwoFactorRequired,
+    models.ErrTwoFactorInvalid,
+}
+
+/**
+ * Errors with a message that can be shown as is
+ **/
+type publicError interface {
+    error
+    Public() string
+}
+
+/**
+ * @brief:  Turn an error of the server back into the model error it was
+ *          made from, so callers can compare it
+ *
+ * @arg:    err - Error of a request
+ *
+ * @return: Model error if it's a known one, else err
+ **/
+func knownError(err error) error {
+    answer, ok := err.(serverError)
+    if !ok {
+        return err
+    }
+
+    for _, known := range knownErrors {
+        if string(answer) == known.Public() {
+            return known
+        }
+    }
+
+    return err
+}
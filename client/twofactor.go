@@ -0,0 +1,114 @@
+package client
+
+/**
+ * Whether the account has two-factor login, and the secret being enrolled
+ **/
+type twoFactorJSON struct {
+    Enabled bool   `json:"enabled"`
+    URI     string `json:"uri,omitempty"`
+}
+
+/**
+ * Body of requests that need a two-factor or recovery code
+ **/
+type codeJSON struct {
+    Code string `json:"code"`
+}
+
+type recoveryCodesJSON struct {
+    RecoveryCodes []string `json:"recovery_codes"`
+}
+
+/**
+ * @brief:  Check if the account has two-factor login
+ *
+ * @return: true if it's on, else false, and nil on success, else error
+ **/
+func (c *Client) TwoFactorEnabled() (bool, error) {
+    if c.token == "" {
+        return false, ErrLoginRequired
+    }
+
+    var status twoFactorJSON
+    if err := c.do("GET", "/api/2fa", nil, &status); err != nil {
+        return false, err
+    }
+
+    return status.Enabled, nil
+}
+
+/**
+ * @brief:  Start enrolling the account in two-factor login
+ *
+ * @return: otpauth URI of the new secret on success, else error
+ **/
+func (c *Client) BeginTwoFactor() (string, error) {
+    if c.token == "" {
+        return "", ErrLoginRequired
+    }
+
+    var status twoFactorJSON
+    if err := c.do("POST", "/api/2fa", nil, &status); err != nil {
+        return "", err
+    }
+
+    return status.URI, nil
+}
+
+/**
+ * @brief:  Turn two-factor login on with a code of the enrolled secret
+ *
+ * @arg:    code - Code shown by the authenticator app
+ *
+ * @return: Recovery codes on success, else error
+ **/
+func (c *Client) ConfirmTwoFactor(code string) ([]string, error) {
+    return c.recoveryCodes("/api/2fa/confirm", code)
+}
+
+/**
+ * @brief:  Replace the recovery codes of the account
+ *
+ * @arg:    code - Two-factor or recovery code
+ *
+ * @return: New recovery codes on success, else error
+ **/
+func (c *Client) RegenerateRecoveryCodes(code string) ([]string, error) {
+    return c.recoveryCodes("/api/2fa/recovery-codes", code)
+}
+
+/**
+ * @brief:  Send a code to a route answering with recovery codes
+ *
+ * @arg:    path - Route
+ * @arg:    code - Two-factor or recovery code
+ *
+ * @return: Recovery codes on success, else error
+ **/
+func (c *Client) recoveryCodes(path string, code string) ([]string, error) {
+    if c.token == "" {
+        return nil, ErrLoginRequired
+    }
+
+    var codes recoveryCodesJSON
+    if err := c.do("POST", path, codeJSON{Code: code}, &codes); err != nil {
+        return nil, knownError(err)
+    }
+
+    return codes.RecoveryCodes, nil
+}
+
+/**
+ * @brief:  Turn two-factor login off
+ *
+ * @arg:    code - Two-factor or recovery code
+ *
+ * @return: nil on success, else error
+ **/
+func (c *Client) DisableTwoFactor(code string) error {
+    if c.token == "" {
+        return ErrLoginRequired
+    }
+
+    return knownError(c.do("POST", "/api/2fa/disable", codeJSON{Code: code}, nil))
+}
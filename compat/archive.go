@@ -0,0 +1,123 @@
+package compat
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/binary"
+    "io"
+
+    "golang.org/x/crypto/argon2"
+    "golang.org/x/crypto/chacha20poly1305"
+)
+
+/**
+ * Archive layout, all integers big endian:
+ *
+ *  magic (8) | version (1) | argon2id time (4) | memory KiB (4) |
+ *  threads (1) | salt (16) | nonce (24) | XChaCha20-Poly1305 ciphertext
+ *
+ * The header is authenticated as additional data.
+ **/
+const (
+    archiveMagic   = "VDARCHIV"
+    archiveVersion = 1
+    archiveSaltLen = 16
+
+    archiveTime    = 3
+    archiveMemory  = 64 * 1024
+    archiveThreads = 4
+
+    /* Refuse archives asking for more than this, it's not worth the DoS */
+    archiveMaxTime   = 16
+    archiveMaxMemory = 1024 * 1024
+
+    archiveHeaderLen = len(archiveMagic) + 1 + 4 + 4 + 1 + archiveSaltLen + chacha20poly1305.NonceSizeX
+)
+
+/**
+ * @brief:  Check if data is an archive made by SealArchive
+ *
+ * @param:  data - Data to check
+ *
+ * @return: true if it starts like an archive, else false
+ **/
+func IsArchive(data []byte) bool {
+    return bytes.HasPrefix(data, []byte(archiveMagic))
+}
+
+/**
+ * @brief:  Encrypt data with a passphrase. The key is derived with argon2id
+ *          and the data sealed with XChaCha20-Poly1305.
+ *
+ * @param:  passphrase - Passphrase to derive the key from
+ * @param:  data - Data to encrypt
+ *
+ * @return: Archive on success, else error
+ **/
+func SealArchive(passphrase string, data []byte) ([]byte, error) {
+    header := make([]byte, archiveHeaderLen)
+    copy(header, archiveMagic)
+    off := len(archiveMagic)
+    header[off] = archiveVersion
+    binary.BigEndian.PutUint32(header[off + 1:], archiveTime)
+    binary.BigEndian.PutUint32(header[off + 5:], archiveMemory)
+    header[off + 9] = archiveThreads
+
+    /* Salt and nonce are the tail of the header */
+    if _, err := io.ReadFull(rand.Reader, header[off + 10:]); err != nil {
+        return nil, err
+    }
+    salt := header[off + 10:off + 10 + archiveSaltLen]
+    nonce := header[off + 10 + archiveSaltLen:]
+
+    key := argon2.IDKey([]byte(passphrase), salt, archiveTime, archiveMemory, archiveThreads, chacha20poly1305.KeySize)
+    aead, err := chacha20poly1305.NewX(key)
+    if err != nil {
+        return nil, err
+    }
+
+    return aead.Seal(header, nonce, data, header), nil
+}
+
+/**
+ * @brief:  Decrypt an archive made by SealArchive
+ *
+ * @param:  passphrase - Passphrase the archive was sealed with
+ * @param:  archive - Archive to decrypt
+ *
+ * @return: Data on success, ErrArchiveInvalid if it's not an archive,
+ *          ErrArchivePassphrase if the passphrase is wrong or the archive
+ *          was tampered with
+ **/
+func OpenArchive(passphrase string, archive []byte) ([]byte, error) {
+    if len(archive) < archiveHeaderLen || !IsArchive(archive) {
+        return nil, ErrArchiveInvalid
+    }
+
+    header := archive[:archiveHeaderLen]
+    off := len(archiveMagic)
+    if header[off] != archiveVersion {
+        return nil, ErrArchiveInvalid
+    }
+    time := binary.BigEndian.Uint32(header[off + 1:])
+    memory := binary.BigEndian.Uint32(header[off + 5:])
+    threads := header[off + 9]
+    if time == 0 || time > archiveMaxTime || memory > archiveMaxMemory || threads == 0 {
+        return nil, ErrArchiveInvalid
+    }
+    salt := header[off + 10:off + 10 + archiveSaltLen]
+    nonce := header[off + 10 + archiveSaltLen:]
+
+    key := argon2.IDKey([]byte(passphrase), salt, time, memory, threads, chacha20poly1305.KeySize)
+    aead, err := chacha20poly1305.NewX(key)
+    if err != nil {
+        return nil, err
+    }
+
+    data, err := aead.Open(nil, nonce, archive[archiveHeaderLen:], header)
+    if err != nil {
+        return nil, ErrArchivePassphrase
+    }
+
+    return data, nil
+}
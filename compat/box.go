@@ -0,0 +1,74 @@
+package compat
+
+import (
+    "crypto/rand"
+
+    "golang.org/x/crypto/nacl/box"
+)
+
+/**
+ * Bytes of each half of a key pair
+ **/
+const KeyPairBytes = 32
+
+/**
+ * @brief:  Generate an X25519 key pair, to seal keys to (see SealTo)
+ *
+ * @return: Public and private key on success, else error
+ **/
+func NewKeyPair() ([]byte, []byte, error) {
+    public, private, err := box.GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    return public[:], private[:], nil
+}
+
+/**
+ * @brief:  Seal data to a public key, only its private key opens it. A
+ *          throwaway key pair is made for each seal (NaCl sealed box).
+ *
+ * @arg:    public - Public key of the recipient
+ * @arg:    data - Data to seal
+ *
+ * @return: Sealed data on success, ErrKeyPairInvalid if the key isn't one,
+ *          else error
+ **/
+func SealTo(public []byte, data []byte) ([]byte, error) {
+    if len(public) != KeyPairBytes {
+        return nil, ErrKeyPairInvalid
+    }
+
+    var key [KeyPairBytes]byte
+    copy(key[:], public)
+
+    return box.SealAnonymous(nil, data, &key, rand.Reader)
+}
+
+/**
+ * @brief:  Open data sealed by SealTo
+ *
+ * @arg:    public - Public key it was sealed to
+ * @arg:    private - Private key of the pair
+ * @arg:    sealed - Sealed data
+ *
+ * @return: Data on success, ErrKeyPairInvalid if the keys aren't a pair,
+ *          ErrSealedInvalid if it isn't sealed to them or was changed
+ **/
+func OpenSealed(public []byte, private []byte, sealed []byte) ([]byte, error) {
+    if len(public) != KeyPairBytes || len(private) != KeyPairBytes {
+        return nil, ErrKeyPairInvalid
+    }
+
+    var publicKey, privateKey [KeyPairBytes]byte
+    copy(publicKey[:], public)
+    copy(privateKey[:], private)
+
+    data, ok := box.OpenAnonymous(nil, sealed, &publicKey, &privateKey)
+    if !ok {
+        return nil, ErrSealedInvalid
+    }
+
+    return data, nil
+}
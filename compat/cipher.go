@@ -3,8 +3,10 @@ package compat
 import (
     "crypto/aes"
     "crypto/cipher"
+    "crypto/hmac"
     "crypto/md5"
     "crypto/rand"
+    "crypto/sha256"
     "encoding/hex"
     "io"
 )
@@ -78,3 +80,77 @@ func (aesObj AES) Decrypt(data []byte) (string, error) {
 
     return string(plaintext), nil
 }
+
+/**
+ * @brief:  Keyed blind index of a value, lets a ciphered column be looked up
+ *          by exact match without decrypting it. The HMAC key is derived from
+ *          the users key, so indexes of different users don't match.
+ *
+ * @param:  value - Normalized textbase value
+ *
+ * @return: HMAC-SHA256 of the value as hex
+ **/
+func (aesObj AES) BlindIndex(value string) string {
+    indexKey := sha256.Sum256([]byte("vaultDepot blind index:" + aesObj.aes))
+
+    mac := hmac.New(sha256.New, indexKey[:])
+    mac.Write([]byte(value))
+
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * @brief:  Encrypt bytes with the users key, binding them to additional data
+ *          that must be given again to decrypt
+ *
+ * @param:  data - Input that is being encrypted
+ * @param:  ad - Additional data authenticated along with the input
+ *
+ * @return: Data encrypted
+ **/
+func (aesObj AES) Seal(data []byte, ad []byte) ([]byte, error) {
+    block, err := aes.NewCipher([]byte(aesObj.aes))
+    if err != nil {
+        return nil, err
+    }
+
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+
+    nonce := make([]byte, gcm.NonceSize())
+    if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+        return nil, err
+    }
+
+    return gcm.Seal(nonce, nonce, data, ad), nil
+}
+
+/**
+ * @brief:  Decrypt bytes sealed with Seal
+ *
+ * @param:  data - Input that is being decrypted
+ * @param:  ad - Additional data the input was sealed with
+ *
+ * @return: Data decrypted
+ **/
+func (aesObj AES) Open(data []byte, ad []byte) ([]byte, error) {
+    block, err := aes.NewCipher([]byte(aesObj.aes))
+    if err != nil {
+        return nil, err
+    }
+
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+
+    nonceSize := gcm.NonceSize()
+    if len(data) < nonceSize {
+        return nil, ErrCipherTooShort
+    }
+
+    nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+    return gcm.Open(nil, nonce, ciphertext, ad)
+}
@@ -0,0 +1,48 @@
+package compat
+
+import (
+    "strings"
+)
+
+type compatError string
+
+var (
+    /* Return when data isn't an archive made by SealArchive */
+    ErrArchiveInvalid compatError = "compat: Not a valid archive"
+
+    /* Return when an archive can't be decrypted with the passphrase */
+    ErrArchivePassphrase compatError = "compat: Incorrect passphrase or damaged archive"
+
+    /* Return when a ciphertext is shorter than its nonce */
+    ErrCipherTooShort compatError = "compat: Ciphertext is too short"
+
+    /* Return when a public or private key isn't KeyPairBytes long */
+    ErrKeyPairInvalid compatError = "compat: Key pair is not valid"
+
+    /* Return when recovery words are unknown, too few or fail their checksum */
+    ErrRecoveryWordsInvalid compatError = "compat: Recovery key words are not valid, check for typos"
+
+    /* Return when a secret is split into too few or too many shares */
+    ErrSharesCount compatError = "compat: Split into 2 to 16 shares, needing at least 2 of them"
+
+    /* Return when shares are unreadable, repeated or fewer than their threshold */
+    ErrSharesInvalid compatError = "compat: Recovery shares are not valid, check for typos or repeated shares"
+
+    /* Return when shares of different splits are combined */
+    ErrSharesMismatch compatError = "compat: Recovery shares are of different recovery keys"
+
+    /* Return when data sealed by SealTo can't be opened with a key pair */
+    ErrSealedInvalid compatError = "compat: Sealed data can't be opened with this key pair"
+
+    /* Return when a TOTP secret or otpauth URI can't be parsed */
+    ErrTOTPInvalid compatError = "compat: TOTP secret is not valid"
+)
+
+func (err compatError) Error() string {
+    return string(err)
+}
+
+func (err compatError) Public() string {
+    str := strings.Replace(string(err), "compat: ", "", 1)
+    return str
+}
@@ -0,0 +1,41 @@
+package compat
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+)
+
+/**
+ * Keyed hash of tokens, so a leaked database can't be used to log in
+ **/
+type HMAC struct {
+    key     []byte
+}
+
+/**
+ * @brief:  Create an HMAC-SHA256 with a secret key
+ *
+ * @arg:    key - Secret key of the server
+ *
+ * @return: HMAC
+ **/
+func NewHMAC(key string) HMAC {
+    return HMAC {
+        key:    []byte(key),
+    }
+}
+
+/**
+ * @brief:  Hash the input with the secret key, safe to call concurrently
+ *
+ * @arg:    input - Token to hash
+ *
+ * @return: Base64 hash
+ **/
+func (h HMAC) Hash(input string) string {
+    mac := hmac.New(sha256.New, h.key)
+    mac.Write([]byte(input))
+
+    return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,54 @@
+package compat
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "strings"
+
+    "golang.org/x/crypto/argon2"
+)
+
+/**
+ * argon2id parameters of the account keys, same cost as archives. Changing
+ * them changes every key, remote accounts would no longer log in.
+ **/
+const (
+    accountKeyTime    = 3
+    accountKeyMemory  = 64 * 1024
+    accountKeyThreads = 4
+    accountKeyLen     = 32
+)
+
+/**
+ * Keys of a remote account, derived on the client. The server is only sent
+ * the auth hash, which doesn't reveal the encryption key.
+ **/
+type AccountKeys struct {
+    /* Proves the password and secret key to the server, hex encoded */
+    AuthHash string
+
+    /* Encrypts the entries (see NewAES), never leaves the client */
+    EncryptionKey string
+}
+
+/**
+ * @brief:  Derive the keys of a remote account. argon2id stretches the
+ *          password and secret key, salted with the username, into one
+ *          secret that is split in an auth hash and an encryption key.
+ *
+ * @param:  username - Username of the account
+ * @param:  password - Password of the account
+ * @param:  secretKey - Secret key of the account
+ *
+ * @return: Keys of the account
+ **/
+func DeriveAccountKeys(username, password, secretKey string) AccountKeys {
+    salt := sha256.Sum256([]byte("vaultdepot account:" + strings.ToLower(strings.TrimSpace(username))))
+    secret := []byte(password + "\x00" + secretKey)
+    key := argon2.IDKey(secret, salt[:], accountKeyTime, accountKeyMemory, accountKeyThreads, 2 * accountKeyLen)
+
+    return AccountKeys{
+        AuthHash: hex.EncodeToString(key[:accountKeyLen]),
+        EncryptionKey: hex.EncodeToString(key[accountKeyLen:]),
+    }
+}
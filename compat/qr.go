@@ -0,0 +1,53 @@
+package compat
+
+import (
+    "strings"
+
+    "rsc.io/qr"
+)
+
+/**
+ * Light modules around the code, scanners need a quiet zone
+ **/
+const qrQuietZone = 2
+
+/**
+ * @brief:  Draw text as a QR code with block characters, two rows of modules
+ *          per line. Dark modules are drawn as blanks, so it scans on the
+ *          dark background of a terminal.
+ *
+ * @arg:    text - Text to encode, e.g. an otpauth URI
+ *
+ * @return: Lines of the code on success, else error
+ **/
+func QRText(text string) (string, error) {
+    code, err := qr.Encode(text, qr.M)
+    if err != nil {
+        return "", err
+    }
+
+    /* Black is false outside the code, which draws the quiet zone */
+    light := func(x, y int) bool {
+        return !code.Black(x, y)
+    }
+
+    var out strings.Builder
+    for y := -qrQuietZone; y < code.Size + qrQuietZone; y += 2 {
+        for x := -qrQuietZone; x < code.Size + qrQuietZone; x++ {
+            top, bottom := light(x, y), light(x, y + 1)
+            switch {
+            case top && bottom:
+                out.WriteString("█")
+            case top:
+                out.WriteString("▀")
+            case bottom:
+                out.WriteString("▄")
+            default:
+                out.WriteString(" ")
+            }
+        }
+        out.WriteString("\n")
+    }
+
+    return out.String(), nil
+}
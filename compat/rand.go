@@ -0,0 +1,68 @@
+package compat
+
+import (
+    "crypto/rand"
+    "encoding/base64"
+)
+
+/**
+ * Number of random bytes in a remember token
+ **/
+const RememberTokenBytes = 32
+
+/**
+ * @brief:  Generate random bytes with crypto/rand
+ *
+ * @arg:    n - Number of bytes
+ *
+ * @return: Random bytes on success, else error
+ **/
+func Bytes(n int) ([]byte, error) {
+    b := make([]byte, n)
+    if _, err := rand.Read(b); err != nil {
+        return nil, err
+    }
+
+    return b, nil
+}
+
+/**
+ * @brief:  Generate a URL safe base64 string of random bytes
+ *
+ * @arg:    nBytes - Number of random bytes
+ *
+ * @return: Random string on success, else error
+ **/
+func String(nBytes int) (string, error) {
+    b, err := Bytes(nBytes)
+    if err != nil {
+        return "", err
+    }
+
+    return base64.URLEncoding.EncodeToString(b), nil
+}
+
+/**
+ * @brief:  Generate a remember token of RememberTokenBytes random bytes
+ *
+ * @return: Token on success, else error
+ **/
+func RememberToken() (string, error) {
+    return String(RememberTokenBytes)
+}
+
+/**
+ * @brief:  Count the random bytes a token made by String holds
+ *
+ * @arg:    token - Base64 token
+ *
+ * @return: Number of bytes on success, else error
+ **/
+func NBytes(token string) (int, error) {
+    b, err := base64.URLEncoding.DecodeString(token)
+    if err != nil {
+        return -1, err
+    }
+
+    return len(b), nil
+}
@@ -0,0 +1,43 @@
+package compat
+
+import (
+    "strings"
+
+    "github.com/tyler-smith/go-bip39"
+)
+
+/**
+ * Random bytes of a recovery key, written down as 24 words
+ **/
+const RecoveryKeyBytes = 32
+
+/**
+ * @brief:  Write a recovery key as BIP39 words. The last word carries a
+ *          checksum, so a mistyped word is caught before it's used.
+ *
+ * @arg:    key - RecoveryKeyBytes random bytes
+ *
+ * @return: Words separated by spaces on success, else error
+ **/
+func RecoveryWords(key []byte) (string, error) {
+    return bip39.NewMnemonic(key)
+}
+
+/**
+ * @brief:  Read a recovery key written by RecoveryWords. Case and extra
+ *          white space don't matter.
+ *
+ * @arg:    words - Words of the key
+ *
+ * @return: Key on success, else ErrRecoveryWordsInvalid
+ **/
+func ParseRecoveryWords(words string) ([]byte, error) {
+    words = strings.Join(strings.Fields(strings.ToLower(words)), " ")
+
+    key, err := bip39.EntropyFromMnemonic(words)
+    if err != nil || len(key) != RecoveryKeyBytes {
+        return nil, ErrRecoveryWordsInvalid
+    }
+
+    return key, nil
+}
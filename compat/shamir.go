@@ -0,0 +1,276 @@
+package compat
+
+import (
+    "crypto/sha256"
+    "strings"
+
+    "github.com/tyler-smith/go-bip39"
+)
+
+/**
+ * Most shares a secret is split into, the x coordinates are 1 to MaxShares
+ **/
+const MaxShares = 16
+
+/**
+ * Logarithms and powers of 3 in GF(256) with the AES polynomial
+ * x^8 + x^4 + x^3 + x + 1
+ **/
+var gfLog, gfExp = gfTables()
+
+/**
+ * @brief:  Build the logarithm and power tables of GF(256)
+ *
+ * @return: Logarithms, and powers doubled in length so products of two
+ *          logarithms need no modulo
+ **/
+func gfTables() ([256]byte, [510]byte) {
+    var logs [256]byte
+    var exps [510]byte
+
+    x := 1
+    for i := 0; i < 255; i++ {
+        exps[i], exps[i + 255] = byte(x), byte(x)
+        logs[x] = byte(i)
+
+        /* Multiply by 3: x * 2 xor x */
+        x2 := x << 1
+        if x2 & 0x100 != 0 {
+            x2 ^= 0x11b
+        }
+        x = x2 ^ x
+    }
+
+    return logs, exps
+}
+
+func gfMul(a byte, b byte) byte {
+    if a == 0 || b == 0 {
+        return 0
+    }
+    return gfExp[int(gfLog[a]) + int(gfLog[b])]
+}
+
+func gfDiv(a byte, b byte) byte {
+    if a == 0 {
+        return 0
+    }
+    return gfExp[int(gfLog[a]) + 255 - int(gfLog[b])]
+}
+
+/**
+ * @brief:  Split a secret with Shamir's scheme over GF(256). Each byte gets
+ *          its own random polynomial of degree threshold - 1, whose
+ *          constant is the byte; a share holds one point of each.
+ *
+ * @arg:    secret - Secret to split
+ * @arg:    shares - Number of shares, threshold to MaxShares
+ * @arg:    threshold - Shares needed to combine the secret, at least 2
+ *
+ * @return: Shares as the x coordinate followed by the y of each byte on
+ *          success, ErrSharesCount if the counts are out of range, else error
+ **/
+func SplitSecret(secret []byte, shares int, threshold int) ([][]byte, error) {
+    if threshold < 2 || shares < threshold || shares > MaxShares {
+        return nil, ErrSharesCount
+    }
+
+    split := make([][]byte, shares)
+    for i := range split {
+        split[i] = make([]byte, len(secret) + 1)
+        split[i][0] = byte(i + 1)
+    }
+
+    coefficients := make([]byte, threshold)
+    for b, value := range secret {
+        random, err := Bytes(threshold - 1)
+        if err != nil {
+            return nil, err
+        }
+        coefficients[0] = value
+        copy(coefficients[1:], random)
+
+        /* Horner's rule from the highest coefficient down */
+        for _, share := range split {
+            x, y := share[0], byte(0)
+            for c := threshold - 1; c >= 0; c-- {
+                y = gfMul(y, x) ^ coefficients[c]
+            }
+            share[b + 1] = y
+        }
+    }
+
+    return split, nil
+}
+
+/**
+ * @brief:  Combine shares made by SplitSecret with Lagrange interpolation
+ *          at x = 0. Any threshold of them gives the secret back, fewer give
+ *          random bytes.
+ *
+ * @arg:    shares - Shares, all of the same length
+ *
+ * @return: Secret on success, else ErrSharesInvalid
+ **/
+func CombineShares(shares [][]byte) ([]byte, error) {
+    if len(shares) < 2 {
+        return nil, ErrSharesInvalid
+    }
+
+    size := len(shares[0])
+    seen := map[byte]bool{}
+    for _, share := range shares {
+        if len(share) != size || size < 2 || share[0] == 0 || seen[share[0]] {
+            return nil, ErrSharesInvalid
+        }
+        seen[share[0]] = true
+    }
+
+    secret := make([]byte, size - 1)
+    for i, share := range shares {
+        /* Lagrange basis of share i at x = 0: product of xj / (xi - xj) */
+        basis := byte(1)
+        for j, other := range shares {
+            if i != j {
+                basis = gfMul(basis, gfDiv(other[0], share[0] ^ other[0]))
+            }
+        }
+
+        for b := range secret {
+            secret[b] ^= gfMul(share[b + 1], basis)
+        }
+    }
+
+    return secret, nil
+}
+
+/**
+ * Share of a recovery key, read back from its words
+ **/
+type RecoveryShare struct {
+    /* x coordinate, 1 to MaxShares */
+    Index     int
+
+    /* Shares needed to combine the key */
+    Threshold int
+
+    /* Bits of the hash of the key, shares of one split all carry the same */
+    tag       int
+    y         []byte
+}
+
+/**
+ * @brief:  Tag shares with a few bits of the key they split, to tell when
+ *          shares of different splits are combined
+ *
+ * @arg:    key - Recovery key
+ *
+ * @return: Tag, 0 to 7
+ **/
+func shareTag(key []byte) int {
+    sum := sha256.Sum256(key)
+    return int(sum[0] & 0x07)
+}
+
+/**
+ * @brief:  Split a recovery key into shares written as words. Each share
+ *          starts with a word holding its index, the threshold and a tag of
+ *          the key, followed by its points as BIP39 words with a checksum.
+ *
+ * @arg:    key - RecoveryKeyBytes random bytes
+ * @arg:    shares - Number of shares, threshold to MaxShares
+ * @arg:    threshold - Shares needed to recover, at least 2
+ *
+ * @return: Words of each share on success, ErrSharesCount if the counts
+ *          are out of range, else error
+ **/
+func RecoveryShares(key []byte, shares int, threshold int) ([]string, error) {
+    split, err := SplitSecret(key, shares, threshold)
+    if err != nil {
+        return nil, err
+    }
+
+    list := bip39.GetWordList()
+    words := make([]string, len(split))
+    for i, share := range split {
+        mnemonic, err := bip39.NewMnemonic(share[1:])
+        if err != nil {
+            return nil, err
+        }
+
+        header := shareTag(key) << 8 | (threshold - 1) << 4 | int(share[0] - 1)
+        words[i] = list[header] + " " + mnemonic
+    }
+
+    return words, nil
+}
+
+/**
+ * @brief:  Read a share written by RecoveryShares. Case and extra white
+ *          space don't matter.
+ *
+ * @arg:    words - Words of the share
+ *
+ * @return: Share on success, else ErrSharesInvalid
+ **/
+func ParseRecoveryShare(words string) (RecoveryShare, error) {
+    fields := strings.Fields(strings.ToLower(words))
+    if len(fields) < 2 {
+        return RecoveryShare{}, ErrSharesInvalid
+    }
+
+    header, ok := bip39.GetWordIndex(fields[0])
+    if !ok {
+        return RecoveryShare{}, ErrSharesInvalid
+    }
+    y, err := bip39.EntropyFromMnemonic(strings.Join(fields[1:], " "))
+    if err != nil || len(y) != RecoveryKeyBytes {
+        return RecoveryShare{}, ErrSharesInvalid
+    }
+
+    share := RecoveryShare{
+        Index: header & 0x0f + 1,
+        Threshold: header >> 4 & 0x0f + 1,
+        tag: header >> 8,
+        y: y,
+    }
+    if share.Threshold < 2 {
+        return RecoveryShare{}, ErrSharesInvalid
+    }
+
+    return share, nil
+}
+
+/**
+ * @brief:  Combine shares of a recovery key
+ *
+ * @arg:    shares - Shares read by ParseRecoveryShare, at least their
+ *                   threshold
+ *
+ * @return: Recovery key on success
+ *          If a share is given twice or there are too few, ErrSharesInvalid
+ *          If the shares are of different splits, ErrSharesMismatch
+ **/
+func CombineRecoveryShares(shares []RecoveryShare) ([]byte, error) {
+    if len(shares) == 0 || len(shares) < shares[0].Threshold {
+        return nil, ErrSharesInvalid
+    }
+
+    points := make([][]byte, len(shares))
+    for i, share := range shares {
+        if share.Threshold != shares[0].Threshold || share.tag != shares[0].tag {
+            return nil, ErrSharesMismatch
+        }
+        points[i] = append([]byte{byte(share.Index)}, share.y...)
+    }
+
+    key, err := CombineShares(points)
+    if err != nil {
+        return nil, err
+    }
+    if shareTag(key) != shares[0].tag {
+        return nil, ErrSharesMismatch
+    }
+
+    return key, nil
+}
@@ -0,0 +1,205 @@
+package compat
+
+import (
+    "crypto/hmac"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/base32"
+    "encoding/binary"
+    "fmt"
+    "hash"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+/**
+ * Characters of a Steam Guard code
+ **/
+const steamAlphabet = "23456789BCDFGHJKMNPQRTVWXY"
+
+/**
+ * Time-based one-time password (RFC 6238) generator
+ **/
+type TOTP struct {
+    Secret    []byte
+    Algorithm string
+    Digits    int
+    Period    int
+
+    /* Steam Guard codes: 5 characters of steamAlphabet */
+    Steam     bool
+
+    Issuer    string
+    Account   string
+}
+
+/**
+ * @brief:  Parse a TOTP secret. Accepts `otpauth://totp/` URIs (algorithm
+ *          SHA1, SHA256 or SHA512, 6 or 8 digits, `encoder=steam` for Steam),
+ *          `steam://` secrets and bare base32 secrets.
+ *
+ * @param:  input - Secret or URI
+ *
+ * @return: Generator on success, else ErrTOTPInvalid
+ **/
+func ParseTOTP(input string) (TOTP, error) {
+    input = strings.TrimSpace(input)
+    totp := TOTP{Algorithm: "SHA1", Digits: 6, Period: 30}
+
+    switch {
+    case strings.HasPrefix(strings.ToLower(input), "steam://"):
+        totp.Steam = true
+        totp.Digits = 5
+        input = input[len("steam://"):]
+
+    case strings.HasPrefix(strings.ToLower(input), "otpauth://"):
+        uri, err := url.Parse(input)
+        if err != nil || strings.ToLower(uri.Host) != "totp" {
+            return TOTP{}, ErrTOTPInvalid
+        }
+
+        label := strings.TrimPrefix(uri.Path, "/")
+        if i := strings.Index(label, ":"); i >= 0 {
+            totp.Issuer = label[:i]
+            label = label[i + 1:]
+        }
+        totp.Account = strings.TrimSpace(label)
+
+        query := uri.Query()
+        if issuer := query.Get("issuer"); issuer != "" {
+            totp.Issuer = issuer
+        }
+        if algorithm := query.Get("algorithm"); algorithm != "" {
+            totp.Algorithm = strings.ToUpper(algorithm)
+        }
+        if digits := query.Get("digits"); digits != "" {
+            totp.Digits, err = strconv.Atoi(digits)
+            if err != nil {
+                return TOTP{}, ErrTOTPInvalid
+            }
+        }
+        if period := query.Get("period"); period != "" {
+            totp.Period, err = strconv.Atoi(period)
+            if err != nil || totp.Period <= 0 {
+                return TOTP{}, ErrTOTPInvalid
+            }
+        }
+        if strings.ToLower(query.Get("encoder")) == "steam" {
+            totp.Steam = true
+            totp.Digits = 5
+        }
+        input = query.Get("secret")
+    }
+
+    secret := strings.ToUpper(strings.Replace(input, " ", "", -1))
+    secret = strings.TrimRight(secret, "=")
+    key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+    if err != nil || len(key) == 0 {
+        return TOTP{}, ErrTOTPInvalid
+    }
+    totp.Secret = key
+
+    if totp.hash() == nil {
+        return TOTP{}, ErrTOTPInvalid
+    }
+    if !totp.Steam && totp.Digits != 6 && totp.Digits != 8 {
+        return TOTP{}, ErrTOTPInvalid
+    }
+
+    return totp, nil
+}
+
+/**
+ * @brief:  Get the hash function of the algorithm
+ *
+ * @return: Hash constructor, nil if the algorithm isn't supported
+ **/
+func (totp TOTP) hash() func() hash.Hash {
+    switch totp.Algorithm {
+    case "SHA1":
+        return sha1.New
+    case "SHA256":
+        return sha256.New
+    case "SHA512":
+        return sha512.New
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Generate the code for a point in time
+ *
+ * @param:  now - Time to generate the code for
+ *
+ * @return: Code
+ **/
+func (totp TOTP) Code(now time.Time) string {
+    counter := make([]byte, 8)
+    binary.BigEndian.PutUint64(counter, uint64(now.Unix()) / uint64(totp.Period))
+
+    mac := hmac.New(totp.hash(), totp.Secret)
+    mac.Write(counter)
+    sum := mac.Sum(nil)
+
+    /* Dynamic truncation, RFC 4226 section 5.3 */
+    offset := sum[len(sum) - 1] & 0x0f
+    value := binary.BigEndian.Uint32(sum[offset:offset + 4]) & 0x7fffffff
+
+    if totp.Steam {
+        code := make([]byte, totp.Digits)
+        for i := range code {
+            code[i] = steamAlphabet[value % uint32(len(steamAlphabet))]
+            value /= uint32(len(steamAlphabet))
+        }
+        return string(code)
+    }
+
+    mod := uint32(1)
+    for i := 0; i < totp.Digits; i++ {
+        mod *= 10
+    }
+
+    return fmt.Sprintf("%0*d", totp.Digits, value % mod)
+}
+
+/**
+ * @brief:  Seconds the code of a point in time stays valid
+ *
+ * @param:  now - Time the code was generated for
+ *
+ * @return: Remaining seconds
+ **/
+func (totp TOTP) Remaining(now time.Time) int {
+    return totp.Period - int(now.Unix() % int64(totp.Period))
+}
+
+/**
+ * @brief:  Format as an `otpauth://` URI, the form secrets are stored in
+ *
+ * @return: URI
+ **/
+func (totp TOTP) URI() string {
+    query := url.Values{}
+    query.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(totp.Secret))
+    query.Set("algorithm", totp.Algorithm)
+    query.Set("digits", strconv.Itoa(totp.Digits))
+    query.Set("period", strconv.Itoa(totp.Period))
+    if totp.Issuer != "" {
+        query.Set("issuer", totp.Issuer)
+    }
+    if totp.Steam {
+        query.Set("encoder", "steam")
+    }
+
+    label := totp.Account
+    if totp.Issuer != "" {
+        label = totp.Issuer + ":" + label
+    }
+
+    uri := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: query.Encode()}
+    return uri.String()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/atotto/clipboard"
+    "github.com/jinzhu/gorm"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * @brief:  Tell the user about open requests for emergency access to their
+ *          vault, right after logging in
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - Owner of the vault
+ **/
+func warnEmergencyRequests(db *gorm.DB, user models.User) {
+    contacts, err := models.EmergencyContacts(db, user)
+    checkError(err)
+
+    for _, contact := range contacts {
+        if contact.Status == models.EmergencyRequested {
+            fmt.Printf("Emergency access requested: %s\n", contact)
+        }
+    }
+}
+
+/**
+ * @brief:  Let the user pick an emergency access from a list
+ *
+ * @arg:    contacts - Listed emergency accesses
+ *
+ * @return: Emergency access, and false if the number isn't on the list
+ **/
+func chooseEmergencyAccess(contacts []models.EmergencyAccess) (models.EmergencyAccess, bool) {
+    input := 0
+    fmt.Print("Enter contact: ")
+    fmt.Scanln(&input)
+    if input < 1 || input > len(contacts) {
+        fmt.Printf("Contact not found\n\n")
+        return models.EmergencyAccess{}, false
+    }
+
+    return contacts[input - 1], true
+}
+
+/**
+ * @brief:  List the emergency contacts of the user and the vaults they're
+ *          trusted with, and let them manage both
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - User with its vault key unlocked
+ **/
+func manageEmergencyAccess(db *gorm.DB, user models.User) {
+    options := []string{"Add or change a trusted contact", "Approve a request", "Reject a request or take back access",
+        "Remove a trusted contact", "Vaults I'm trusted with", "Done"}
+
+    for {
+        contacts, err := models.EmergencyContacts(db, user)
+        checkError(err)
+
+        if len(contacts) == 0 {
+            fmt.Println("You have no trusted contacts")
+        }
+        for i, contact := range contacts {
+            fmt.Printf("%d.) %s\n", i + 1, contact)
+        }
+        fmt.Println()
+
+        input := DisplayOptions(options)
+        switch input {
+        case 1:
+            username := ""
+            fmt.Print("Enter username: ")
+            fmt.Scanln(&username)
+
+            access := models.EmergencyRead
+            fmt.Println("Once granted they can:")
+            if DisplayOptions([]string{"Read your vault", "Read your vault or take over your account"}) == 2 {
+                access = models.EmergencyTakeover
+            }
+
+            days := 0
+            fmt.Print("Days you have to reject a request (1 - 90): ")
+            fmt.Scanln(&days)
+
+            err = models.AddEmergencyContact(db, user, username, access, days, models.LocalSource)
+            if err == nil {
+                fmt.Printf("%s can ask for %s access to your vault\n\n", username, access)
+            }
+
+        case 2, 3, 4:
+            contact, ok := chooseEmergencyAccess(contacts)
+            if !ok {
+                break
+            }
+
+            switch input {
+            case 2:
+                err = models.ApproveEmergencyAccess(db, user, contact.Username, models.LocalSource)
+            case 3:
+                err = models.RejectEmergencyAccess(db, user, contact.Username, models.LocalSource)
+            default:
+                err = models.RemoveEmergencyContact(db, user, contact.Username, models.LocalSource)
+            }
+            if err == nil {
+                fmt.Printf("Done, %s is told in their security events\n\n", contact.Username)
+            }
+
+        case 5:
+            trustedWith(db, user)
+
+        default:
+            return
+        }
+
+        if err != nil && !refusal(err) {
+            checkError(err)
+        }
+    }
+}
+
+/**
+ * @brief:  List the vaults the user is an emergency contact of, and let them
+ *          ask for access, read a vault or take over an account once it's
+ *          granted
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - User with its vault key unlocked
+ **/
+func trustedWith(db *gorm.DB, user models.User) {
+    contacts, err := models.TrustedBy(db, user)
+    checkError(err)
+    if len(contacts) == 0 {
+        fmt.Printf("Nobody made you their emergency contact\n\n")
+        return
+    }
+    for i, contact := range contacts {
+        fmt.Printf("%d.) %s\n", i + 1, contact)
+    }
+    fmt.Println()
+
+    input := DisplayOptions([]string{"Request access", "Open a vault", "Take over an account", "Done"})
+    if input == 4 {
+        return
+    }
+    contact, ok := chooseEmergencyAccess(contacts)
+    if !ok {
+        return
+    }
+
+    switch input {
+    case 1:
+        requested, err := models.RequestEmergencyAccess(db, user, contact.Username, models.LocalSource)
+        if err == nil {
+            fmt.Printf("Requested, %s can reject it until %s\n\n", contact.Username,
+                requested.GrantsAt().Format("2006-01-02 15:04"))
+        } else if !refusal(err) {
+            checkError(err)
+        }
+
+    case 2:
+        grantor, err := models.OpenEmergencyVault(db, user, contact.Username, models.LocalSource)
+        if err != nil && refusal(err) {
+            return
+        }
+        checkError(err)
+        readEmergencyVault(db, *grantor)
+
+    case 3:
+        answer := ""
+        fmt.Printf("Reset the password and secret key of %s? They are logged out everywhere (y or n): ", contact.Username)
+        fmt.Scanln(&answer)
+        if strings.ToLower(answer) != "y" {
+            return
+        }
+
+        fmt.Printf("Choose a new password and secret key for %s\n", contact.Username)
+        reset := models.User{
+            Password: models.HiddenInput("password"),
+            SecretKey: models.HiddenInput("secret key"),
+        }
+
+        err := models.TakeOverAccount(db, user, contact.Username, reset, models.LocalSource)
+        if err != nil && refusal(err) {
+            return
+        }
+        checkError(err)
+        fmt.Printf("Log in as %s with the new password and secret key\n\n", contact.Username)
+    }
+}
+
+/**
+ * @brief:  Let an emergency contact copy from the entries of a vault
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    grantor - Owner of the vault, with its vault key unlocked
+ **/
+func readEmergencyVault(db *gorm.DB, grantor models.User) {
+    vaults, err := findVaults(db, grantor)
+    if err != models.ErrNotFound {
+        checkError(err)
+    }
+    if len(vaults) == 0 {
+        fmt.Printf("The vault of %s is empty\n\n", grantor.Username)
+        return
+    }
+
+    for {
+        entry := getVaultItems(vaults) - 1
+        if entry < 0 {
+            return
+        }
+
+        vault, err := models.ByID(db, vaults[entry].ID, grantor)
+        checkError(err)
+        models.DisplayEntry(vault)
+
+        switch DisplayOptions([]string{"Copy password or secret to clipboard", "Copy current code", "Done"}) {
+        case 1:
+            fmt.Println("Secret copied to clipboard")
+            clipboard.WriteAll(vault.Secret())
+
+        case 2:
+            code, remaining, err := models.CurrentCode(vault)
+            if err == models.ErrTOTPRequired {
+                fmt.Printf("%s has no TOTP secret\n\n", vault)
+                break
+            }
+            checkError(err)
+            clipboard.WriteAll(code)
+            fmt.Printf("Code copied to clipboard, valid for %d more seconds\n\n", remaining)
+        }
+    }
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+    "bufio"
+    "flag"
     "fmt"
+    "os"
     "strings"
 
     "github.com/atotto/clipboard"
     "github.com/loerac/vaultDepot/compat"
     "github.com/loerac/vaultDepot/models"
     "github.com/loerac/vaultDepot/manager"
+    "github.com/loerac/vaultDepot/web"
 
     "github.com/jinzhu/gorm"
     _ "github.com/lib/pq"
@@ -24,11 +28,32 @@ const (
 var checkError = compat.CheckError
 
 var menu_options []string = []string{"Login", "Signup"}
-var vault_menu_options []string = []string{"Get vault item", "Add vault item", "Export passwords", "Import passwords", "Exit"}
-var vault_options []string = []string{"Copy password to clipboard", "Edit info", "Delete"}
+var vault_menu_options []string = []string{"Get vault item", "Search vault", "Filter by folder or tag", "Add vault item", "Export passwords", "Import passwords", "Import from pass", "Sessions", "Shared with me", "Organizations", "Emergency access", "Log out", "Exit"}
+var vault_options []string = []string{"Copy password or secret to clipboard", "Copy current code", "Edit info", "Edit custom fields", "Attachments", "Delete", "Share"}
 
 var vaults []models.Vault
 
+var columnMapOption = flag.String("map", "",
+    "Import CSV columns by header name, e.g. \"password=Secret,application=Site\"")
+var mergeOption = flag.String("merge", "skip",
+    "What to do with imported entries already in the vault: skip, overwrite, keep-both or newest-wins")
+var dryRunOption = flag.Bool("dry-run", false,
+    "Only show which imported entries are new, changed or identical")
+var plaintextOption = flag.Bool("plaintext-export", false,
+    "Export passwords to an unencrypted CSV instead of a passphrase protected archive")
+var folderOption = flag.String("folder", "",
+    "Only list entries in this folder and its subfolders, e.g. \"Work/Servers\"")
+var tagOption = flag.String("tag", "",
+    "Only list entries with this tag")
+var addrOption = flag.String("addr", "localhost:8080",
+    "Address the web interface and API listen on")
+var httpsOption = flag.Bool("https", false,
+    "The server is reached over HTTPS (e.g. behind a proxy), send Secure cookies")
+var trustProxyOption = flag.Bool("trust-proxy", false,
+    "Take client addresses from the X-Forwarded-For header of the proxy in front of the server")
+var serverOption = flag.String("server", "",
+    "Use the vault on a vaultDepot server, e.g. \"https://vault.example.com\", instead of the database")
+
 /**
  * @brief:  Display the options on the menu
  *
@@ -54,19 +79,44 @@ func DisplayOptions(options []string) int {
     return input
 }
 
+/**
+ * @brief:  Find all the entries in the user's vault, with the metadata
+ *          decrypted to list them, sorted by folder and name
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - User to find and decrypt the entries of
+ *
+ * @return: Entries on success, else error
+ **/
+func findVaults(db *gorm.DB, user models.User) ([]models.Vault, error) {
+    found, err := models.FindAll(db, user.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := models.DecryptMetadata(found, user); err != nil {
+        return nil, err
+    }
+    models.SortVaults(found)
+
+    return found, nil
+}
+
 /**
  * @brief:  Display all the entries in the vault and select a specific vault
  *          entry
  *
+ * @arg:    items - Entries to select from
+ *
  * @return: index of the vault selected
  **/
-func getVaultItems() int {
+func getVaultItems(items []models.Vault) int {
     input := 0
-    models.DisplayVault(vaults)
+    models.DisplayVault(items)
     for input != -1 {
         fmt.Print("Enter vault entry ('-1' to exit): ")
         fmt.Scanln(&input)
-        if input < 0 || input > len(vaults) {
+        if input < 0 || input > len(items) {
             if input != -1 {
                 fmt.Println("Entry not found")
             }
@@ -92,16 +142,27 @@ func getVaultItems() int {
 func selectVaultOptions(db *gorm.DB, user models.User, vault *models.Vault) {
     var char_input string
 
-    fmt.Printf("Selected Vault: %v\n", *vault)
+    models.DisplayEntry(*vault)
     input := DisplayOptions(vault_options)
     switch (input) {
-    /* Copy password to clipboard */
+    /* Copy password, or the item's secret, to clipboard */
     case 1:
-        fmt.Println("Password copied to clipboard")
-        clipboard.WriteAll(vault.Password)
+        fmt.Println("Secret copied to clipboard")
+        clipboard.WriteAll(vault.Secret())
 
-    /* Edit entry */
+    /* Copy TOTP code to clipboard */
     case 2:
+        code, remaining, err := models.CurrentCode(*vault)
+        if err == models.ErrTOTPRequired {
+            fmt.Printf("%s has no TOTP secret\n\n", *vault)
+            break
+        }
+        checkError(err)
+        clipboard.WriteAll(code)
+        fmt.Printf("Code copied to clipboard, valid for %d more seconds\n\n", remaining)
+
+    /* Edit entry */
+    case 3:
         fmt.Printf("Update %s? (y or n): ", *vault)
         fmt.Scanln(&char_input)
         if strings.ToLower(char_input) == "y" {
@@ -113,8 +174,20 @@ func selectVaultOptions(db *gorm.DB, user models.User, vault *models.Vault) {
             fmt.Printf("\n%s wasn't updated\n\n", *vault)
         }
 
+    /* Edit custom fields */
+    case 4:
+        updated_vault, err := models.EditFields(db, *vault, user)
+        checkError(err)
+        fmt.Printf("Updated: %s\n\n", updated_vault)
+        vault = &updated_vault
+
+    /* Add, extract or delete attachments */
+    case 5:
+        err := models.ManageAttachments(db, *vault, user)
+        checkError(err)
+
     /* Delete entry */
-    case 3:
+    case 6:
         fmt.Printf("Delete %s from vault? (y or n): ", *vault)
         fmt.Scanln(&char_input)
         if strings.ToLower(char_input) == "y" {
@@ -124,12 +197,146 @@ func selectVaultOptions(db *gorm.DB, user models.User, vault *models.Vault) {
         } else {
             fmt.Printf("\n%s wasn't deleted\n\n", *vault)
         }
+
+    /* Share with other users, or stop sharing */
+    case 7:
+        manageShares(db, user, *vault)
+
     default:
         break
     }
 }
 
+/**
+ * @brief:  List the entries whose URIs match a site and let the user pick one
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - User to search the vault of
+ * @arg:    site - URL of the site
+ **/
+func matchVaults(db *gorm.DB, user models.User, site string) {
+    found, err := findVaults(db, user)
+    if err != nil && err != models.ErrNotFound {
+        panic(err)
+    }
+
+    matched, err := models.MatchURL(found, site)
+    if err == models.ErrNotFound {
+        fmt.Printf("Nothing in your vault matches %s\n", site)
+        return
+    }
+    checkError(err)
+
+    entry := getVaultItems(matched) - 1
+    if entry < 0 {
+        return
+    }
+
+    vault, err := models.ByID(db, matched[entry].ID, user)
+    checkError(err)
+    selectVaultOptions(db, user, &vault)
+}
+
+/**
+ * @brief:  List the user's active sessions and let them revoke some, see
+ *          the lockouts of their account or manage two-factor login
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - User to list the sessions of
+ * @arg:    current - Session of this run, kept when revoking the others
+ **/
+func manageSessions(db *gorm.DB, user models.User, current models.Session) {
+    options := []string{"Revoke a session", "Revoke all other sessions", "Security events", "Two-factor authentication", "Recovery key", "Done"}
+
+    for {
+        sessions, err := models.ActiveSessions(db, user.ID)
+        checkError(err)
+
+        for i, session := range sessions {
+            mark := ""
+            if session.ID == current.ID {
+                mark = " (this session)"
+            }
+            fmt.Printf("%d.) %s%s\n", i + 1, session, mark)
+        }
+        fmt.Println()
+
+        switch DisplayOptions(options) {
+        case 1:
+            entry := 0
+            fmt.Print("Enter session: ")
+            fmt.Scanln(&entry)
+            if entry < 1 || entry > len(sessions) {
+                fmt.Println("Session not found")
+                break
+            }
+
+            if sessions[entry - 1].ID == current.ID {
+                fmt.Println("Use \"Log out\" to end this session")
+                break
+            }
+            err = models.RevokeSession(db, user.ID, sessions[entry - 1].ID)
+            checkError(err)
+            fmt.Printf("Revoked %s\n\n", sessions[entry - 1])
+
+        case 2:
+            err = models.RevokeSessions(db, user.ID, current.ID)
+            checkError(err)
+            fmt.Println("Revoked all other sessions")
+
+        /* Lockouts of the account */
+        case 3:
+            events, err := models.AuditEvents(db, user.ID)
+            checkError(err)
+            if len(events) == 0 {
+                fmt.Println("No security events")
+            }
+            for _, event := range events {
+                fmt.Println(event)
+            }
+            fmt.Println()
+
+        /* Turn two-factor login on or off */
+        case 4:
+            manageTwoFactor(localAccount{db: db, user: &user})
+
+        /* Create or replace the emergency kit */
+        case 5:
+            setupRecoveryKey(db, &user)
+
+        default:
+            return
+        }
+    }
+}
+
 func main() {
+    flag.Parse()
+    columnMap, err := manager.ParseColumnMap(*columnMapOption)
+    checkError(err)
+    strategy, err := manager.ParseMergeStrategy(*mergeOption)
+    checkError(err)
+    importOptions := manager.ImportOptions{
+        ColumnMap: columnMap,
+        Strategy: strategy,
+        DryRun: *dryRunOption,
+    }
+    filter := models.VaultFilter{Folder: *folderOption, Tag: *tagOption}
+
+    /* The server holds the vault, there's no database to open */
+    if *serverOption != "" {
+        remoteMain(*serverOption, filter)
+        return
+    }
+
+    /* Peppers and bcrypt cost of the credentials stored in the database */
+    err = models.LoadHashing()
+    checkError(err)
+
+    /* Keys of remember tokens, recovery codes and TOTP secrets */
+    err = models.LoadSecrets()
+    checkError(err)
+
     /**
      * Log into the vault_database,
      * Enable logging,
@@ -141,25 +348,98 @@ func main() {
     checkError(err)
     defer db.Close()
     db.LogMode(false)
-    db.AutoMigrate(&models.User{}, &models.Vault{})
+    db.AutoMigrate(&models.User{}, &models.Session{}, &models.Vault{}, &models.Field{},
+        &models.Attachment{}, &models.AttachmentChunk{}, &models.RemoteEntry{},
+        &models.LoginThrottle{}, &models.AuditEvent{}, &models.RecoveryCode{},
+        &models.Share{}, &models.Organization{}, &models.Membership{}, &models.Collection{},
+        &models.EmergencyAccess{})
+    err = models.MigrateVaults(db)
+    checkError(err)
+    err = models.MigrateTwoFactor(db)
+    checkError(err)
+
+    /* The web interface and API log users in per request */
+    if flag.Arg(0) == "serve" {
+        fmt.Printf("Serving the web interface on http://%s and the API on http://%s/api\n", *addrOption, *addrOption)
+        checkError(web.ListenAndServe(db, *addrOption, web.Options{HTTPS: *httpsOption, TrustProxy: *trustProxyOption}))
+        return
+    }
+
+    /* Restoring creates the account, there's nobody to log in yet */
+    if flag.Arg(0) == "restore" {
+        err = manager.RestoreManager(db)
+        checkError(err)
+        return
+    }
+
+    /* Recovering is for users that can't log in */
+    if flag.Arg(0) == "recover" {
+        recoverAccount(db)
+        return
+    }
 
     var user models.User
+    var session models.Session
+
+    /* Pick up the session of the last login, if it's still active */
+    resumed := false
+    if token := models.LoadRememberToken(); token != "" {
+        user, session, err = models.ResumeSession(db, token)
+        if err == models.ErrSessionInvalid {
+            fmt.Println("Your session expired, log in again")
+            checkError(models.ForgetRememberToken())
+        } else {
+            checkError(err)
+            resumed = true
+        }
+    }
 
     /* Let the user login or signup */
-    menu := DisplayOptions(menu_options)
-    if menu == 1 {
-        user, err = models.Login(db)
-    } else {
-        user, err = models.Signup(db)
+    if !resumed {
+        menu := DisplayOptions(menu_options)
+        if menu == 1 {
+            user, err = models.Login(db)
+        } else {
+            user, err = models.Signup(db)
+            checkError(err)
+
+            answer := ""
+            fmt.Print("Create a recovery key, to get back in if you forget your secret key? (y or n): ")
+            fmt.Scanln(&answer)
+            if strings.ToLower(answer) == "y" {
+                setupRecoveryKey(db, &user)
+            }
+        }
+        checkError(err)
+
+        hostname, _ := os.Hostname()
+        session, err = models.CreateSession(db, user, hostname)
+        checkError(err)
+        err = models.SaveRememberToken(session.Remember)
+        checkError(err)
+    }
+
+    if flag.Arg(0) == "backup" {
+        err = manager.BackupManager(db, user)
+        checkError(err)
+        return
     }
-    checkError(err)
 
     /**
      * Grab all the items from user's vault.
      * If nothing is in the vault, let them add it in
      **/
     fmt.Println("\nWelcome", user.Username)
-    vaults, err = models.FindAll(db, user.ID)
+    warnEmergencyRequests(db, user)
+    err = models.EncryptPlaintextMetadata(db, user)
+    checkError(err)
+
+    if flag.Arg(0) == "match" {
+        matchVaults(db, user, flag.Arg(1))
+        return
+    }
+
+    vaults, err = findVaults(db, user)
     if err == models.ErrNotFound || len(vaults) == 0 {
         fmt.Println("Looks like you have nothing in your vault, let's update that")
         vault, err := models.CreateEntry(db, user)
@@ -180,42 +460,128 @@ func main() {
          **/
         input = DisplayOptions(vault_menu_options)
         switch (input) {
-        /* Get vault item */
+        /* Get vault item, in the folder and tag filtered on */
         case 1:
-            input = getVaultItems()
+            shown := models.FilterVaults(vaults, filter)
+            if len(shown) == 0 {
+                fmt.Printf("Nothing in %s\n\n", filter)
+                break
+            }
+
+            input = getVaultItems(shown)
             if -1 == input {
                 break
             }
 
-            entry := input - 1
-            vaults[entry], err = models.ByID(db, vaults[entry].ID, user)
+            vault, err := models.ByID(db, shown[input - 1].ID, user)
             checkError(err)
 
-            selectVaultOptions(db, user, &vaults[entry])
-            vaults, err = models.FindAll(db, user.ID)
+            selectVaultOptions(db, user, &vault)
+            vaults, err = findVaults(db, user)
             checkError(err)
 
-        /* Add vault item */
+        /* Search vault by exact application or email, in the filtered folder and tag */
         case 2:
+            term := ""
+            fmt.Print("Enter application or email: ")
+            fmt.Scanln(&term)
+
+            found, err := models.FindExact(db, user, term)
+            if err == nil {
+                found = models.FilterVaults(found, filter)
+                if len(found) == 0 {
+                    err = models.ErrNotFound
+                }
+            }
+            if err == models.ErrNotFound {
+                fmt.Printf("Nothing found for %s\n\n", term)
+                break
+            }
+            checkError(err)
+
+            entry := getVaultItems(found) - 1
+            if entry < 0 {
+                break
+            }
+
+            vault, err := models.ByID(db, found[entry].ID, user)
+            checkError(err)
+
+            selectVaultOptions(db, user, &vault)
+            vaults, err = findVaults(db, user)
+            checkError(err)
+
+        /* Narrow the listed items down to a folder or tag */
+        case 3:
+            models.DisplayFolders(vaults)
+
+            reader := bufio.NewReader(os.Stdin)
+            fmt.Print("Enter folder (empty for all): ")
+            folder, _ := reader.ReadString('\n')
+            fmt.Print("Enter tag (empty for all): ")
+            tag, _ := reader.ReadString('\n')
+
+            filter = models.VaultFilter{
+                Folder: models.NormalizeFolder(folder),
+                Tag: strings.TrimSpace(tag),
+            }
+            fmt.Printf("Showing %s\n\n", filter)
+
+        /* Add vault item */
+        case 4:
             _, err = models.CreateEntry(db, user)
             checkError(err)
 
-            vaults, err = models.FindAll(db, user.ID)
+            vaults, err = findVaults(db, user)
             checkError(err)
 
         /* Export vault */
-        case 3:
-            err = manager.ExportManager(vaults, user)
+        case 5:
+            err = manager.ExportManager(vaults, user, *plaintextOption)
             checkError(err)
 
         /* Import vault */
-        case 4:
-            err = manager.ImportManager(db, user)
+        case 6:
+            err = manager.ImportManager(db, user, importOptions)
+            checkError(err)
+
+            vaults, err = findVaults(db, user)
             checkError(err)
 
-            vaults, err = models.FindAll(db, user.ID)
+        /* Import pass store */
+        case 7:
+            err = manager.PassImportManager(db, user, importOptions)
             checkError(err)
 
+            vaults, err = findVaults(db, user)
+            checkError(err)
+
+        /* List and revoke sessions */
+        case 8:
+            manageSessions(db, user, session)
+
+        /* Entries other users shared */
+        case 9:
+            sharedWithMe(db, user)
+
+        /* Organizations and their shared collections */
+        case 10:
+            manageOrganizations(db, user)
+
+        /* Trusted contacts, and the vaults the user is trusted with */
+        case 11:
+            manageEmergencyAccess(db, user)
+
+        /* Revoke this session and forget its token */
+        case 12:
+            err = models.RevokeSession(db, user.ID, session.ID)
+            if err != nil && err != models.ErrNotFound {
+                panic(err)
+            }
+            checkError(models.ForgetRememberToken())
+            fmt.Println("Logged out, bye bye")
+            input = -1
+
         default:
             fmt.Println("Bye bye")
             input = -1
@@ -0,0 +1,349 @@
+package manager
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "time"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/loerac/vaultDepot/models"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * Version of the backup layout, bumped when rows are added to it
+ **/
+const backupVersion = 4
+
+/**
+ * Everything stored for an account. Rows are kept as they are in the
+ * database, so vault entries stay encrypted under the user's secret key.
+ *
+ * What other users or organizations granted the account isn't backed up:
+ * entries shared with it, memberships and being someone's emergency
+ * contact. Only they can grant it again, else a restore would bring back
+ * what they took away. Recovery codes are hashed with the ID of the account,
+ * which changes, so new ones are generated after a restore.
+ **/
+type accountBackup struct {
+    Version int
+    Created time.Time
+    User    models.User
+    Vaults  []models.Vault
+
+    /* Since version 2 */
+    Attachments []models.Attachment
+    Chunks      []models.AttachmentChunk
+
+    /* Since version 4, the TOTP secret of two-factor login (the user's row
+     * holds it encrypted with the TOTP key of the server) and whom the
+     * account shared entries with or made its emergency contacts */
+    TOTP        string
+    Shares      []backupShare
+    Contacts    []backupContact
+}
+
+/**
+ * Share of an entry, with the public key of the user it's shared with. The
+ * item key is sealed to it, so it's only restored to the same user with the
+ * same key pair.
+ **/
+type backupShare struct {
+    models.Share
+    PublicKey   []byte
+}
+
+/**
+ * Emergency contact, with its public key like backupShare
+ **/
+type backupContact struct {
+    models.EmergencyAccess
+    PublicKey   []byte
+}
+
+/**
+ * @brief:  Back up the user's account into a passphrase encrypted archive
+ *          (see compat.SealArchive)
+ *
+ * @param:  db - pointer to dabase
+ * @param:  user - Account to back up
+ *
+ * @return: nil on success, else error
+ **/
+func BackupManager(db *gorm.DB, user models.User) error {
+    vaults, err := models.FindAll(db, user.ID)
+    if err != nil && err != models.ErrNotFound {
+        return err
+    }
+
+    backup := accountBackup{
+        Version: backupVersion,
+        Created: time.Now(),
+        User: user,
+        Vaults: vaults,
+    }
+
+    if len(vaults) > 0 {
+        ids := make([]uint, len(vaults))
+        for i, vault := range vaults {
+            ids[i] = vault.ID
+        }
+
+        err := db.Where("vault_id IN (?)", ids).Order("id").Find(&backup.Attachments).Error
+        if err != nil {
+            return err
+        }
+    }
+
+    if len(backup.Attachments) > 0 {
+        ids := make([]uint, len(backup.Attachments))
+        for i, attachment := range backup.Attachments {
+            ids[i] = attachment.ID
+        }
+
+        err := db.Where("attachment_id IN (?)", ids).Order("attachment_id, seq").Find(&backup.Chunks).Error
+        if err != nil {
+            return err
+        }
+    }
+
+    if backup.TOTP, err = models.TwoFactorSecret(user); err != nil {
+        return err
+    }
+
+    for _, vault := range vaults {
+        shares, err := models.EntryShares(db, vault)
+        if err != nil {
+            return err
+        }
+
+        for _, share := range shares {
+            grantee, err := models.ByUsername(db, share.Username)
+            if err != nil {
+                return err
+            }
+            backup.Shares = append(backup.Shares, backupShare{share, grantee.PublicKey})
+        }
+    }
+
+    contacts, err := models.EmergencyContacts(db, user)
+    if err != nil {
+        return err
+    }
+    for _, contact := range contacts {
+        grantee, err := models.ByUsername(db, contact.Username)
+        if err != nil {
+            return err
+        }
+        backup.Contacts = append(backup.Contacts, backupContact{contact, grantee.PublicKey})
+    }
+
+    data, err := json.Marshal(backup)
+    if err != nil {
+        return err
+    }
+
+    passphrase := models.HiddenInput("backup passphrase")
+    if len(passphrase) < 8 {
+        return ErrPassphraseTooShort
+    }
+
+    archive, err := compat.SealArchive(passphrase, data)
+    if err != nil {
+        return err
+    }
+
+    filename := Filename(false, ".vdb")
+    if err := ioutil.WriteFile(filename, archive, 0600); err != nil {
+        return err
+    }
+
+    fmt.Printf("Backed up %s with %d entries and %d attachments to %s\n",
+        user.Username, len(vaults), len(backup.Attachments), filename)
+
+    return nil
+}
+
+/**
+ * @brief:  Restore an account from a backup made by BackupManager. The
+ *          account must not exist in the database yet, the user logs in
+ *          with the same credentials as before.
+ *
+ * @param:  db - pointer to dabase
+ *
+ * @return: nil on success, else error
+ **/
+func RestoreManager(db *gorm.DB) error {
+    filename := Filename(true, ".vdb")
+    archive, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return err
+    }
+
+    passphrase, err := models.UserInput("Enter backup passphrase")
+    if err != nil {
+        return err
+    }
+
+    data, err := compat.OpenArchive(passphrase, archive)
+    if err != nil {
+        return err
+    }
+
+    var backup accountBackup
+    if err := json.Unmarshal(data, &backup); err != nil {
+        return err
+    }
+    if backup.Version > backupVersion {
+        return ErrBackupVersion
+    }
+
+    user := backup.User
+    if user.PepperVersion == 0 {
+        /* Backed up before peppers had versions, hashed with the first one */
+        user.PepperVersion = 1
+    }
+    if _, err := models.ByUsername(db, user.Username); err == nil {
+        return models.ErrUsernameTaken
+    } else if err != models.ErrNotFound {
+        return err
+    }
+
+    /* Older backups hold folders and custom fields in plaintext */
+    if models.PlaintextEntries(backup.Vaults) {
+        fmt.Printf("The folders and custom fields of %s are encrypted while restoring\n", user.Username)
+        secretKey := models.HiddenInput("secret key")
+        if err := models.EncryptRestoredEntries(backup.Vaults, user, secretKey); err != nil {
+            return err
+        }
+    }
+
+    if err := models.RestoreTwoFactor(&user, backup.TOTP); err != nil {
+        return err
+    }
+
+    skipped := 0
+    err = db.Transaction(func(tx *gorm.DB) error {
+        user.ID = 0
+        if err := tx.Create(&user).Error; err != nil {
+            return err
+        }
+
+        oldIDs := make([]uint, len(backup.Vaults))
+        for i := range backup.Vaults {
+            oldIDs[i] = backup.Vaults[i].ID
+            backup.Vaults[i].ID = 0
+            backup.Vaults[i].UserID = user.ID
+        }
+
+        if err := models.CreateVaultEntries(tx, backup.Vaults); err != nil {
+            return err
+        }
+
+        /* Rows get new IDs, point the attachments and chunks at them */
+        vaultIDs := map[uint]uint{}
+        for i, vault := range backup.Vaults {
+            vaultIDs[oldIDs[i]] = vault.ID
+        }
+
+        attachmentIDs := map[uint]uint{}
+        for _, attachment := range backup.Attachments {
+            oldID := attachment.ID
+            attachment.ID = 0
+            attachment.VaultID = vaultIDs[attachment.VaultID]
+            if err := tx.Create(&attachment).Error; err != nil {
+                return err
+            }
+            attachmentIDs[oldID] = attachment.ID
+        }
+
+        for _, chunk := range backup.Chunks {
+            chunk.ID = 0
+            chunk.AttachmentID = attachmentIDs[chunk.AttachmentID]
+            if err := tx.Create(&chunk).Error; err != nil {
+                return err
+            }
+        }
+
+        for _, share := range backup.Shares {
+            grantee, err := restoredGrantee(tx, share.Username, share.PublicKey)
+            if err != nil {
+                return err
+            } else if grantee == nil {
+                skipped++
+                continue
+            }
+
+            share.Share.ID = 0
+            share.VaultID = vaultIDs[share.VaultID]
+            share.UserID = grantee.ID
+            if err := tx.Create(&share.Share).Error; err != nil {
+                return err
+            }
+        }
+
+        for _, contact := range backup.Contacts {
+            grantee, err := restoredGrantee(tx, contact.Username, contact.PublicKey)
+            if err != nil {
+                return err
+            } else if grantee == nil {
+                skipped++
+                continue
+            }
+
+            /* The wait of a request pending at backup time may be over, it's
+             * asked for again */
+            contact.EmergencyAccess.ID = 0
+            contact.GrantorID = user.ID
+            contact.GranteeID = grantee.ID
+            contact.Status, contact.RequestedAt = models.EmergencyIdle, nil
+            if err := tx.Create(&contact.EmergencyAccess).Error; err != nil {
+                return err
+            }
+        }
+
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Restored %s with %d entries from %s (backed up %s)\n",
+        user.Username, len(backup.Vaults), filename, backup.Created.Format(time.RFC1123))
+    if skipped > 0 {
+        fmt.Printf("%d shares and emergency contacts weren't restored, their users are gone or have new keys\n", skipped)
+    }
+    if user.TwoFactorEnabled() {
+        fmt.Println("Two-factor login is on, get new recovery codes: the old ones don't work anymore")
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Find the user a restored share or emergency contact is for
+ *
+ * @param:  db - pointer to dabase
+ * @param:  username - User it was for
+ * @param:  publicKey - Public key of the user at backup time
+ *
+ * @return: User on success, nil if the user is gone or has another key
+ *          pair, else error
+ **/
+func restoredGrantee(db *gorm.DB, username string, publicKey []byte) (*models.User, error) {
+    grantee, err := models.ByUsername(db, username)
+    if err == models.ErrNotFound {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+
+    if len(publicKey) == 0 || !bytes.Equal(grantee.PublicKey, publicKey) {
+        return nil, nil
+    }
+
+    return grantee, nil
+}
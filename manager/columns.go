@@ -0,0 +1,224 @@
+package manager
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Header names each vault field is known by, matched case insensitive
+ **/
+var columnAliases = map[string][]string{
+    "email":       {"email", "e-mail", "mail", "email address", "login_email"},
+    "username":    {"username", "user", "user name", "login", "login_username"},
+    "application": {"application", "app", "name", "title", "site", "service"},
+    "password":    {"password", "pass", "passwd", "login_password"},
+    "folder":      {"folder", "group", "grouping", "path", "folder name"},
+    "tags":        {"tags", "tag", "labels", "keywords"},
+    "uris":        {"uris", "urls", "uri", "url", "login_uri", "website", "web site"},
+    "notes":       {"notes", "note", "comments", "extra"},
+    "type":        {"type", "item type", "kind"},
+    "fields":      {"fields", "payload"},
+    "totp":        {"totp", "otp", "otpauth", "2fa"},
+    "custom":      {"custom", "custom fields", "custom_fields", "extra fields"},
+    "modified":    {"modified", "updated", "updated_at", "last_modified", "lastmodified"},
+}
+
+/**
+ * Fields every imported row needs a value for, and those a login needs too
+ **/
+var requiredColumns = []string{"application"}
+var loginColumns = []string{"email", "password"}
+
+/**
+ * @brief:  Parse the `--map` option, a comma separated list of
+ *          `field=Header` pairs overriding the header aliases
+ *
+ * @param:  option - Value of the `--map` option
+ *
+ * @return: Field to header name on success, else ErrMapInvalid
+ **/
+func ParseColumnMap(option string) (map[string]string, error) {
+    columns := map[string]string{}
+    if strings.TrimSpace(option) == "" {
+        return columns, nil
+    }
+
+    for _, pair := range strings.Split(option, ",") {
+        kv := strings.SplitN(pair, "=", 2)
+        if len(kv) != 2 {
+            return nil, ErrMapInvalid
+        }
+
+        field := strings.ToLower(strings.TrimSpace(kv[0]))
+        if _, ok := columnAliases[field]; !ok {
+            return nil, ErrMapInvalid
+        }
+        columns[field] = strings.TrimSpace(kv[1])
+    }
+
+    return columns, nil
+}
+
+/**
+ * @brief:  Find the column index of each vault field in the CSV header
+ *
+ * @param:  header - First row of the CSV
+ * @param:  columnMap - Header name overrides from `--map`
+ *
+ * @return: Field to column index on success, else ErrColumnRequired
+ **/
+func mapColumns(header []string, columnMap map[string]string) (map[string]int, error) {
+    index := map[string]int{}
+    for i, name := range header {
+        name = strings.ToLower(strings.TrimSpace(name))
+        if _, ok := index[name]; !ok {
+            index[name] = i
+        }
+    }
+
+    columns := map[string]int{}
+    for field, aliases := range columnAliases {
+        if name, ok := columnMap[field]; ok {
+            aliases = []string{name}
+        }
+
+        for _, alias := range aliases {
+            if i, ok := index[strings.ToLower(alias)]; ok {
+                columns[field] = i
+                break
+            }
+        }
+    }
+
+    for _, field := range requiredColumns {
+        if _, ok := columns[field]; !ok {
+            fmt.Printf("No column found for %s\n", field)
+            return nil, ErrColumnRequired
+        }
+    }
+
+    return columns, nil
+}
+
+/**
+ * @brief:  Get the value of a field in a row
+ *
+ * @param:  row - CSV row
+ * @param:  columns - Field to column index
+ * @param:  field - Vault field
+ *
+ * @return: Value of the field, empty if the row has no such column
+ **/
+func columnValue(row []string, columns map[string]int, field string) string {
+    i, ok := columns[field]
+    if !ok || i >= len(row) {
+        return ""
+    }
+
+    return strings.TrimSpace(row[i])
+}
+
+/**
+ * @brief:  List the required fields a row has no value for, logins need an
+ *          email and password on top of the application
+ *
+ * @param:  row - CSV row
+ * @param:  columns - Field to column index
+ *
+ * @return: Missing fields, empty if none
+ **/
+func missingColumns(row []string, columns map[string]int) []string {
+    required := requiredColumns
+    itemType := strings.ToLower(columnValue(row, columns, "type"))
+    if itemType == "" || itemType == models.TypeLogin {
+        required = append(required, loginColumns...)
+    }
+
+    var missing []string
+    for _, field := range required {
+        if columnValue(row, columns, field) == "" {
+            missing = append(missing, field)
+        }
+    }
+
+    return missing
+}
+
+/**
+ * @brief:  Parse the fields column, a JSON object of the item's fields
+ *
+ * @param:  value - Fields column value
+ *
+ * @return: Fields on success, nil if empty, else error
+ **/
+func parseFields(value string) (map[string]string, error) {
+    if value == "" {
+        return nil, nil
+    }
+
+    var fields map[string]string
+    if err := json.Unmarshal([]byte(value), &fields); err != nil {
+        return nil, ErrFieldsInvalid
+    }
+
+    return fields, nil
+}
+
+/**
+ * Custom field as written in the custom column
+ **/
+type customField struct {
+    Name   string `json:"name"`
+    Value  string `json:"value"`
+    Hidden bool   `json:"hidden,omitempty"`
+}
+
+/**
+ * @brief:  Parse the custom column, a JSON array of custom fields
+ *
+ * @param:  value - Custom column value
+ *
+ * @return: Fields on success, nil if empty, else error
+ **/
+func parseCustom(value string) ([]models.Field, error) {
+    if value == "" {
+        return nil, nil
+    }
+
+    var custom []customField
+    if err := json.Unmarshal([]byte(value), &custom); err != nil {
+        return nil, ErrCustomInvalid
+    }
+
+    fields := make([]models.Field, len(custom))
+    for i, field := range custom {
+        fields[i] = models.Field{Name: field.Name, Value: field.Value, Hidden: field.Hidden}
+    }
+
+    return fields, nil
+}
+
+/**
+ * @brief:  Format decrypted custom fields for the custom column
+ *
+ * @param:  fields - Decrypted custom fields
+ *
+ * @return: JSON array on success, empty if no fields, else error
+ **/
+func formatCustom(fields []models.Field) (string, error) {
+    if len(fields) == 0 {
+        return "", nil
+    }
+
+    custom := make([]customField, len(fields))
+    for i, field := range fields {
+        custom[i] = customField{Name: field.Name, Value: field.Value, Hidden: field.Hidden}
+    }
+
+    data, err := json.Marshal(custom)
+    return string(data), err
+}
@@ -0,0 +1,51 @@
+package manager
+
+import (
+    "strings"
+)
+
+type managerError string
+
+var (
+    /* Return when the password store directory doesn't exist */
+    ErrStoreNotFound managerError = "manager: Password store directory not found"
+
+    /* Return when the `--map` option isn't a list of field=Header pairs */
+    ErrMapInvalid managerError = "manager: Column map must be field=Header pairs of known fields"
+
+    /* Return when the `--merge` option isn't a known strategy */
+    ErrMergeInvalid managerError = "manager: Merge strategy must be skip, overwrite, keep-both or newest-wins"
+
+    /* Return when the CSV header has no column for a required field */
+    ErrColumnRequired managerError = "manager: CSV is missing a required column"
+
+    /* Return when the fields column isn't a JSON object of strings */
+    ErrFieldsInvalid managerError = "manager: Fields must be a JSON object of strings"
+
+    /* Return when the custom column isn't a JSON array of fields */
+    ErrCustomInvalid managerError = "manager: Custom fields must be a JSON array of {name, value, hidden}"
+
+    /* Return when rows of an import failed and nothing was imported */
+    ErrImportFailed managerError = "manager: Import failed, nothing was imported"
+
+    /* Return when the export passphrase length less than 8 */
+    ErrPassphraseTooShort managerError = "manager: Passphrase must be at least 8 characters long"
+
+    /* Return when a backup was made by a newer version */
+    ErrBackupVersion managerError = "manager: Backup is from a newer version of vaultDepot"
+
+    /* Return when an encrypted file is found but no keyring was given */
+    ErrKeyringRequired managerError = "manager: Keyring is required to decrypt file"
+
+    /* Return when the keyring passphrase can't unlock the private keys */
+    ErrKeyringLocked managerError = "manager: Keyring passphrase is incorrect"
+)
+
+func (err managerError) Error() string {
+    return string(err)
+}
+
+func (err managerError) Public() string {
+    str := strings.Replace(string(err), "manager: ", "", 1)
+    return str
+}
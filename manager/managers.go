@@ -1,9 +1,18 @@
 package manager
 
 import (
+    "bufio"
+    "bytes"
     "encoding/csv"
+    "encoding/json"
+    "errors"
     "fmt"
+    "io/ioutil"
     "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
 
     "github.com/loerac/vaultDepot/compat"
     "github.com/loerac/vaultDepot/models"
@@ -14,16 +23,17 @@ import (
 /**
  * CSV row header
  **/
-var header = []string{"email", "username", "application", "password"}
+var header = []string{"type", "email", "username", "application", "password", "uris", "folder", "tags", "notes", "totp", "fields", "custom", "modified"}
 
 /**
  * @brief:  Ask the user for a filename path
  *
- * @param:  port_type - Importing (true) or exporting (false) a CSV file
+ * @param:  port_type - Importing (true) or exporting (false) a file
+ * @param:  ext - Extension added if the filename has none
  *
  * @return: Filename path
  **/
-func Filename(port_type bool) string {
+func Filename(port_type bool, ext string) string {
     _type := "Exporting"
     if port_type {
         _type = "Importing"
@@ -35,9 +45,8 @@ func Filename(port_type bool) string {
         fmt.Scanln(&filename)
     }
 
-    ext := filename[len(filename) - 4:]
-    if ".csv" != ext {
-        filename += ".csv"
+    if filepath.Ext(filename) == "" {
+        filename += ext
     }
 
     fmt.Printf("%s %s...\n", _type, filename)
@@ -45,93 +54,344 @@ func Filename(port_type bool) string {
 }
 
 /**
- * @brief:  Import a CSV with the header values (email, username, application, password)
- *          Password is textbase, and will be encrypted with users secret key
+ * @brief:  Ask the user for a line of input, may be empty
+ *
+ * @param:  prompt - Message to ask for user input
+ *
+ * @return: Input without surrounding white spaces
+ **/
+func readLine(prompt string) string {
+    reader := bufio.NewReader(os.Stdin)
+
+    fmt.Printf("%s: ", prompt)
+    input, _ := reader.ReadString('\n')
+
+    return strings.TrimSpace(input)
+}
+
+/**
+ * Outcome of an import
+ **/
+type ImportResult struct {
+    /* Imported entries by whether they are in the vault already */
+    New       int
+    Changed   int
+    Identical int
+
+    /* Rows left out for another row of the file with the same entry */
+    Duplicate int
+
+    /* Entries written, none on a dry run or failed import */
+    Created   int
+    Updated   int
+
+    /* Rows that couldn't be imported */
+    Failed    []RowError
+
+    rows      [][]string
+    plans     []importPlan
+}
+
+/**
+ * @brief:  Import a CSV, columns are matched to the vault fields by their
+ *          header name (see columnAliases). Password is textbase, and will be
+ *          encrypted with users secret key. Entries already in the vault are
+ *          matched by application, username and email and merged according
+ *          to the strategy. The import is a single transaction, if any row
+ *          fails nothing is imported.
+ *
+ * @param:  db - pointer to dabase
+ * @param:  user - contains user ID
+ * @param:  data - CSV, already decrypted
+ * @param:  options - Column map, merge strategy and dry run
+ *
+ * @return: Result on success, the result and ErrImportFailed if rows failed,
+ *          else error
+ **/
+func ImportCSV(db *gorm.DB, user models.User, data []byte, options ImportOptions) (ImportResult, error) {
+    read := csv.NewReader(bytes.NewReader(data))
+    read.FieldsPerRecord = -1
+    rows, err := read.ReadAll()
+    if err != nil {
+        return ImportResult{}, err
+    }
+    if len(rows) == 0 {
+        return ImportResult{}, ErrColumnRequired
+    }
+
+    columns, err := mapColumns(rows[0], options.ColumnMap)
+    if err != nil {
+        return ImportResult{}, err
+    }
+
+    var entries []importRow
+    var failed []RowError
+    for i, row := range rows[1:] {
+        /* Header is row 1 */
+        line := i + 2
+
+        if missing := missingColumns(row, columns); len(missing) > 0 {
+            failed = append(failed, RowError{
+                Line: line,
+                Err: errors.New("missing " + strings.Join(missing, ", ")),
+            })
+            continue
+        }
+
+        fields, err := parseFields(columnValue(row, columns, "fields"))
+        if err != nil {
+            failed = append(failed, RowError{Line: line, Err: err})
+            continue
+        }
+
+        custom, err := parseCustom(columnValue(row, columns, "custom"))
+        if err != nil {
+            failed = append(failed, RowError{Line: line, Err: err})
+            continue
+        }
+
+        entries = append(entries, importRow{
+            Line: line,
+            Vault: models.Vault{
+                UserID: user.ID,
+                Type: columnValue(row, columns, "type"),
+                Email: columnValue(row, columns, "email"),
+                Username: columnValue(row, columns, "username"),
+                Application: columnValue(row, columns, "application"),
+                Password: columnValue(row, columns, "password"),
+                Folder: columnValue(row, columns, "folder"),
+                Tags: models.ParseTags(columnValue(row, columns, "tags")),
+                URIs: models.ParseURIs(columnValue(row, columns, "uris")),
+                Notes: columnValue(row, columns, "notes"),
+                TOTP: columnValue(row, columns, "totp"),
+                Payload: fields,
+                Fields: custom,
+            },
+            Modified: parseModified(columnValue(row, columns, "modified")),
+        })
+    }
+
+    plans, err := planImport(db, user, entries, options.Strategy)
+    if err != nil {
+        return ImportResult{}, err
+    }
+
+    creates, updates, invalid := prepareImport(user, plans, options.Strategy)
+    failed = append(failed, invalid...)
+    sort.Slice(failed, func(i, j int) bool {
+        return failed[i].Line < failed[j].Line
+    })
+
+    result := ImportResult{Failed: failed, rows: rows, plans: plans}
+    for _, plan := range plans {
+        switch plan.Status {
+        case importNew:
+            result.New++
+        case importChanged:
+            result.Changed++
+        case importIdentical:
+            result.Identical++
+        case importDuplicate:
+            result.Duplicate++
+        }
+    }
+
+    if options.DryRun {
+        return result, nil
+    }
+
+    /* All or nothing, a half imported vault is worse than none */
+    if len(failed) > 0 {
+        return result, ErrImportFailed
+    }
+
+    if err := commitImport(db, creates, updates); err != nil {
+        return result, err
+    }
+    result.Created = len(creates)
+    result.Updated = len(updates)
+
+    return result, nil
+}
+
+/**
+ * @brief:  Ask for a CSV, or an encrypted export, and import it (see
+ *          ImportCSV). The failed rows are reported.
  *
  * @param:  db - pointer to dabase
  * @param:  user - contains user ID
+ * @param:  options - Column map, merge strategy and dry run
  *
  * @return: nil on success, else error
  **/
-func ImportManager(db *gorm.DB, user models.User) error {
-    filename := Filename(true)
-    file, err := os.Open(filename)
+func ImportManager(db *gorm.DB, user models.User, options ImportOptions) error {
+    filename := Filename(true, ".csv")
+    data, err := ioutil.ReadFile(filename)
     if nil != err {
         return err
     }
-    defer file.Close()
 
-    read := csv.NewReader(file)
-    rows, err := read.ReadAll()
-    if err != nil {
+    /* Encrypted exports are decrypted in memory, never written to disk */
+    encrypted := compat.IsArchive(data)
+    if encrypted {
+        passphrase, err := models.UserInput("Enter export passphrase")
+        if err != nil {
+            return err
+        }
+
+        data, err = compat.OpenArchive(passphrase, data)
+        if err != nil {
+            fmt.Printf("Failed to import %s: %s.\n", filename, err)
+            return err
+        }
+    }
+
+    result, err := ImportCSV(db, user, data, options)
+    if result.rows == nil {
         fmt.Printf("Failed to import %s: %s.\n", filename, err)
         return err
     }
 
-    for _, row := range rows[1:] {
-        vault := models.Vault{
-            UserID: user.ID,
-            Email: row[0],
-            Username: row[1],
-            Application: row[2],
-            Password: row[3],
+    if options.DryRun {
+        displayPlan(result.plans, options.Strategy)
+        for _, row := range result.Failed {
+            fmt.Printf("Row %d: %s\n", row.Line, row.Err)
         }
+        fmt.Printf("Dry run, nothing imported from %s\n", filename)
+        return nil
+    }
 
-        vault, err := models.VaultEntry(db, vault, user)
-        if nil != err {
+    if err == ErrImportFailed {
+        if encrypted {
+            /* The rows can't be looked up in an encrypted export, only list them */
+            reportImportErrors("", result.Failed)
+            fmt.Printf("Nothing imported, %d rows of %s failed\n", len(result.Failed), filename)
             return err
         }
-    }
 
+        report, rerr := reportImportErrors(filename, result.Failed)
+        if rerr != nil {
+            return rerr
+        }
+        fmt.Printf("Nothing imported, %d rows of %s failed (see %s, delete it once they're fixed)\n",
+            len(result.Failed), filename, report)
+        return err
+    }
+    if err != nil {
+        fmt.Printf("Nothing imported from %s: %s\n", filename, err)
+        return err
+    }
     fmt.Printf("Imported %s to vault\n", filename)
 
     return nil
 }
 
 /**
- * @brief:  Export a CSV with. Password will be in textbase.
+ * @brief:  Write the vault as a CSV with textbase passwords, entries that
+ *          can't be decrypted are skipped
  *
  * @param:  vaults - entries that will be exported
  * @param:  user - decrypt password
  *
- * @return: nil on success, else error
+ * @return: CSV on success, else error
  **/
-func ExportManager(vaults []models.Vault, user models.User) error {
-    filename := Filename(false)
-    file, err := os.Create(filename)
-    if nil != err {
-        return err
-    }
-    defer file.Close()
-
-    write := csv.NewWriter(file)
-    defer write.Flush()
+func ExportCSV(vaults []models.Vault, user models.User) ([]byte, error) {
+    var buf bytes.Buffer
+    write := csv.NewWriter(&buf)
 
-    err = write.Write(header)
+    err := write.Write(header)
     if nil != err {
-        return err
+        return nil, err
     }
 
     for _, vault := range vaults {
-        aes := compat.NewAES(user.SecretKey)
-        password, err := aes.Decrypt(vault.PasswordCipher)
-        if err != nil {
+        if err := models.DecryptEntry(&vault, user); err != nil {
             fmt.Printf("Failed to decrypt %s, skipping...\n", vault)
             continue
         }
-        vault.Password = password
 
-        var data = []string{vault.Email,
+        fields := ""
+        if len(vault.Payload) > 0 {
+            payload, err := json.Marshal(vault.Payload)
+            if err != nil {
+                return nil, err
+            }
+            fields = string(payload)
+        }
+
+        custom, err := formatCustom(vault.Fields)
+        if err != nil {
+            return nil, err
+        }
+
+        var data = []string{vault.ItemType(),
+                            vault.Email,
                             vault.Username,
                             vault.Application,
                             vault.Password,
+                            models.FormatURIs(vault.URIs),
+                            vault.Folder,
+                            strings.Join(vault.Tags, ", "),
+                            vault.Notes,
+                            vault.TOTP,
+                            fields,
+                            custom,
+                            vault.UpdatedAt.Format(time.RFC3339),
                            }
         err = write.Write(data)
         if nil != err {
+            return nil, err
+        }
+    }
+
+    write.Flush()
+    if err := write.Error(); err != nil {
+        return nil, err
+    }
+
+    return buf.Bytes(), nil
+}
+
+/**
+ * @brief:  Export the vault as a CSV. Unless plaintext is set, the CSV is
+ *          encrypted with a passphrase (see compat.SealArchive) and never
+ *          touches the disk unencrypted.
+ *
+ * @param:  vaults - entries that will be exported
+ * @param:  user - decrypt password
+ * @param:  plaintext - Write the CSV with textbase passwords as is
+ *
+ * @return: nil on success, else error
+ **/
+func ExportManager(vaults []models.Vault, user models.User, plaintext bool) error {
+    passphrase := ""
+    if !plaintext {
+        passphrase = models.HiddenInput("export passphrase")
+        if len(passphrase) < 8 {
+            return ErrPassphraseTooShort
+        }
+    }
+
+    data, err := ExportCSV(vaults, user)
+    if err != nil {
+        return err
+    }
+
+    ext := ".vdx"
+    if plaintext {
+        ext = ".csv"
+    } else {
+        data, err = compat.SealArchive(passphrase, data)
+        if err != nil {
             return err
         }
     }
 
+    filename := Filename(false, ext)
+    if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+        return err
+    }
+
     fmt.Printf("Exported vault to %s\n", filename)
 
     return nil
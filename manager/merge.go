@@ -0,0 +1,365 @@
+package manager
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/loerac/vaultDepot/models"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * What to do with an imported entry that matches an existing one
+ **/
+type MergeStrategy int
+
+const (
+    /* Keep the existing entry */
+    MergeSkip MergeStrategy = iota
+
+    /* Replace the existing entry with the imported one */
+    MergeOverwrite
+
+    /* Add the imported entry next to the existing one */
+    MergeKeepBoth
+
+    /* Keep whichever was modified last */
+    MergeNewestWins
+)
+
+var mergeStrategies = []string{"skip", "overwrite", "keep-both", "newest-wins"}
+
+/**
+ * Whether an imported entry is in the vault already
+ **/
+type importStatus int
+
+const (
+    importNew importStatus = iota
+    importChanged
+    importIdentical
+
+    /* Another row of the same file is imported instead */
+    importDuplicate
+)
+
+var importStatuses = []string{"new", "changed", "identical", "duplicate"}
+
+/**
+ * Options of an import
+ **/
+type ImportOptions struct {
+    /* Header name overrides from `--map` */
+    ColumnMap map[string]string
+
+    /* What to do with changed entries */
+    Strategy MergeStrategy
+
+    /* Only show what would be imported */
+    DryRun bool
+}
+
+/**
+ * Entry read from an import file
+ **/
+type importRow struct {
+    /* Row (or position) of the entry in the import file */
+    Line int
+
+    Vault models.Vault
+
+    /* When the entry was last modified, zero if unknown */
+    Modified time.Time
+}
+
+/**
+ * What an import is going to do with an entry
+ **/
+type importPlan struct {
+    importRow
+    Status importStatus
+
+    /* Matching entry in the vault, nil if new */
+    Existing *models.Vault
+
+    /* Other row of the file with the same entry, 0 if there's none */
+    Duplicate int
+}
+
+func (strategy MergeStrategy) String() string {
+    return mergeStrategies[strategy]
+}
+
+func (status importStatus) String() string {
+    return importStatuses[status]
+}
+
+/**
+ * @brief:  Parse the `--merge` option
+ *
+ * @param:  option - Value of the `--merge` option
+ *
+ * @return: Strategy on success, else ErrMergeInvalid
+ **/
+func ParseMergeStrategy(option string) (MergeStrategy, error) {
+    option = strings.ToLower(strings.TrimSpace(option))
+    for i, name := range mergeStrategies {
+        if option == name {
+            return MergeStrategy(i), nil
+        }
+    }
+
+    return MergeSkip, ErrMergeInvalid
+}
+
+/**
+ * @brief:  Parse the modified time of an imported entry. Accepts RFC 3339,
+ *          `YYYY-MM-DD[ HH:MM:SS]` and unix seconds.
+ *
+ * @param:  value - Modified column value
+ *
+ * @return: Time on success, zero time if empty or unknown format
+ **/
+func parseModified(value string) time.Time {
+    if value == "" {
+        return time.Time{}
+    }
+
+    for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+        if modified, err := time.Parse(layout, value); err == nil {
+            return modified
+        }
+    }
+
+    if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+        return time.Unix(secs, 0)
+    }
+
+    return time.Time{}
+}
+
+/**
+ * @brief:  Key entries are matched on: type, application, username and
+ *          email, normalized the same way the vault stores them
+ *
+ * @param:  vault - Entry to get the key of
+ *
+ * @return: Match key
+ **/
+func matchKey(vault models.Vault) string {
+    return strings.Join([]string{
+        strings.ToLower(vault.ItemType()),
+        strings.ToLower(vault.Application),
+        vault.Username,
+        strings.ToLower(strings.TrimSpace(vault.Email)),
+    }, "\x00")
+}
+
+/**
+ * @brief:  Check if an imported entry holds the same data as an existing one
+ *
+ * @param:  imported - Entry from the import file
+ * @param:  existing - Decrypted entry in the vault
+ *
+ * @return: true if nothing would change, else false
+ **/
+func sameEntry(imported models.Vault, existing models.Vault) bool {
+    if len(imported.Payload) != len(existing.Payload) {
+        return false
+    }
+    for name, value := range imported.Payload {
+        if existing.Payload[name] != value {
+            return false
+        }
+    }
+
+    if len(imported.Fields) != len(existing.Fields) {
+        return false
+    }
+    for i, field := range imported.Fields {
+        other := existing.Fields[i]
+        if field.Name != other.Name || field.Value != other.Value || field.Hidden != other.Hidden {
+            return false
+        }
+    }
+
+    return imported.Password == existing.Password &&
+           imported.Notes == existing.Notes &&
+           imported.TOTP == existing.TOTP &&
+           models.FormatURIs(imported.URIs) == models.FormatURIs(existing.URIs) &&
+           models.NormalizeFolder(imported.Folder) == existing.Folder &&
+           strings.Join(imported.Tags, ",") == strings.Join(existing.Tags, ",")
+}
+
+/**
+ * @brief:  Compare the imported entries against the user's vault. Rows of
+ *          the file with the same entry are merged like the vault is: skip
+ *          keeps the first, overwrite the last, newest-wins the one modified
+ *          last and keep-both imports all of them.
+ *
+ * @param:  db - pointer to dabase
+ * @param:  user - decrypt existing entries
+ * @param:  rows - Entries from the import file
+ * @param:  strategy - What to do with changed entries
+ *
+ * @return: Plan for each row on success, else error
+ **/
+func planImport(db *gorm.DB, user models.User, rows []importRow, strategy MergeStrategy) ([]importPlan, error) {
+    vaults, err := models.FindAll(db, user.ID)
+    if err != nil && err != models.ErrNotFound {
+        return nil, err
+    }
+
+    existing := map[string]*models.Vault{}
+    for i := range vaults {
+        if err := models.DecryptEntry(&vaults[i], user); err != nil {
+            fmt.Printf("Failed to decrypt %s, not matching against it...\n", vaults[i])
+            continue
+        }
+        existing[matchKey(vaults[i])] = &vaults[i]
+    }
+
+    /* Row of the file kept for each entry */
+    kept := map[string]int{}
+    plans := make([]importPlan, len(rows))
+    for i, row := range rows {
+        plans[i] = importPlan{importRow: row, Status: importNew}
+
+        key := matchKey(row.Vault)
+        if j, ok := kept[key]; ok {
+            plans[i].Duplicate, plans[j].Duplicate = plans[j].Line, row.Line
+
+            /* With keep-both, both are matched against the vault on their own */
+            if strategy != MergeKeepBoth {
+                replaces := strategy == MergeOverwrite ||
+                    (strategy == MergeNewestWins && row.Modified.After(plans[j].Modified))
+                if !replaces {
+                    plans[i].Status = importDuplicate
+                    continue
+                }
+                plans[j].Status, plans[j].Existing = importDuplicate, nil
+            }
+        }
+        kept[key] = i
+
+        match, ok := existing[key]
+        if !ok {
+            continue
+        }
+
+        plans[i].Existing = match
+        plans[i].Status = importChanged
+        if sameEntry(row.Vault, *match) {
+            plans[i].Status = importIdentical
+        }
+    }
+
+    return plans, nil
+}
+
+/**
+ * @brief:  Print what an import would do
+ *
+ * @param:  plans - Plan of each imported entry
+ * @param:  strategy - What to do with changed entries
+ **/
+func displayPlan(plans []importPlan, strategy MergeStrategy) {
+    counts := make([]int, len(importStatuses))
+    for _, plan := range plans {
+        counts[plan.Status]++
+        if plan.Duplicate != 0 {
+            fmt.Printf("%d.) %-9s %s (same entry as row %d)\n", plan.Line, plan.Status, plan.Vault, plan.Duplicate)
+        } else {
+            fmt.Printf("%d.) %-9s %s\n", plan.Line, plan.Status, plan.Vault)
+        }
+    }
+
+    fmt.Printf("\n%d new, %d changed (%s), %d identical, %d duplicates\n",
+        counts[importNew], counts[importChanged], strategy, counts[importIdentical], counts[importDuplicate])
+}
+
+/**
+ * @brief:  Validate and encrypt the planned entries, split in entries to
+ *          insert and entries to update. Nothing is written to the database.
+ *
+ * @param:  user - contains user ID
+ * @param:  plans - Plan of each imported entry
+ * @param:  strategy - What to do with changed entries
+ *
+ * @return: Entries to insert, entries to update and the rows that failed
+ **/
+func prepareImport(user models.User, plans []importPlan, strategy MergeStrategy) ([]models.Vault, []models.Vault, []RowError) {
+    var creates, updates []models.Vault
+    var failed []RowError
+
+    for _, plan := range plans {
+        vault := plan.Vault
+        vault.UserID = user.ID
+
+        update := false
+        switch plan.Status {
+        case importIdentical, importDuplicate:
+            continue
+
+        case importChanged:
+            switch strategy {
+            case MergeSkip:
+                continue
+
+            case MergeNewestWins:
+                if !plan.Modified.After(plan.Existing.UpdatedAt) {
+                    continue
+                }
+                fallthrough
+
+            case MergeOverwrite:
+                vault.Model = plan.Existing.Model
+                vault.ItemKeyCipher = plan.Existing.ItemKeyCipher
+                update = true
+            }
+        }
+
+        if err := models.ValidateImportedEntry(&vault, user); err != nil {
+            failed = append(failed, RowError{Line: plan.Line, Err: err})
+            continue
+        }
+
+        if update {
+            updates = append(updates, vault)
+        } else {
+            creates = append(creates, vault)
+        }
+    }
+
+    return creates, updates, failed
+}
+
+/**
+ * @brief:  Write the prepared entries in a single transaction, nothing is
+ *          written if any of them fails
+ *
+ * @param:  db - pointer to dabase
+ * @param:  creates - Validated entries to insert
+ * @param:  updates - Validated entries to update
+ *
+ * @return: nil on success, else error
+ **/
+func commitImport(db *gorm.DB, creates []models.Vault, updates []models.Vault) error {
+    err := db.Transaction(func(tx *gorm.DB) error {
+        if err := models.CreateVaultEntries(tx, creates); err != nil {
+            return err
+        }
+
+        return models.SaveVaultEntries(tx, updates)
+    })
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("%d new items added to your vault, %d updated\n", len(creates), len(updates))
+
+    return nil
+}
@@ -0,0 +1,263 @@
+package manager
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/loerac/vaultDepot/models"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+
+    "golang.org/x/crypto/openpgp"
+)
+
+/**
+ * Directory (relative to home) pass keeps its store in when
+ * PASSWORD_STORE_DIR isn't set
+ **/
+const passStoreDefault = ".password-store"
+
+/**
+ * @brief:  Import a pass (password-store) directory tree. Encrypted `.gpg`
+ *          files are decrypted with the keyring supplied by the user, any
+ *          other file is read as already decrypted. Entries are merged like
+ *          a CSV import (see ImportCSV), in a single transaction: if any
+ *          entry fails nothing is imported.
+ *
+ * @param:  db - pointer to dabase
+ * @param:  user - contains user ID
+ * @param:  options - Merge strategy and dry run
+ *
+ * @return: nil on success, ErrImportFailed if entries failed, else error
+ **/
+func PassImportManager(db *gorm.DB, user models.User, options ImportOptions) error {
+    store := os.Getenv("PASSWORD_STORE_DIR")
+    if store == "" {
+        home, _ := os.UserHomeDir()
+        store = filepath.Join(home, passStoreDefault)
+    }
+
+    input := readLine(fmt.Sprintf("Enter password store (default %s)", store))
+    if input != "" {
+        store = input
+    }
+
+    info, err := os.Stat(store)
+    if err != nil || !info.IsDir() {
+        return ErrStoreNotFound
+    }
+
+    keyring, err := passKeyring()
+    if err != nil {
+        return err
+    }
+
+    /* Path of each entry, by its position */
+    var paths []string
+    var entries []importRow
+    err = filepath.Walk(store, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+
+        /* Skip pass' own metadata, i.e. `.git` and `.gpg-id` */
+        if strings.HasPrefix(info.Name(), ".") && path != store {
+            if info.IsDir() {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        if info.IsDir() {
+            return nil
+        }
+
+        rel, err := filepath.Rel(store, path)
+        if err != nil {
+            return err
+        }
+
+        content, err := passReadFile(path, keyring)
+        if err != nil {
+            fmt.Printf("Failed to read %s: %s, skipping...\n", rel, err)
+            return nil
+        }
+
+        vault := parsePassEntry(rel, content)
+        vault.UserID = user.ID
+        paths = append(paths, rel)
+        entries = append(entries, importRow{Line: len(paths), Vault: vault})
+
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    plans, err := planImport(db, user, entries, options.Strategy)
+    if err != nil {
+        return err
+    }
+    if options.DryRun {
+        displayPlan(plans, options.Strategy)
+    }
+
+    creates, updates, failed := prepareImport(user, plans, options.Strategy)
+    for _, row := range failed {
+        fmt.Printf("Failed to import %s: %s\n", paths[row.Line - 1], row.Err)
+    }
+
+    if options.DryRun {
+        fmt.Printf("Dry run, nothing imported from %s\n", store)
+        return nil
+    }
+
+    /* All or nothing, like a CSV import */
+    if len(failed) > 0 {
+        fmt.Printf("Nothing imported, %d entries of %s failed\n", len(failed), store)
+        return ErrImportFailed
+    }
+
+    return commitImport(db, creates, updates)
+}
+
+/**
+ * @brief:  Ask the user for their keyring and unlock the private keys in it.
+ *          Both armored and binary keyrings are accepted.
+ *
+ * @return: Unlocked keyring on success, nil if no keyring given, else error
+ **/
+func passKeyring() (openpgp.EntityList, error) {
+    filename := readLine("Enter secret keyring file (empty for plain files only)")
+    if filename == "" {
+        return nil, nil
+    }
+
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+
+    keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+    if err != nil {
+        keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    passphrase, err := models.UserInput("Enter keyring passphrase")
+    if err != nil {
+        return nil, err
+    }
+
+    for _, entity := range keyring {
+        if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+            if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+                return nil, ErrKeyringLocked
+            }
+        }
+
+        for _, subkey := range entity.Subkeys {
+            if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+                if err := subkey.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+                    return nil, ErrKeyringLocked
+                }
+            }
+        }
+    }
+
+    return keyring, nil
+}
+
+/**
+ * @brief:  Read a file from the password store, decrypting it if it's a
+ *          `.gpg` file
+ *
+ * @param:  path - File in the password store
+ * @param:  keyring - Unlocked keyring, nil if none was given
+ *
+ * @return: Content of the file on success, else error
+ **/
+func passReadFile(path string, keyring openpgp.EntityList) (string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    if filepath.Ext(path) != ".gpg" {
+        data, err := ioutil.ReadAll(file)
+        return string(data), err
+    }
+
+    if keyring == nil {
+        return "", ErrKeyringRequired
+    }
+
+    md, err := openpgp.ReadMessage(file, keyring, nil, nil)
+    if err != nil {
+        return "", err
+    }
+
+    data, err := ioutil.ReadAll(md.UnverifiedBody)
+    return string(data), err
+}
+
+/**
+ * @brief:  Map a pass entry to a vault entry. The directories are the folder,
+ *          the file name is the application, the first line is the password
+ *          and the rest are custom fields (`key: value`) or notes.
+ *
+ * @param:  rel - Path of the entry relative to the password store
+ * @param:  content - Decrypted content of the entry
+ *
+ * @return: Vault entry, without the user ID
+ **/
+func parsePassEntry(rel string, content string) models.Vault {
+    rel = strings.TrimSuffix(rel, ".gpg")
+    folder, app := filepath.Split(rel)
+
+    lines := strings.Split(strings.TrimRight(content, "\r\n"), "\n")
+    vault := models.Vault{
+        Folder: strings.Trim(filepath.ToSlash(folder), "/"),
+        Application: app,
+        Password: strings.TrimRight(lines[0], "\r"),
+    }
+
+    var notes []string
+    for _, line := range lines[1:] {
+        line = strings.TrimRight(line, "\r")
+
+        key, value := "", ""
+        if i := strings.Index(line, ":"); i > 0 {
+            key = strings.ToLower(strings.TrimSpace(line[:i]))
+            value = strings.TrimSpace(line[i + 1:])
+        }
+
+        switch key {
+        case "login", "user", "username":
+            vault.Username = value
+        case "email", "mail":
+            vault.Email = value
+        case "":
+            notes = append(notes, line)
+        default:
+            vault.Fields = append(vault.Fields, models.Field{
+                Name: strings.TrimSpace(line[:strings.Index(line, ":")]),
+                Value: value,
+            })
+        }
+    }
+    vault.Notes = strings.TrimSpace(strings.Join(notes, "\n"))
+
+    /* pass users often log in with their email address */
+    if vault.Email == "" && strings.Contains(vault.Username, "@") {
+        vault.Email = vault.Username
+    }
+
+    return vault
+}
@@ -0,0 +1,63 @@
+package manager
+
+import (
+    "encoding/csv"
+    "fmt"
+    "os"
+    "strings"
+)
+
+/**
+ * Import row that couldn't be imported and why
+ **/
+type RowError struct {
+    Line int
+    Err error
+}
+
+/**
+ * @brief:  Print the rows that failed and write their row numbers and
+ *          errors to `<file>-errors.csv`, readable only by the user, so they
+ *          can be fixed in the original file and imported again. The rows
+ *          themselves hold passwords and aren't copied.
+ *
+ * @param:  filename - CSV that was imported, empty to only print the rows
+ * @param:  failed - Rows that failed
+ *
+ * @return: Name of the report on success (empty if not written), else error
+ **/
+func reportImportErrors(filename string, failed []RowError) (string, error) {
+    for _, row := range failed {
+        fmt.Printf("Row %d: %s\n", row.Line, row.Err)
+    }
+    if filename == "" {
+        return "", nil
+    }
+
+    report := strings.TrimSuffix(filename, ".csv") + "-errors.csv"
+    file, err := os.OpenFile(report, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0600)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    /* A report left by an older import may be readable by others */
+    if err := file.Chmod(0600); err != nil {
+        return "", err
+    }
+
+    write := csv.NewWriter(file)
+    defer write.Flush()
+
+    if err := write.Write([]string{"row", "error"}); err != nil {
+        return "", err
+    }
+
+    for _, row := range failed {
+        if err := write.Write([]string{fmt.Sprint(row.Line), row.Err.Error()}); err != nil {
+            return "", err
+        }
+    }
+
+    return report, nil
+}
@@ -0,0 +1,344 @@
+package models
+
+import (
+    "bufio"
+    "crypto/rand"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+const (
+    /* Attachments are encrypted and stored in chunks of this size */
+    attachmentChunkSize = 64 * 1024
+
+    /* Largest file that can be attached */
+    maxAttachmentSize = 10 * 1024 * 1024
+
+    /* Largest total size of the attachments of an entry */
+    maxEntryAttachments = 25 * 1024 * 1024
+)
+
+/**
+ * @brief:  Additional data a chunk is sealed with, so chunks can't be
+ *          swapped between attachments, reordered or dropped
+ *
+ * @param:  attachment - Attachment of the chunk
+ * @param:  seq - Position of the chunk
+ *
+ * @return: Additional data
+ **/
+func chunkAD(attachment Attachment, seq int) []byte {
+    ad := make([]byte, 16)
+    binary.BigEndian.PutUint64(ad, uint64(seq))
+    binary.BigEndian.PutUint64(ad[8:], uint64(attachment.Chunks))
+
+    return append(append([]byte{}, attachment.Ref...), ad...)
+}
+
+/**
+ * @brief:  Encrypt a file and attach it to a vault
+ *
+ * @param:  db - pointer to dabase
+ * @param:  vault - Vault to attach the file to
+ * @param:  user - User to cipher the file
+ * @param:  path - File to attach
+ *
+ * @return: Attachment on success, else error
+ **/
+func AddAttachment(db *gorm.DB, vault Vault, user User, path string) (Attachment, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return Attachment{}, err
+    }
+    defer file.Close()
+
+    info, err := file.Stat()
+    if err != nil {
+        return Attachment{}, err
+    }
+    if info.Size() > maxAttachmentSize {
+        return Attachment{}, ErrAttachmentTooLarge
+    }
+
+    var used int64
+    if err := db.Model(&Attachment{}).Where("vault_id = ?", vault.ID).
+        Select("COALESCE(SUM(size), 0)").Row().Scan(&used); err != nil {
+        return Attachment{}, err
+    }
+    if used + info.Size() > maxEntryAttachments {
+        return Attachment{}, ErrAttachmentsFull
+    }
+
+    aes, err := entryAES(vault, user)
+    if err != nil {
+        return Attachment{}, err
+    }
+    nameCipher, err := aes.Encrypt(filepath.Base(path))
+    if err != nil {
+        return Attachment{}, err
+    }
+
+    attachment := Attachment{
+        VaultID: vault.ID,
+        Name: filepath.Base(path),
+        NameCipher: nameCipher,
+        Size: info.Size(),
+        Chunks: int((info.Size() + attachmentChunkSize - 1) / attachmentChunkSize),
+        Ref: make([]byte, 16),
+    }
+    if _, err := io.ReadFull(rand.Reader, attachment.Ref); err != nil {
+        return Attachment{}, err
+    }
+
+    err = db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&attachment).Error; err != nil {
+            return err
+        }
+
+        buf := make([]byte, attachmentChunkSize)
+        for seq := 0; seq < attachment.Chunks; seq++ {
+            n, err := io.ReadFull(file, buf)
+            if err != nil && err != io.ErrUnexpectedEOF {
+                return err
+            }
+
+            dataCipher, err := aes.Seal(buf[:n], chunkAD(attachment, seq))
+            if err != nil {
+                return err
+            }
+
+            chunk := AttachmentChunk{
+                AttachmentID: attachment.ID,
+                Seq: seq,
+                DataCipher: dataCipher,
+            }
+            if err := tx.Create(&chunk).Error; err != nil {
+                return err
+            }
+        }
+
+        return nil
+    })
+    if err != nil {
+        return Attachment{}, err
+    }
+
+    return attachment, nil
+}
+
+/**
+ * @brief:  List the attachments of a vault with their names decrypted
+ *
+ * @param:  db - pointer to dabase
+ * @param:  vault - Vault to list the attachments of
+ * @param:  user - User to decipher the names
+ *
+ * @return: Attachments on success, else error
+ **/
+func FindAttachments(db *gorm.DB, vault Vault, user User) ([]Attachment, error) {
+    var attachments []Attachment
+    err := find(db.Where("vault_id = ?", vault.ID).Order("id"), &attachments)
+    if err != nil && err != ErrNotFound {
+        return nil, err
+    }
+
+    aes, err := entryAES(vault, user)
+    if err != nil {
+        return nil, err
+    }
+    for i := range attachments {
+        name, err := aes.Decrypt(attachments[i].NameCipher)
+        if err != nil {
+            return nil, err
+        }
+        attachments[i].Name = name
+    }
+
+    return attachments, nil
+}
+
+/**
+ * @brief:  Decrypt an attachment into a new file, an existing file is never
+ *          overwritten
+ *
+ * @param:  db - pointer to dabase
+ * @param:  vault - Vault the file is attached to
+ * @param:  attachment - Attachment to extract
+ * @param:  user - User to decipher the file
+ * @param:  path - File to create
+ *
+ * @return: nil on success, else error
+ **/
+func ExtractAttachment(db *gorm.DB, vault Vault, attachment Attachment, user User, path string) error {
+    aes, err := entryAES(vault, user)
+    if err != nil {
+        return err
+    }
+
+    file, err := os.OpenFile(path, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0600)
+    if err != nil {
+        return err
+    }
+
+    err = writeAttachment(db, attachment, aes, file)
+    if cerr := file.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        os.Remove(path)
+    }
+
+    return err
+}
+
+/**
+ * @brief:  Decrypt the chunks of an attachment in order
+ *
+ * @param:  db - pointer to dabase
+ * @param:  attachment - Attachment to decrypt
+ * @param:  aes - Key of the entry it's attached to
+ * @param:  w - Where to write the file
+ *
+ * @return: nil on success, ErrAttachmentDamaged if chunks are missing,
+ *          else error
+ **/
+func writeAttachment(db *gorm.DB, attachment Attachment, aes compat.AES, w io.Writer) error {
+    rows, err := db.Model(&AttachmentChunk{}).Where("attachment_id = ?", attachment.ID).
+        Order("seq").Select("seq, data_cipher").Rows()
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    seq := 0
+    for rows.Next() {
+        var chunkSeq int
+        var dataCipher []byte
+        if err := rows.Scan(&chunkSeq, &dataCipher); err != nil {
+            return err
+        }
+        if chunkSeq != seq {
+            return ErrAttachmentDamaged
+        }
+
+        data, err := aes.Open(dataCipher, chunkAD(attachment, seq))
+        if err != nil {
+            return ErrAttachmentDamaged
+        }
+        if _, err := w.Write(data); err != nil {
+            return err
+        }
+        seq++
+    }
+    if seq != attachment.Chunks {
+        return ErrAttachmentDamaged
+    }
+
+    return rows.Err()
+}
+
+/**
+ * @brief:  Remove an attachment and its chunks
+ *
+ * @param:  db - pointer to dabase
+ * @param:  id - ID of the attachment
+ *
+ * @return: nil on success, else error
+ **/
+func DeleteAttachment(db *gorm.DB, id uint) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("attachment_id = ?", id).Delete(&AttachmentChunk{}).Error; err != nil {
+            return err
+        }
+
+        return tx.Unscoped().Where("id = ?", id).Delete(&Attachment{}).Error
+    })
+}
+
+/**
+ * @brief:  Let the user add, extract or delete the attachments of an item
+ *
+ * @param:  db - pointer to dabase
+ * @param:  vault - Decrypted vault
+ * @param:  user - User to cipher the attachments
+ *
+ * @return: nil on success, else error
+ **/
+func ManageAttachments(db *gorm.DB, vault Vault, user User) error {
+    reader := bufio.NewReader(os.Stdin)
+    options := []string{"Add attachment", "Extract attachment", "Delete attachment", "Done"}
+
+    for {
+        attachments, err := FindAttachments(db, vault, user)
+        if err != nil {
+            return err
+        }
+        for i, attachment := range attachments {
+            fmt.Printf("%d.) %s (%d bytes)\n", i + 1, attachment.Name, attachment.Size)
+        }
+
+        for i, option := range options {
+            fmt.Printf("%d.) %s\n", i + 1, option)
+        }
+        fmt.Printf("Choose (1 - %d): ", len(options))
+
+        input := 0
+        fmt.Scanln(&input)
+        if input == len(options) {
+            return nil
+        }
+
+        switch input {
+        case 1:
+            fmt.Print("Enter file to attach: ")
+            path, _ := reader.ReadString('\n')
+
+            attachment, err := AddAttachment(db, vault, user, strings.TrimSpace(path))
+            if err != nil {
+                fmt.Printf("Failed to attach %s: %s\n", strings.TrimSpace(path), err)
+                break
+            }
+            fmt.Printf("Attached %s to %s\n", attachment.Name, vault)
+
+        case 2, 3:
+            entry := 0
+            fmt.Print("Enter attachment: ")
+            fmt.Scanln(&entry)
+            if entry < 1 || entry > len(attachments) {
+                fmt.Println("Attachment not found")
+                break
+            }
+            attachment := attachments[entry - 1]
+
+            if input == 3 {
+                if err := DeleteAttachment(db, attachment.ID); err != nil {
+                    return err
+                }
+                fmt.Printf("%s was deleted\n", attachment.Name)
+                break
+            }
+
+            fmt.Printf("Extract to (default %s): ", attachment.Name)
+            path, _ := reader.ReadString('\n')
+            path = strings.TrimSpace(path)
+            if path == "" {
+                path = filepath.Base(attachment.Name)
+            }
+
+            if err := ExtractAttachment(db, vault, attachment, user, path); err != nil {
+                fmt.Printf("Failed to extract %s: %s\n", attachment.Name, err)
+                break
+            }
+            fmt.Printf("Extracted %s to %s\n", attachment.Name, path)
+        }
+        fmt.Println()
+    }
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+    "fmt"
+
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * Most recent audit events listed by AuditEvents
+ **/
+const auditListLimit = 50
+
+/**
+ * @brief:  Keep a security event in the audit trail
+ *
+ * @param:  db - Pointer to database
+ * @param:  event - Event with its action set
+ *
+ * @return: nil on success, else error
+ **/
+func recordAudit(db *gorm.DB, event AuditEvent) error {
+    event.ID = 0
+    return db.Create(&event).Error
+}
+
+/**
+ * @brief:  Find the most recent security events of an account
+ *
+ * @param:  db - Pointer to database
+ * @param:  userID - ID of the account
+ *
+ * @return: Events, newest first, on success, else error
+ **/
+func AuditEvents(db *gorm.DB, userID uint) ([]AuditEvent, error) {
+    var events []AuditEvent
+    query := db.Where("user_id = ?", userID).Order("created_at desc").Limit(auditListLimit)
+    if err := find(query, &events); err != nil {
+        return nil, err
+    }
+
+    return events, nil
+}
+
+func (event AuditEvent) String() string {
+    return fmt.Sprintf("%s %s from %s: %s", event.CreatedAt.Format("2006-01-02 15:04"), event.Action, event.Source, event.Detail)
+}
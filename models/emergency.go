@@ -0,0 +1,550 @@
+package models
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+const (
+    /* Emergency contact can read the vault */
+    EmergencyRead = "read"
+
+    /* Emergency contact can read the vault or reset its credentials */
+    EmergencyTakeover = "takeover"
+
+    /* Nothing requested */
+    EmergencyIdle = "idle"
+
+    /* Requested, granted once the waiting period is over unless rejected */
+    EmergencyRequested = "requested"
+
+    /* Approved, or the waiting period went by */
+    EmergencyGranted = "granted"
+
+    /* Longest waiting period */
+    maxWaitDays = 90
+)
+
+/**
+ * @brief:  Get when a request for emergency access is granted
+ *
+ * @return: End of the waiting period, zero if nothing was requested
+ **/
+func (access EmergencyAccess) GrantsAt() time.Time {
+    if access.RequestedAt == nil {
+        return time.Time{}
+    }
+
+    return access.RequestedAt.AddDate(0, 0, access.WaitDays)
+}
+
+/**
+ * @brief:  Check if emergency access is granted, by approval or because the
+ *          waiting period went by without a rejection
+ *
+ * @param:  now - Current time
+ *
+ * @return: true if granted, else false
+ **/
+func (access EmergencyAccess) Granted(now time.Time) bool {
+    if access.Status == EmergencyGranted {
+        return true
+    }
+
+    return access.Status == EmergencyRequested && !now.Before(access.GrantsAt())
+}
+
+/**
+ * @brief:  Record an emergency access event in the audit logs of both users
+ *
+ * @param:  tx - Transaction
+ * @param:  grantor - Owner of the vault
+ * @param:  grantee - Emergency contact
+ * @param:  source - Address the request came from, LocalSource on the command line
+ * @param:  detail - What happened, the other username is appended
+ *
+ * @return: nil on success, else error
+ **/
+func recordEmergencyAudit(tx *gorm.DB, grantor User, grantee User, source string, detail string) error {
+    err := recordAudit(tx, AuditEvent{UserID: grantor.ID, Username: grantor.Username, Source: source,
+        Action: "emergency access", Detail: fmt.Sprintf("%s, contact %s", detail, grantee.Username)})
+    if err != nil {
+        return err
+    }
+
+    return recordAudit(tx, AuditEvent{UserID: grantee.ID, Username: grantee.Username, Source: source,
+        Action: "emergency access", Detail: fmt.Sprintf("%s, vault of %s", detail, grantor.Username)})
+}
+
+/**
+ * @brief:  Lock the emergency access between two users for the rest of a
+ *          transaction
+ *
+ * @param:  tx - Transaction
+ * @param:  grantorID - ID of the owner of the vault
+ * @param:  granteeID - ID of the emergency contact
+ *
+ * @return: Emergency access on success, ErrNotFound if there is none, else
+ *          error
+ **/
+func lockEmergencyAccess(tx *gorm.DB, grantorID uint, granteeID uint) (EmergencyAccess, error) {
+    var access EmergencyAccess
+    err := first(tx.Set("gorm:query_option", "FOR UPDATE").Where("grantor_id = ? AND grantee_id = ?",
+        grantorID, granteeID), &access)
+
+    return access, err
+}
+
+/**
+ * @brief:  Make a user an emergency contact, or change what they can do.
+ *          The vault key is sealed to their public key up front, and only
+ *          opened once access is granted. Changing a contact drops any
+ *          request or grant.
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantor - User with its vault key unlocked
+ * @param:  username - User to trust
+ * @param:  access - EmergencyRead or EmergencyTakeover
+ * @param:  waitDays - Days the grantor has to reject a request, 1 to 90
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the user doesn't exist, ErrNotFound
+ *          If it's the grantor, ErrEmergencySelf
+ *          If either user can't hold keys, ErrRemoteAccount or ErrShareNoKey
+ *          Else, error
+ **/
+func AddEmergencyContact(db *gorm.DB, grantor User, username string, access string, waitDays int, source string) error {
+    if access != EmergencyRead && access != EmergencyTakeover {
+        return ErrAccessInvalid
+    } else if waitDays < 1 || waitDays > maxWaitDays {
+        return ErrWaitDaysInvalid
+    } else if grantor.Remote {
+        return ErrRemoteAccount
+    } else if grantor.VaultKey == "" {
+        return ErrVaultLocked
+    }
+
+    grantee, err := ByUsername(db, username)
+    if err != nil {
+        return err
+    }
+    if grantee.ID == grantor.ID {
+        return ErrEmergencySelf
+    } else if grantee.Remote || len(grantee.PublicKey) == 0 {
+        return ErrShareNoKey
+    }
+
+    sealed, err := compat.SealTo(grantee.PublicKey, []byte(grantor.VaultKey))
+    if err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        contact, err := lockEmergencyAccess(tx, grantor.ID, grantee.ID)
+        if err == ErrNotFound {
+            contact = EmergencyAccess{GrantorID: grantor.ID, GranteeID: grantee.ID}
+        } else if err != nil {
+            return err
+        }
+        contact.Access, contact.WaitDays, contact.VaultKeyCipher = access, waitDays, sealed
+        contact.Status, contact.RequestedAt = EmergencyIdle, nil
+        if err := tx.Save(&contact).Error; err != nil {
+            return err
+        }
+
+        detail := fmt.Sprintf("%s access after %d days set up", access, waitDays)
+        return recordEmergencyAudit(tx, grantor, *grantee, source, detail)
+    })
+}
+
+/**
+ * @brief:  Fill in the usernames of emergency accesses
+ *
+ * @param:  db - Pointer to database
+ * @param:  contacts - Emergency accesses
+ * @param:  grantors - true for the usernames of the grantors, false for the
+ *                     grantees
+ *
+ * @return: nil on success, else error
+ **/
+func emergencyUsernames(db *gorm.DB, contacts []EmergencyAccess, grantors bool) error {
+    for i := range contacts {
+        id := contacts[i].GranteeID
+        if grantors {
+            id = contacts[i].GrantorID
+        }
+
+        var user User
+        if err := first(db.Where("id = ?", id), &user); err != nil {
+            return err
+        }
+        contacts[i].Username = user.Username
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Find the emergency contacts of a user
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantor - Owner of the vault
+ *
+ * @return: Emergency accesses with the usernames of the contacts on
+ *          success, else error
+ **/
+func EmergencyContacts(db *gorm.DB, grantor User) ([]EmergencyAccess, error) {
+    contacts := []EmergencyAccess{}
+    if err := db.Where("grantor_id = ?", grantor.ID).Order("id").Find(&contacts).Error; err != nil {
+        return nil, err
+    }
+
+    if err := emergencyUsernames(db, contacts, false); err != nil {
+        return nil, err
+    }
+
+    return contacts, nil
+}
+
+/**
+ * @brief:  Find whom a user is the emergency contact of
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantee - Emergency contact
+ *
+ * @return: Emergency accesses with the usernames of the vault owners on
+ *          success, else error
+ **/
+func TrustedBy(db *gorm.DB, grantee User) ([]EmergencyAccess, error) {
+    contacts := []EmergencyAccess{}
+    if err := db.Where("grantee_id = ?", grantee.ID).Order("id").Find(&contacts).Error; err != nil {
+        return nil, err
+    }
+
+    if err := emergencyUsernames(db, contacts, true); err != nil {
+        return nil, err
+    }
+
+    return contacts, nil
+}
+
+/**
+ * @brief:  Stop trusting an emergency contact, dropping the vault key
+ *          sealed to them
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantor - Owner of the vault
+ * @param:  username - Emergency contact
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success, ErrNotFound if the user isn't a contact, else
+ *          error
+ **/
+func RemoveEmergencyContact(db *gorm.DB, grantor User, username string, source string) error {
+    grantee, err := ByUsername(db, username)
+    if err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        contact, err := lockEmergencyAccess(tx, grantor.ID, grantee.ID)
+        if err != nil {
+            return err
+        }
+        if err := tx.Unscoped().Delete(&contact).Error; err != nil {
+            return err
+        }
+
+        return recordEmergencyAudit(tx, grantor, *grantee, source, "emergency contact removed")
+    })
+}
+
+/**
+ * @brief:  Ask for emergency access to a vault. It's granted once the
+ *          waiting period is over, unless the owner rejects it first; the
+ *          owner is told in their security events.
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantee - Emergency contact
+ * @param:  username - Owner of the vault
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: Emergency access as requested on success
+ *          If the user isn't trusted by the owner, ErrNotFound
+ *          If a request is open or granted, ErrEmergencyRequested
+ *          Else, error
+ **/
+func RequestEmergencyAccess(db *gorm.DB, grantee User, username string, source string) (EmergencyAccess, error) {
+    grantor, err := ByUsername(db, username)
+    if err != nil {
+        return EmergencyAccess{}, err
+    }
+
+    var contact EmergencyAccess
+    err = db.Transaction(func(tx *gorm.DB) error {
+        contact, err = lockEmergencyAccess(tx, grantor.ID, grantee.ID)
+        if err != nil {
+            return err
+        } else if contact.Status != EmergencyIdle {
+            return ErrEmergencyRequested
+        }
+
+        now := time.Now()
+        contact.Status, contact.RequestedAt = EmergencyRequested, &now
+        err := tx.Model(&contact).UpdateColumns(map[string]interface{}{
+            "status": contact.Status,
+            "requested_at": contact.RequestedAt,
+        }).Error
+        if err != nil {
+            return err
+        }
+
+        detail := fmt.Sprintf("%s access requested, granted on %s unless rejected",
+            contact.Access, contact.GrantsAt().Format("2006-01-02 15:04"))
+        return recordEmergencyAudit(tx, *grantor, grantee, source, detail)
+    })
+    if err != nil {
+        return EmergencyAccess{}, err
+    }
+    contact.Username = grantor.Username
+
+    return contact, nil
+}
+
+/**
+ * @brief:  Grant a request for emergency access before the waiting period
+ *          is over
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantor - Owner of the vault
+ * @param:  username - Emergency contact
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the user isn't a contact, ErrNotFound
+ *          If nothing is requested, ErrEmergencyNotRequested
+ *          Else, error
+ **/
+func ApproveEmergencyAccess(db *gorm.DB, grantor User, username string, source string) error {
+    return decideEmergencyAccess(db, grantor, username, source, true)
+}
+
+/**
+ * @brief:  Reject a request for emergency access, or take back access that
+ *          was granted. The contact stays trusted and can ask again.
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantor - Owner of the vault
+ * @param:  username - Emergency contact
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the user isn't a contact, ErrNotFound
+ *          If nothing is requested or granted, ErrEmergencyNotRequested
+ *          Else, error
+ **/
+func RejectEmergencyAccess(db *gorm.DB, grantor User, username string, source string) error {
+    return decideEmergencyAccess(db, grantor, username, source, false)
+}
+
+/**
+ * @brief:  Approve or reject emergency access, see ApproveEmergencyAccess
+ *          and RejectEmergencyAccess
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantor - Owner of the vault
+ * @param:  username - Emergency contact
+ * @param:  source - Address the request came from, LocalSource on the command line
+ * @param:  approve - true to approve, false to reject
+ *
+ * @return: nil on success, else error
+ **/
+func decideEmergencyAccess(db *gorm.DB, grantor User, username string, source string, approve bool) error {
+    grantee, err := ByUsername(db, username)
+    if err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        contact, err := lockEmergencyAccess(tx, grantor.ID, grantee.ID)
+        if err != nil {
+            return err
+        }
+
+        status, detail := EmergencyIdle, fmt.Sprintf("%s access rejected", contact.Access)
+        if approve {
+            if contact.Status != EmergencyRequested {
+                return ErrEmergencyNotRequested
+            }
+            status, detail = EmergencyGranted, fmt.Sprintf("%s access approved", contact.Access)
+        } else if contact.Status == EmergencyIdle {
+            return ErrEmergencyNotRequested
+        }
+
+        update := map[string]interface{}{"status": status}
+        if status == EmergencyIdle {
+            update["requested_at"] = nil
+        }
+        if err := tx.Model(&contact).UpdateColumns(update).Error; err != nil {
+            return err
+        }
+
+        return recordEmergencyAudit(tx, grantor, *grantee, source, detail)
+    })
+}
+
+/**
+ * @brief:  Open the vault key of a grantor for their emergency contact, once
+ *          access is granted. A request whose waiting period went by is
+ *          marked granted first.
+ *
+ * @param:  tx - Transaction
+ * @param:  grantee - Emergency contact with its vault key unlocked
+ * @param:  username - Owner of the vault
+ * @param:  access - Access needed, EmergencyRead or EmergencyTakeover
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: Grantor with its vault key on success
+ *          If the user isn't trusted by the owner, ErrNotFound
+ *          If the access doesn't allow a takeover, ErrEmergencyNoTakeover
+ *          If access isn't granted, ErrEmergencyWaiting
+ *          Else, error
+ **/
+func openEmergencyAccess(tx *gorm.DB, grantee User, username string, access string, source string) (*User, error) {
+    grantor, err := ByUsername(tx, username)
+    if err != nil {
+        return nil, err
+    }
+
+    contact, err := lockEmergencyAccess(tx, grantor.ID, grantee.ID)
+    if err != nil {
+        return nil, err
+    }
+    if access == EmergencyTakeover && contact.Access != EmergencyTakeover {
+        return nil, ErrEmergencyNoTakeover
+    }
+    if !contact.Granted(time.Now()) {
+        return nil, ErrEmergencyWaiting
+    }
+
+    if contact.Status == EmergencyRequested {
+        if err := tx.Model(&contact).UpdateColumn("status", EmergencyGranted).Error; err != nil {
+            return nil, err
+        }
+
+        detail := fmt.Sprintf("%s access granted, not rejected within %d days", contact.Access, contact.WaitDays)
+        if err := recordEmergencyAudit(tx, *grantor, grantee, source, detail); err != nil {
+            return nil, err
+        }
+    }
+
+    vaultKey, err := openSealedKey(grantee, contact.VaultKeyCipher)
+    if err != nil {
+        return nil, err
+    }
+    grantor.VaultKey = vaultKey
+
+    return grantor, nil
+}
+
+/**
+ * @brief:  Open the vault of a user as their emergency contact, once access
+ *          is granted. Each time shows up in the security events of both.
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantee - Emergency contact with its vault key unlocked
+ * @param:  username - Owner of the vault
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: Owner with its vault key unlocked, to read the vault with, on
+ *          success
+ *          If the user isn't trusted by the owner, ErrNotFound
+ *          If access isn't granted, ErrEmergencyWaiting
+ *          Else, error
+ **/
+func OpenEmergencyVault(db *gorm.DB, grantee User, username string, source string) (*User, error) {
+    var grantor *User
+    err := db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        grantor, err = openEmergencyAccess(tx, grantee, username, EmergencyRead, source)
+        if err != nil {
+            return err
+        }
+
+        return recordEmergencyAudit(tx, *grantor, grantee, source, "vault opened with emergency access")
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return grantor, nil
+}
+
+/**
+ * @brief:  Take over the account of a user as their emergency contact, once
+ *          takeover access is granted. The password and secret key are
+ *          reset like a recovery, two-factor login is turned off so the
+ *          contact can log in, and every session is revoked.
+ *
+ * @param:  db - Pointer to database
+ * @param:  grantee - Emergency contact with its vault key unlocked
+ * @param:  username - Owner of the account
+ * @param:  reset - New password and secret key
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the user isn't trusted by the owner, ErrNotFound
+ *          If the access doesn't allow a takeover, ErrEmergencyNoTakeover
+ *          If access isn't granted, ErrEmergencyWaiting
+ *          Else, error
+ **/
+func TakeOverAccount(db *gorm.DB, grantee User, username string, reset User, source string) error {
+    err := runUserValFns(&reset,
+        userPasswordRequired,
+        secretKeyRequired,
+        passwordMinLength,
+        secretKeyMinLength,
+    )
+    if err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        grantor, err := openEmergencyAccess(tx, grantee, username, EmergencyTakeover, source)
+        if err != nil {
+            return err
+        }
+
+        reset.VaultKey = grantor.VaultKey
+        if err := runUserValFns(&reset, wrapVaultKey, bcryptPassword, bcryptSecretKey); err != nil {
+            return err
+        }
+
+        err = tx.Model(grantor).UpdateColumns(map[string]interface{}{
+            "password_hash": reset.PasswordHash,
+            "secret_key_hash": reset.SecretKeyHash,
+            "pepper_version": reset.PepperVersion,
+            "vault_key_cipher": reset.VaultKeyCipher,
+            "totp_cipher": nil,
+            "totp_pending_cipher": nil,
+        }).Error
+        if err != nil {
+            return err
+        }
+
+        if err := tx.Unscoped().Where("user_id = ?", grantor.ID).Delete(&RecoveryCode{}).Error; err != nil {
+            return err
+        }
+        if err := RevokeSessions(tx, grantor.ID, 0); err != nil {
+            return err
+        }
+
+        return recordEmergencyAudit(tx, *grantor, grantee, source,
+            "account taken over with emergency access, password and secret key reset, two-factor login off")
+    })
+}
@@ -23,8 +23,119 @@ var (
     /* Return when password length less than 8 */
     ErrPasswordTooShort modelError = "models: Password must be at least 8 characters long"
 
-    /* Return when invalid password is used */
-    ErrPasswordIncorrect modelError = "models: Incorrect password"
+    /* Return when a login fails, whichever of the credentials was wrong */
+    ErrLoginFailed modelError = "models: Incorrect username, password or secret key"
+
+    /* Return when an account or source failed to log in too often */
+    ErrLoginThrottled modelError = "models: Too many failed logins, try again later"
+
+    /* Return when the credentials are right but the two-factor code is missing */
+    ErrTwoFactorRequired modelError = "models: Two-factor code is required"
+
+    /* Return when a two-factor or recovery code is wrong or was used before */
+    ErrTwoFactorInvalid modelError = "models: Two-factor code is incorrect or was already used"
+
+    /* Return when enrolling an account that already has two-factor login */
+    ErrTwoFactorEnabled modelError = "models: Two-factor authentication is already on"
+
+    /* Return when an account has no two-factor login, or no enrollment to confirm */
+    ErrTwoFactorDisabled modelError = "models: Two-factor authentication is off"
+
+    /* Return when the TOTP secret of an older backup was encrypted with another server's key */
+    ErrTwoFactorRestore modelError = "models: Two-factor secret of the backup only opens on the server it was made on"
+
+    /* Return when a hash was made with a pepper version that isn't configured */
+    ErrPepperUnknown privateError = "models: Hash uses a pepper version that isn't configured"
+
+    /* Return when a configured pepper isn't "<version>:<secret>" with a long enough secret */
+    ErrPepperInvalid privateError = "models: Peppers must be \"<version>:<secret>\", secrets at least 16 characters long"
+
+    /* Return when a configured pepper uses version 1, which is the built-in pepper */
+    ErrPepperReserved privateError = "models: Pepper version 1 is the built-in pepper, configure versions from 2 on"
+
+    /* Return when others may read the pepper file */
+    ErrPepperFileMode privateError = "models: Pepper file must only be readable by its owner"
+
+    /* Return when the HMAC or TOTP key of the server isn't configured */
+    ErrServerKeyMissing privateError = "models: Set VAULTDEPOT_HMAC_KEY and VAULTDEPOT_TOTP_KEY, or their _FILE variants"
+
+    /* Return when a key of the server is short or the built-in one */
+    ErrServerKeyInvalid privateError = "models: Server keys must be at least 32 characters and not the built-in ones"
+
+    /* Return when others may read the file of a server key */
+    ErrServerKeyFileMode privateError = "models: Server key files must only be readable by their owner"
+
+    /* Return when the configured bcrypt cost is out of range */
+    ErrBcryptCostInvalid privateError = "models: bcrypt cost must be between 4 and 31"
+
+    /* Return when a share permission is neither read-only nor read-write */
+    ErrPermissionInvalid modelError = "models: Permission must be read-only or read-write"
+
+    /* Return when an entry is shared with its owner */
+    ErrShareSelf modelError = "models: Entry can't be shared with its owner"
+
+    /* Return when an entry is shared with a user without a key pair */
+    ErrShareNoKey modelError = "models: User has to log in once before entries can be shared with them"
+
+    /* Return when an entry shared read-only is edited */
+    ErrShareReadOnly modelError = "models: Entry is shared read-only"
+
+    /* Return when a shared entry was re-encrypted while it was edited */
+    ErrShareChanged modelError = "models: Entry was re-encrypted meanwhile, open it again"
+
+    /* Return when an organization or collection has no name */
+    ErrOrgNameRequired modelError = "models: Name is required"
+
+    /* Return when an organization name is already taken */
+    ErrOrgNameTaken modelError = "models: Organization name is already taken"
+
+    /* Return when a collection name is already taken in its organization */
+    ErrCollectionTaken modelError = "models: Organization already has a collection with that name"
+
+    /* Return when a collection with entries is deleted */
+    ErrCollectionNotEmpty modelError = "models: Collection still has entries"
+
+    /* Return when a role of a member isn't known */
+    ErrRoleInvalid modelError = "models: Role must be owner, admin, member or read-only"
+
+    /* Return when the role of a member doesn't allow the change */
+    ErrRoleForbidden modelError = "models: Your role in the organization doesn't allow this"
+
+    /* Return when a user is added to an organization twice */
+    ErrMemberExists modelError = "models: User is already a member of the organization"
+
+    /* Return when the last owner of an organization would leave or be demoted */
+    ErrLastOwner modelError = "models: Organization needs at least one owner"
+
+    /* Return when the organization key was replaced while it was in use */
+    ErrOrgRekeyed modelError = "models: Organization key changed meanwhile, open it again"
+
+    /* Return when an emergency access isn't known */
+    ErrAccessInvalid modelError = "models: Emergency access must be read or takeover"
+
+    /* Return when a waiting period is out of range */
+    ErrWaitDaysInvalid modelError = "models: Waiting period must be 1 to 90 days"
+
+    /* Return when a user is made their own emergency contact */
+    ErrEmergencySelf modelError = "models: You can't be your own emergency contact"
+
+    /* Return when emergency access is requested while a request is open */
+    ErrEmergencyRequested modelError = "models: Emergency access was already requested"
+
+    /* Return when emergency access is approved or rejected without a request */
+    ErrEmergencyNotRequested modelError = "models: Emergency access wasn't requested"
+
+    /* Return when emergency access is used before it's granted */
+    ErrEmergencyWaiting modelError = "models: Emergency access isn't granted yet"
+
+    /* Return when read-only emergency access is used to take over an account */
+    ErrEmergencyNoTakeover modelError = "models: Emergency access doesn't allow taking over the account"
+
+    /* Return when a username or recovery key is wrong, whichever it was */
+    ErrRecoveryFailed modelError = "models: Incorrect username or recovery key"
+
+    /* Return when the vault key of a user isn't unlocked */
+    ErrVaultLocked privateError = "models: Vault key is locked"
 
     /* Return when secret key isn't provided*/
     ErrSecretKeyRequired modelError = "models: Secret key is required"
@@ -32,9 +143,6 @@ var (
     /* Return when secret key length less than 8 */
     ErrSecretKeyTooShort modelError = "models: Secret key must be at least 8 characters long"
 
-    /* Return when invalid password is used */
-    ErrSecretKeyIncorrect modelError = "models: Incorrect secret key"
-
     /* Return when an email address isn't provided */
     ErrEmailRequired modelError = "models: Email address is required"
 
@@ -44,14 +152,68 @@ var (
     /* Return when an provided email is already taken */
     ErrEmailTaken modelError = "models: Email address is already taken"
 
+    /* Return when a username isn't provided */
+    ErrUsernameRequired modelError = "models: Username is required"
+
+    /* Return when a provided username is already taken */
+    ErrUsernameTaken modelError = "models: Username is already taken"
+
     /* Return when a remember token hash isn't provided */
     ErrRememberRequired privateError = "models: Remember token is required"
 
     /* Return when remember token isn't at least 32 bytes */
     ErrRememberTooShort privateError = "models: Remember token must be at least 32 bytes"
 
+    /* Return when a remote client's auth hash isn't a hex encoded 32 byte key */
+    ErrAuthHashInvalid modelError = "models: Auth hash must be 64 hexadecimal characters"
+
+    /* Return when a remote account logs in without a vaultDepot client */
+    ErrRemoteAccount modelError = "models: Account is only reachable with a vaultDepot client (--server)"
+
+    /* Return when a sealed remote entry is empty or too large */
+    ErrSealedInvalid modelError = "models: Sealed entry is empty or too large"
+
+    /* Return when a remember token is unknown, expired or revoked */
+    ErrSessionInvalid modelError = "models: Session expired or was revoked"
+
     /* Return when application isn't provided */
     ErrApplicationRequired modelError = "models: Application is required"
+
+    /* Return when an item type isn't known */
+    ErrTypeInvalid modelError = "models: Item type must be login, note, card, identity or apikey"
+
+    /* Return when a secure note has no note */
+    ErrNoteRequired modelError = "models: Note is required"
+
+    /* Return when a required field of the item type isn't provided */
+    ErrFieldRequired modelError = "models: A required field of the item is missing"
+
+    /* Return when a TOTP secret or otpauth URI isn't valid */
+    ErrTOTPInvalid modelError = "models: TOTP secret must be base32 or an otpauth:// URI"
+
+    /* Return when an entry has no TOTP secret */
+    ErrTOTPRequired modelError = "models: Entry has no TOTP secret"
+
+    /* Return when a file is larger than maxAttachmentSize */
+    ErrAttachmentTooLarge modelError = "models: Attachments can be at most 10 MiB"
+
+    /* Return when an entry's attachments would exceed maxEntryAttachments */
+    ErrAttachmentsFull modelError = "models: Attachments of an entry can be at most 25 MiB in total"
+
+    /* Return when an attachment's chunks are missing or were tampered with */
+    ErrAttachmentDamaged modelError = "models: Attachment is damaged"
+
+    /* Return when a custom field has no name */
+    ErrFieldNameRequired modelError = "models: Field name is required"
+
+    /* Return when a batched insert got or wrote another number of rows than it has entries */
+    ErrBatchMismatch privateError = "models: Batched insert didn't match the number of entries"
+
+    /* Return when a URI has no host or isn't a valid regular expression */
+    ErrURIInvalid modelError = "models: URI must be a URL or a valid regular expression"
+
+    /* Return when a URI's match mode isn't known */
+    ErrMatchInvalid modelError = "models: URI match must be domain, host, startswith, regex or exact"
 )
 
 func (err modelError) Error() string {
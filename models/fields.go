@@ -0,0 +1,362 @@
+package models
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * @brief:  Check every custom field has a name, and trim it
+ *
+ * @param:  vault - Contains custom fields
+ *
+ * @return: nil on success, else ErrFieldNameRequired
+ **/
+func fieldNamesRequired(vault *Vault, user User) error {
+    for i := range vault.Fields {
+        vault.Fields[i].Name = strings.TrimSpace(vault.Fields[i].Name)
+        if vault.Fields[i].Name == "" {
+            return ErrFieldNameRequired
+        }
+    }
+
+    return nil
+}
+
+/**
+ * Columns that held the field names and values in plaintext before they
+ * were encrypted
+ **/
+var plaintextFieldColumns = []string{"name", "value"}
+
+/**
+ * @brief:  Encrypt the name and value of the custom fields with the key of
+ *          the entry. Hidden only masks the value when it's displayed.
+ *
+ * @param:  vault - Contains custom fields
+ * @param:  user - User to cipher fields
+ *
+ * @return: nil on success, else error
+ **/
+func encryptFields(vault *Vault, user User) error {
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    for i := range vault.Fields {
+        field := &vault.Fields[i]
+
+        nameCipher, err := aes.Encrypt(field.Name)
+        if err != nil {
+            return err
+        }
+        valueCipher, err := aes.Encrypt(field.Value)
+        if err != nil {
+            return err
+        }
+        field.NameCipher = nameCipher
+        field.ValueCipher = valueCipher
+
+        /* Forget textbase value of hidden fields */
+        if field.Hidden {
+            field.Value = ""
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Decrypt the custom fields of a vault. Fields stored before they
+ *          were encrypted keep their plaintext (see LoadFields).
+ *
+ * @param:  vault - Contains custom fields
+ * @param:  user - User to decipher fields
+ *
+ * @return: nil on success, else error
+ **/
+func decryptFields(vault *Vault, user User) error {
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    for i := range vault.Fields {
+        field := &vault.Fields[i]
+
+        columns := []struct {
+            cipher []byte
+            value  *string
+        }{
+            {field.NameCipher, &field.Name},
+            {field.ValueCipher, &field.Value},
+        }
+        for _, column := range columns {
+            if len(column.cipher) == 0 {
+                continue
+            }
+
+            value, err := aes.Decrypt(column.cipher)
+            if err != nil {
+                return err
+            }
+            *column.value = value
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Check if a vault has custom fields stored in plaintext
+ *
+ * @param:  vault - Vault with its fields loaded
+ *
+ * @return: true if a field has no encrypted name, else false
+ **/
+func plaintextFields(vault Vault) bool {
+    for _, field := range vault.Fields {
+        if len(field.NameCipher) == 0 {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * @brief:  Encrypt the custom fields left in plaintext from before they were
+ *          encrypted
+ *
+ * @param:  db - pointer to dabase
+ * @param:  vaults - Vaults as stored, their fields are loaded
+ * @param:  user - User to cipher fields, empty for entries with an item key
+ *
+ * @return: nil on success, else error
+ **/
+func encryptPlaintextFields(db *gorm.DB, vaults []Vault, user User) error {
+    if len(vaults) == 0 || !db.Dialect().HasColumn(db.NewScope(&Field{}).TableName(), "name") {
+        return nil
+    }
+    if err := LoadFields(db, vaults); err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        for i := range vaults {
+            vault := vaults[i]
+            if !plaintextFields(vault) {
+                continue
+            }
+
+            /* Hidden values were encrypted already */
+            err := runVaultValFns(&vault, user, decryptFields, encryptFields)
+            if err != nil {
+                return err
+            }
+            if err := saveFields(tx, &vault); err != nil {
+                return err
+            }
+        }
+
+        return nil
+    })
+}
+
+/**
+ * @brief:  Replace the custom fields stored for a vault with the validated
+ *          fields (see ValidateVaultEntry) it holds
+ *
+ * @param:  db - pointer to dabase
+ * @param:  vault - Saved vault with validated fields
+ *
+ * @return: nil on success, else error
+ **/
+func saveFields(db *gorm.DB, vault *Vault) error {
+    if err := db.Unscoped().Where("vault_id = ?", vault.ID).Delete(&Field{}).Error; err != nil {
+        return err
+    }
+
+    for i := range vault.Fields {
+        vault.Fields[i].ID = 0
+        vault.Fields[i].VaultID = vault.ID
+        if err := db.Create(&vault.Fields[i]).Error; err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Load the custom fields of vaults, still encrypted. Fields stored
+ *          before they were encrypted come with their plaintext.
+ *
+ * @param:  db - pointer to dabase
+ * @param:  vaults - Vaults to load the fields of
+ *
+ * @return: nil on success, else error
+ **/
+func LoadFields(db *gorm.DB, vaults []Vault) error {
+    if len(vaults) == 0 {
+        return nil
+    }
+
+    ids := make([]uint, len(vaults))
+    index := map[uint]int{}
+    for i, vault := range vaults {
+        ids[i] = vault.ID
+        index[vault.ID] = i
+        vaults[i].Fields = nil
+    }
+
+    var fields []Field
+    err := find(db.Where("vault_id IN (?)", ids).Order("id"), &fields)
+    if err != nil && err != ErrNotFound {
+        return err
+    }
+
+    /* Fields stored before they were encrypted, see encryptPlaintextFields */
+    plaintext := map[uint][2]string{}
+    table := db.NewScope(&Field{}).TableName()
+    if db.Dialect().HasColumn(table, plaintextFieldColumns[0]) {
+        rows, err := db.Raw("SELECT id, COALESCE(name, ''), COALESCE(value, '') FROM " + table +
+            " WHERE vault_id IN (?) AND name_cipher IS NULL", ids).Rows()
+        if err != nil {
+            return err
+        }
+        for rows.Next() {
+            var id uint
+            var name, value string
+            if err := rows.Scan(&id, &name, &value); err != nil {
+                rows.Close()
+                return err
+            }
+            plaintext[id] = [2]string{name, value}
+        }
+        rows.Close()
+    }
+
+    for _, field := range fields {
+        if legacy, ok := plaintext[field.ID]; ok {
+            field.Name, field.Value = legacy[0], legacy[1]
+        }
+
+        i := index[field.VaultID]
+        vaults[i].Fields = append(vaults[i].Fields, field)
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Display the custom fields of a vault, hidden ones are masked
+ *
+ * @param:  fields - Decrypted custom fields
+ **/
+func displayFields(fields []Field) {
+    for i, field := range fields {
+        value := field.Value
+        if field.Hidden {
+            value = "********"
+        }
+        fmt.Printf("%d.) %s: %s\n", i + 1, field.Name, value)
+    }
+}
+
+/**
+ * @brief:  Let the user add, change, hide or remove the custom fields of an
+ *          item and save them
+ *
+ * @param:  db - pointer to dabase
+ * @param:  vault - Decrypted vault
+ * @param:  user - User to cipher the fields
+ *
+ * @return: Updated vault on success, else error
+ **/
+func EditFields(db *gorm.DB, vault Vault, user User) (Vault, error) {
+    reader := bufio.NewReader(os.Stdin)
+    options := []string{"Add field", "Change value", "Hide or show field", "Remove field", "Done"}
+
+    for {
+        displayFields(vault.Fields)
+        for i, option := range options {
+            fmt.Printf("%d.) %s\n", i + 1, option)
+        }
+        fmt.Printf("Choose (1 - %d): ", len(options))
+
+        input := 0
+        fmt.Scanln(&input)
+        if input == len(options) {
+            break
+        }
+
+        switch input {
+        case 1:
+            fmt.Print("Enter field name: ")
+            name, _ := reader.ReadString('\n')
+
+            char_input := ""
+            fmt.Print("Hidden? (y or n): ")
+            fmt.Scanln(&char_input)
+            hidden := strings.ToLower(char_input) == "y"
+
+            vault.Fields = append(vault.Fields, Field{
+                Name: strings.TrimSpace(name),
+                Hidden: hidden,
+                Value: askFieldValue(reader, name, hidden),
+            })
+
+        case 2, 3, 4:
+            entry := 0
+            fmt.Print("Enter field: ")
+            fmt.Scanln(&entry)
+            if entry < 1 || entry > len(vault.Fields) {
+                fmt.Println("Field not found")
+                continue
+            }
+
+            field := &vault.Fields[entry - 1]
+            switch input {
+            case 2:
+                field.Value = askFieldValue(reader, field.Name, field.Hidden)
+            case 3:
+                field.Hidden = !field.Hidden
+            case 4:
+                vault.Fields = append(vault.Fields[:entry - 1], vault.Fields[entry:]...)
+            }
+        }
+        fmt.Println()
+    }
+
+    if err := UpdateVaultEntry(db, &vault, user); err != nil {
+        return Vault{}, err
+    }
+
+    return ByID(db, vault.ID, user)
+}
+
+/**
+ * @brief:  Ask for the value of a custom field
+ *
+ * @param:  reader - Reads visible values
+ * @param:  name - Name of the field
+ * @param:  hidden - Ask without echo
+ *
+ * @return: Value of the field
+ **/
+func askFieldValue(reader *bufio.Reader, name string, hidden bool) string {
+    name = strings.TrimSpace(name)
+    if hidden {
+        return HiddenInput(name)
+    }
+
+    fmt.Printf("Enter %s: ", name)
+    value, _ := reader.ReadString('\n')
+
+    return strings.TrimSpace(value)
+}
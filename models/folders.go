@@ -0,0 +1,494 @@
+package models
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * Folders are nested with this separator, e.g. "Work/Servers"
+ **/
+const folderSeparator = "/"
+
+/**
+ * Entered on update to remove the folder or tags of an item
+ **/
+const clearValue = "-"
+
+/**
+ * Column that held the folder in plaintext before it was encrypted
+ **/
+const plaintextFolderColumn = "folder"
+
+/**
+ * Folder and tag the listed vaults are narrowed down to, empty values
+ * match every vault
+ **/
+type VaultFilter struct {
+    /* Matches the folder and its subfolders */
+    Folder string
+
+    Tag string
+}
+
+/**
+ * @brief:  Normalize a folder path. Backslashes (LastPass groups) are read as
+ *          separators, empty and '-' segments dropped.
+ *
+ * @param:  folder - Folder path
+ *
+ * @return: Folder path like "Work/Servers", empty for none
+ **/
+func NormalizeFolder(folder string) string {
+    folder = strings.Replace(folder, "\\", folderSeparator, -1)
+
+    var segments []string
+    for _, segment := range strings.Split(folder, folderSeparator) {
+        segment = strings.TrimSpace(segment)
+        if segment != "" && segment != clearValue {
+            segments = append(segments, segment)
+        }
+    }
+
+    return strings.Join(segments, folderSeparator)
+}
+
+/**
+ * @brief:  Parse a comma or semicolon separated list of tags. Tags are
+ *          trimmed, kept once regardless of case and sorted.
+ *
+ * @param:  value - List of tags
+ *
+ * @return: Tags, nil if none
+ **/
+func ParseTags(value string) []string {
+    fields := strings.FieldsFunc(value, func(r rune) bool {
+        return r == ',' || r == ';'
+    })
+
+    return normalizeTagList(fields)
+}
+
+/**
+ * @brief:  Trim, deduplicate and sort tags
+ *
+ * @param:  tags - Tags as entered
+ *
+ * @return: Tags, nil if none
+ **/
+func normalizeTagList(tags []string) []string {
+    var normalized []string
+    seen := map[string]bool{}
+    for _, tag := range tags {
+        tag = strings.TrimSpace(tag)
+        if tag == "" || tag == clearValue || seen[strings.ToLower(tag)] {
+            continue
+        }
+        seen[strings.ToLower(tag)] = true
+        normalized = append(normalized, tag)
+    }
+
+    sort.Slice(normalized, func(i, j int) bool {
+        return strings.ToLower(normalized[i]) < strings.ToLower(normalized[j])
+    })
+
+    return normalized
+}
+
+/**
+ * @brief:  Normalize the folder of a vault
+ *
+ * @param:  vault - Contains folder
+ *
+ * @return: nil
+ **/
+func normalizeFolder(vault *Vault, user User) error {
+    vault.Folder = NormalizeFolder(vault.Folder)
+
+    return nil
+}
+
+/**
+ * @brief:  Encrypt the folder with the key of the entry
+ *
+ * @param:  vault - Contains folder
+ * @param:  user - User to cipher folder
+ *
+ * @return: nil on success, else error
+ **/
+func encryptFolder(vault *Vault, user User) error {
+    if vault.Folder == "" {
+        vault.FolderCipher = nil
+        return nil
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    folderCipher, err := aes.Encrypt(vault.Folder)
+    if err != nil {
+        return err
+    }
+    vault.FolderCipher = folderCipher
+
+    return nil
+}
+
+/**
+ * @brief:  Decrypt the folder of a vault, a folder without cipher is kept
+ *          (see EncryptRestoredEntries)
+ *
+ * @param:  vault - Vault to decrypt
+ * @param:  user - User to decipher folder
+ *
+ * @return: nil on success, else error
+ **/
+func decryptFolder(vault *Vault, user User) error {
+    if len(vault.FolderCipher) == 0 {
+        return nil
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    folder, err := aes.Decrypt(vault.FolderCipher)
+    if err != nil {
+        return err
+    }
+    vault.Folder = folder
+
+    return nil
+}
+
+/**
+ * @brief:  Encrypt the folders left in plaintext from before they were
+ *          encrypted
+ *
+ * @param:  db - pointer to dabase
+ * @param:  vaults - Vaults as stored, their folder ciphers are updated
+ * @param:  user - User to cipher folders, empty for entries with an item key
+ *
+ * @return: nil on success, else error
+ **/
+func encryptPlaintextFolders(db *gorm.DB, vaults []Vault, user User) error {
+    table := db.NewScope(&Vault{}).TableName()
+    if len(vaults) == 0 || !db.Dialect().HasColumn(table, plaintextFolderColumn) {
+        return nil
+    }
+
+    ids := make([]uint, len(vaults))
+    index := map[uint]int{}
+    for i, vault := range vaults {
+        ids[i] = vault.ID
+        index[vault.ID] = i
+    }
+
+    rows, err := db.Raw("SELECT id, folder FROM " + table +
+        " WHERE id IN (?) AND folder IS NOT NULL AND folder_cipher IS NULL", ids).Rows()
+    if err != nil {
+        return err
+    }
+
+    var plaintext []Vault
+    for rows.Next() {
+        var id uint
+        var folder string
+        if err := rows.Scan(&id, &folder); err != nil {
+            rows.Close()
+            return err
+        }
+
+        vault := vaults[index[id]]
+        vault.Folder = folder
+        plaintext = append(plaintext, vault)
+    }
+    rows.Close()
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        for _, vault := range plaintext {
+            if err := runVaultValFns(&vault, user, normalizeFolder, encryptFolder); err != nil {
+                return err
+            }
+
+            err := tx.Exec("UPDATE " + table + " SET folder = NULL, folder_cipher = ? WHERE id = ?",
+                vault.FolderCipher, vault.ID).Error
+            if err != nil {
+                return err
+            }
+            vaults[index[vault.ID]].FolderCipher = vault.FolderCipher
+        }
+
+        return nil
+    })
+}
+
+/**
+ * @brief:  Normalize the tags of a vault
+ *
+ * @param:  vault - Contains tags
+ *
+ * @return: nil
+ **/
+func normalizeTags(vault *Vault, user User) error {
+    vault.Tags = normalizeTagList(vault.Tags)
+
+    return nil
+}
+
+/**
+ * @brief:  Encrypt the tags with the key of the entry
+ *
+ * @param:  vault - Contains tags
+ * @param:  user - User to cipher tags
+ *
+ * @return: nil on success, else error
+ **/
+func encryptTags(vault *Vault, user User) error {
+    if len(vault.Tags) == 0 {
+        vault.TagsCipher = nil
+        return nil
+    }
+
+    tags, err := json.Marshal(vault.Tags)
+    if err != nil {
+        return err
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    tagsCipher, err := aes.Encrypt(string(tags))
+    if err != nil {
+        return err
+    }
+    vault.TagsCipher = tagsCipher
+
+    return nil
+}
+
+/**
+ * @brief:  Decrypt the tags of a vault
+ *
+ * @param:  vault - Vault to decrypt
+ * @param:  user - User to decipher tags
+ *
+ * @return: nil on success, else error
+ **/
+func decryptTags(vault *Vault, user User) error {
+    vault.Tags = nil
+    if len(vault.TagsCipher) == 0 {
+        return nil
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    tags, err := aes.Decrypt(vault.TagsCipher)
+    if err != nil {
+        return err
+    }
+
+    return json.Unmarshal([]byte(tags), &vault.Tags)
+}
+
+/**
+ * @brief:  Check if a vault is in a folder or one of its subfolders
+ *
+ * @param:  folder - Normalized folder path
+ *
+ * @return: true if it is, else false
+ **/
+func (vault Vault) InFolder(folder string) bool {
+    if folder == "" {
+        return true
+    }
+
+    current := strings.ToLower(vault.Folder)
+    folder = strings.ToLower(folder)
+
+    return current == folder || strings.HasPrefix(current, folder + folderSeparator)
+}
+
+/**
+ * @brief:  Check if a vault has a tag, case insensitive
+ *
+ * @param:  tag - Tag to look for
+ *
+ * @return: true if it has, else false
+ **/
+func (vault Vault) HasTag(tag string) bool {
+    for _, current := range vault.Tags {
+        if strings.EqualFold(current, tag) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * @brief:  Check if the filter matches every vault
+ *
+ * @return: true if nothing is filtered, else false
+ **/
+func (filter VaultFilter) Empty() bool {
+    return filter.Folder == "" && filter.Tag == ""
+}
+
+func (filter VaultFilter) String() string {
+    var parts []string
+    if filter.Folder != "" {
+        parts = append(parts, "folder " + filter.Folder)
+    }
+    if filter.Tag != "" {
+        parts = append(parts, "tag " + filter.Tag)
+    }
+    if len(parts) == 0 {
+        return "everything"
+    }
+
+    return strings.Join(parts, ", ")
+}
+
+/**
+ * @brief:  Keep the vaults matching a filter
+ *
+ * @param:  vaults - Vaults with decrypted metadata
+ * @param:  filter - Folder and tag to match
+ *
+ * @return: Matching vaults, in the same order
+ **/
+func FilterVaults(vaults []Vault, filter VaultFilter) []Vault {
+    if filter.Empty() {
+        return vaults
+    }
+
+    folder := NormalizeFolder(filter.Folder)
+    tag := strings.TrimSpace(filter.Tag)
+
+    var matched []Vault
+    for _, vault := range vaults {
+        if !vault.InFolder(folder) {
+            continue
+        }
+        if tag != "" && !vault.HasTag(tag) {
+            continue
+        }
+        matched = append(matched, vault)
+    }
+
+    return matched
+}
+
+/**
+ * @brief:  Sort vaults by folder, then name. The names are encrypted, so the
+ *          database can't do it.
+ *
+ * @param:  vaults - Vaults with decrypted metadata
+ **/
+func SortVaults(vaults []Vault) {
+    sort.SliceStable(vaults, func(i, j int) bool {
+        a, b := vaults[i], vaults[j]
+        if !strings.EqualFold(a.Folder, b.Folder) {
+            return strings.ToLower(a.Folder) < strings.ToLower(b.Folder)
+        }
+        if !strings.EqualFold(a.Application, b.Application) {
+            return strings.ToLower(a.Application) < strings.ToLower(b.Application)
+        }
+
+        return a.ID < b.ID
+    })
+}
+
+/**
+ * @brief:  Display the folder tree with the number of items in each folder
+ *          and its subfolders, then every tag in use
+ *
+ * @param:  vaults - Vaults with decrypted metadata
+ **/
+func DisplayFolders(vaults []Vault) {
+    counts := map[string]int{}
+    tags := map[string]int{}
+    names := map[string]string{}
+    for _, vault := range vaults {
+        segments := strings.Split(vault.Folder, folderSeparator)
+        for i := range segments {
+            if vault.Folder == "" {
+                break
+            }
+            counts[strings.Join(segments[:i + 1], folderSeparator)]++
+        }
+
+        for _, tag := range vault.Tags {
+            if _, ok := names[strings.ToLower(tag)]; !ok {
+                names[strings.ToLower(tag)] = tag
+            }
+            tags[strings.ToLower(tag)]++
+        }
+    }
+
+    folders := make([]string, 0, len(counts))
+    for folder := range counts {
+        folders = append(folders, folder)
+    }
+    /* Children right after their parent, "Work/Servers" before "Work Stuff" */
+    sort.Slice(folders, func(i, j int) bool {
+        return strings.Replace(folders[i], folderSeparator, "\x00", -1) <
+            strings.Replace(folders[j], folderSeparator, "\x00", -1)
+    })
+
+    fmt.Println("Folders:")
+    if len(folders) == 0 {
+        fmt.Println("\t(none)")
+    }
+    for _, folder := range folders {
+        depth := strings.Count(folder, folderSeparator)
+        name := folder[strings.LastIndex(folder, folderSeparator) + 1:]
+        fmt.Printf("\t%s%s (%d)\n", strings.Repeat("    ", depth), name, counts[folder])
+    }
+
+    keys := make([]string, 0, len(tags))
+    for tag := range tags {
+        keys = append(keys, tag)
+    }
+    sort.Strings(keys)
+
+    fmt.Println("Tags:")
+    if len(keys) == 0 {
+        fmt.Println("\t(none)")
+    }
+    for _, tag := range keys {
+        fmt.Printf("\t%s (%d)\n", names[tag], tags[tag])
+    }
+    fmt.Println()
+}
+
+/**
+ * @brief:  Ask for the folder and tags of an item
+ *
+ * @param:  reader - Reads the user input
+ * @param:  vault - Item to set the folder and tags of
+ **/
+func askFolderAndTags(reader *bufio.Reader, vault *Vault) {
+    fmt.Print("Enter folder, e.g. Work/Servers (optional, '-' for none): ")
+    folder, _ := reader.ReadString('\n')
+    vault.Folder = strings.TrimSpace(folder)
+
+    fmt.Print("Enter tags, comma separated (optional, '-' for none): ")
+    tags, _ := reader.ReadString('\n')
+    if strings.TrimSpace(tags) == clearValue {
+        vault.Tags = []string{clearValue}
+        return
+    }
+    vault.Tags = ParseTags(tags)
+}
@@ -0,0 +1,302 @@
+package models
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+)
+
+/**
+ * Types of items kept in the vault
+ **/
+const (
+    TypeLogin    = "login"
+    TypeNote     = "note"
+    TypeCard     = "card"
+    TypeIdentity = "identity"
+    TypeAPIKey   = "apikey"
+)
+
+/**
+ * Field of a typed item, stored in the encrypted payload
+ **/
+type ItemField struct {
+    /* Key in the payload */
+    Name string
+
+    /* Shown to the user */
+    Label string
+
+    Required bool
+
+    /* Masked when displayed and asked for without echo */
+    Hidden bool
+}
+
+/**
+ * Schema of a type of item
+ **/
+type ItemSchema struct {
+    /* Shown to the user */
+    Label string
+
+    /* Payload field copied to the clipboard, empty to copy the password */
+    Secret string
+
+    Fields []ItemField
+}
+
+/**
+ * Schema of each item type, in the order they are offered to the user. A
+ * login keeps its data in the Email, Username and Password columns and a
+ * secure note in Notes, every type has its name in Application.
+ **/
+var itemTypes = []string{TypeLogin, TypeNote, TypeCard, TypeIdentity, TypeAPIKey}
+
+var itemSchemas = map[string]ItemSchema{
+    TypeLogin: {
+        Label: "Login",
+    },
+    TypeNote: {
+        Label: "Secure note",
+    },
+    TypeCard: {
+        Label: "Credit card",
+        Secret: "number",
+        Fields: []ItemField{
+            {Name: "cardholder", Label: "Cardholder name", Required: true},
+            {Name: "number", Label: "Card number", Required: true, Hidden: true},
+            {Name: "expiry", Label: "Expiry (MM/YY)", Required: true},
+            {Name: "cvv", Label: "Security code", Hidden: true},
+            {Name: "pin", Label: "PIN", Hidden: true},
+        },
+    },
+    TypeIdentity: {
+        Label: "Identity",
+        Fields: []ItemField{
+            {Name: "name", Label: "Full name", Required: true},
+            {Name: "address", Label: "Address"},
+            {Name: "phone", Label: "Phone"},
+            {Name: "email", Label: "Email"},
+            {Name: "birthdate", Label: "Date of birth"},
+            {Name: "document", Label: "ID or passport number", Hidden: true},
+        },
+    },
+    TypeAPIKey: {
+        Label: "API key",
+        Secret: "key",
+        Fields: []ItemField{
+            {Name: "key", Label: "Key or token", Required: true, Hidden: true},
+            {Name: "secret", Label: "Secret", Hidden: true},
+            {Name: "endpoint", Label: "Endpoint URL"},
+        },
+    },
+}
+
+/**
+ * @brief:  Get the item types, in the order they are offered to the user
+ *
+ * @return: Item types
+ **/
+func ItemTypes() []string {
+    return append([]string{}, itemTypes...)
+}
+
+/**
+ * @brief:  Get the schema of an item type
+ *
+ * @param:  itemType - Type of the item
+ *
+ * @return: Schema, and false if the type isn't known
+ **/
+func SchemaOf(itemType string) (ItemSchema, bool) {
+    schema, ok := itemSchemas[itemType]
+    return schema, ok
+}
+
+/**
+ * @brief:  Get the value copied to the clipboard for an item
+ *
+ * @return: Password of a login, the secret field of other items
+ **/
+func (vault Vault) Secret() string {
+    schema := itemSchemas[vault.ItemType()]
+    if schema.Secret == "" {
+        return vault.Password
+    }
+
+    return vault.Payload[schema.Secret]
+}
+
+/**
+ * @brief:  Get the type of an item, entries from before item types are logins
+ *
+ * @return: Item type
+ **/
+func (vault Vault) ItemType() string {
+    if vault.Type == "" {
+        return TypeLogin
+    }
+
+    return vault.Type
+}
+
+/**
+ * @brief:  Ask the user which type of item to add
+ *
+ * @return: Item type
+ **/
+func askItemType() string {
+    var input int
+    for input < 1 || input > len(itemTypes) {
+        for i, itemType := range itemTypes {
+            fmt.Printf("%d.) %s\n", i + 1, itemSchemas[itemType].Label)
+        }
+        fmt.Printf("Choose type (1 - %d): ", len(itemTypes))
+        fmt.Scanln(&input)
+    }
+
+    return itemTypes[input - 1]
+}
+
+/**
+ * @brief:  Ask for the fields of a typed item
+ *
+ * @param:  reader - Reads the visible fields
+ * @param:  itemType - Type of the item
+ *
+ * @return: Payload of the item
+ **/
+func askPayload(reader *bufio.Reader, itemType string) map[string]string {
+    payload := map[string]string{}
+    for _, field := range itemSchemas[itemType].Fields {
+        optional := ""
+        if !field.Required {
+            optional = " (optional)"
+        }
+
+        value := ""
+        if field.Hidden {
+            value = HiddenInput(strings.ToLower(field.Label) + optional)
+        } else {
+            fmt.Printf("Enter %s%s: ", strings.ToLower(field.Label), optional)
+            value, _ = reader.ReadString('\n')
+            value = strings.TrimSpace(value)
+        }
+
+        if value != "" {
+            payload[field.Name] = value
+        }
+    }
+
+    return payload
+}
+
+/**
+ * @brief:  Ask for multiple lines of text, ends on an empty line
+ *
+ * @param:  reader - Reads the lines
+ * @param:  prompt - Message to ask for the text
+ *
+ * @return: Text without the trailing empty line
+ **/
+func askLines(reader *bufio.Reader, prompt string) string {
+    fmt.Printf("%s (end with an empty line):\n", prompt)
+
+    var lines []string
+    for {
+        line, err := reader.ReadString('\n')
+        line = strings.TrimRight(line, "\r\n")
+        if line == "" || err != nil {
+            break
+        }
+        lines = append(lines, line)
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+/**
+ * @brief:  Display a decrypted item, hidden fields are masked
+ *
+ * @param:  vault - Decrypted item
+ **/
+func DisplayEntry(vault Vault) {
+    itemType := vault.ItemType()
+    schema := itemSchemas[itemType]
+
+    fmt.Printf("%s: %s\n", schema.Label, vault.Application)
+    if vault.Folder != "" {
+        fmt.Printf("\tFolder: %s\n", vault.Folder)
+    }
+    if len(vault.Tags) > 0 {
+        fmt.Printf("\tTags: %s\n", strings.Join(vault.Tags, ", "))
+    }
+
+    if itemType == TypeLogin {
+        fmt.Printf("\tEmail: %s\n\tUsername: %s\n\tPassword: ********\n", vault.Email, vault.Username)
+    }
+    for _, uri := range vault.URIs {
+        fmt.Printf("\tURL: %s (%s)\n", uri.URI, uri.Match)
+    }
+    if vault.TOTP != "" {
+        fmt.Println("\tTOTP: ********")
+    }
+
+    for _, field := range schema.Fields {
+        value, ok := vault.Payload[field.Name]
+        if !ok {
+            continue
+        }
+        if field.Hidden {
+            value = "********"
+        }
+        fmt.Printf("\t%s: %s\n", field.Label, value)
+    }
+
+    for _, field := range vault.Fields {
+        value := field.Value
+        if field.Hidden {
+            value = "********"
+        }
+        fmt.Printf("\t%s: %s\n", field.Name, value)
+    }
+
+    if vault.Notes != "" {
+        fmt.Printf("\tNotes:\n\t\t%s\n", strings.Replace(vault.Notes, "\n", "\n\t\t", -1))
+    }
+    fmt.Println()
+}
+
+/**
+ * @brief:  Ask for the info of an item of the given type
+ *
+ * @param:  id - User's ID to map item to user
+ * @param:  itemType - Type of the item
+ *
+ * @return: Item with textbase values
+ **/
+func entryInfoOfType(id uint, itemType string) Vault {
+    reader := bufio.NewReader(os.Stdin)
+    if itemType == TypeLogin {
+        return loginInfo(reader, id)
+    }
+
+    fmt.Print("Enter name: ")
+    name, _ := reader.ReadString('\n')
+
+    vault := Vault{
+        UserID: id,
+        Type: itemType,
+        Application: strings.TrimSpace(name),
+        Payload: askPayload(reader, itemType),
+    }
+
+    if itemType == TypeNote {
+        vault.Notes = askLines(reader, "Enter note")
+    }
+    askFolderAndTags(reader, &vault)
+
+    return vault
+}
@@ -0,0 +1,398 @@
+package models
+
+import (
+    "strings"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * Columns that held the metadata in plaintext before it was encrypted
+ **/
+var plaintextColumns = []string{"email", "username", "application"}
+
+/**
+ * @brief:  Encrypt the email, username and application with the users
+ *          vault key and store their blind indexes for exact-match lookup.
+ *          Runs after normalization, the textbase values are kept in memory
+ *          but never stored.
+ *
+ * @param:  vault - Contains email, username and application
+ * @param:  user - User to cipher metadata
+ *
+ * @return: nil on success, else error
+ **/
+func encryptMetadata(vault *Vault, user User) error {
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+
+    columns := []struct {
+        value  string
+        cipher *[]byte
+        index  *string
+    }{
+        {vault.Email, &vault.EmailCipher, &vault.EmailIndex},
+        {vault.Username, &vault.UsernameCipher, &vault.UsernameIndex},
+        {vault.Application, &vault.ApplicationCipher, &vault.ApplicationIndex},
+    }
+
+    for _, column := range columns {
+        if column.value == "" {
+            *column.cipher = nil
+            *column.index = ""
+            continue
+        }
+
+        valueCipher, err := aes.Encrypt(column.value)
+        if err != nil {
+            return err
+        }
+        *column.cipher = valueCipher
+        *column.index = aes.BlindIndex(column.value)
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Decrypt the email, username, application, folder, tags and URIs
+ *          of a vault
+ *
+ * @param:  vault - Vault to decrypt
+ * @param:  user - User to decipher metadata
+ *
+ * @return: nil on success, else error
+ **/
+func decryptMetadata(vault *Vault, user User) error {
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+
+    columns := []struct {
+        cipher []byte
+        value  *string
+    }{
+        {vault.EmailCipher, &vault.Email},
+        {vault.UsernameCipher, &vault.Username},
+        {vault.ApplicationCipher, &vault.Application},
+    }
+
+    for _, column := range columns {
+        if len(column.cipher) == 0 {
+            continue
+        }
+
+        value, err := aes.Decrypt(column.cipher)
+        if err != nil {
+            return err
+        }
+        *column.value = value
+    }
+
+    if err := decryptFolder(vault, user); err != nil {
+        return err
+    }
+    if err := decryptTags(vault, user); err != nil {
+        return err
+    }
+
+    return decryptURIs(vault, user)
+}
+
+/**
+ * @brief:  Decrypt only the metadata (email, username, application, folder, tags and URIs) of vaults, enough
+ *          to list them without deciphering every password
+ *
+ * @param:  vaults - Vaults to decrypt
+ * @param:  user - User to decipher metadata
+ *
+ * @return: nil on success, else error
+ **/
+func DecryptMetadata(vaults []Vault, user User) error {
+    for i := range vaults {
+        if err := decryptMetadata(&vaults[i], user); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Find the user's vaults by exact match of the application or email,
+ *          using the blind indexes of the vault key and of each item key
+ *
+ * @param:  vaultdb - pointer to database
+ * @param:  user - User to search the vault of
+ * @param:  term - Application or email to look for
+ *
+ * @return: Vaults with decrypted metadata on success,
+ *          ErrNotFound if none match, else error
+ **/
+func FindExact(vaultdb *gorm.DB, user User, term string) ([]Vault, error) {
+    /* Same normalization as normalizeApplication and normalizeEmail */
+    term = normalizeTerm(term)
+    indexes := []string{compat.NewAES(user.VaultKey).BlindIndex(term)}
+
+    /* Shared entries are indexed with their item key */
+    var shared []Vault
+    err := vaultdb.Select("id, item_key_cipher").Where("user_id = ? AND item_key_cipher IS NOT NULL", user.ID).Find(&shared).Error
+    if err != nil {
+        return nil, err
+    }
+    for _, vault := range shared {
+        aes, err := entryAES(vault, user)
+        if err != nil {
+            return nil, err
+        }
+        indexes = append(indexes, aes.BlindIndex(term))
+    }
+
+    var vaults []Vault
+    db := vaultdb.Where("user_id = ? AND (application_index IN (?) OR email_index IN (?))", user.ID, indexes, indexes)
+    if err := find(db, &vaults); err != nil {
+        return nil, err
+    }
+    if len(vaults) == 0 {
+        return nil, ErrNotFound
+    }
+
+    if err := DecryptMetadata(vaults, user); err != nil {
+        return nil, err
+    }
+    SortVaults(vaults)
+
+    return vaults, nil
+}
+
+/**
+ * @brief:  Normalize a search term like applications and emails are
+ *
+ * @param:  term - Search term
+ *
+ * @return: Lower cased term without surrounding white spaces
+ **/
+func normalizeTerm(term string) string {
+    return strings.ToLower(strings.TrimSpace(term))
+}
+
+/**
+ * @brief:  Let the plaintext metadata and field columns of old databases be
+ *          null, new entries only store the ciphered columns. Deleted rows
+ *          are never loaded again, their plaintext folders and fields are
+ *          dropped right away. Once every folder and field is encrypted
+ *          their plaintext columns are dropped.
+ *
+ * @param:  db - pointer to dabase
+ *
+ * @return: nil on success, else error
+ **/
+func MigrateVaults(db *gorm.DB) error {
+    tables := map[string][]string{
+        db.NewScope(&Vault{}).TableName(): plaintextColumns,
+        db.NewScope(&Field{}).TableName(): plaintextFieldColumns,
+    }
+    for table, columns := range tables {
+        for _, column := range columns {
+            if !db.Dialect().HasColumn(table, column) {
+                continue
+            }
+
+            sql := "ALTER TABLE " + table + " ALTER COLUMN " + column + " DROP NOT NULL"
+            if err := db.Exec(sql).Error; err != nil {
+                return err
+            }
+        }
+    }
+
+    if err := dropPlaintextColumns(db, &Vault{}, []string{plaintextFolderColumn}); err != nil {
+        return err
+    }
+
+    return dropPlaintextColumns(db, &Field{}, plaintextFieldColumns)
+}
+
+/**
+ * @brief:  Clear the plaintext columns of deleted rows, and drop the columns
+ *          once no row holds plaintext anymore
+ *
+ * @param:  db - pointer to dabase
+ * @param:  model - Vault or Field
+ * @param:  columns - Plaintext columns that have a ciphered one
+ *
+ * @return: nil on success, else error
+ **/
+func dropPlaintextColumns(db *gorm.DB, model interface{}, columns []string) error {
+    table := db.NewScope(model).TableName()
+    if !db.Dialect().HasColumn(table, columns[0]) {
+        return nil
+    }
+
+    cleared := make([]string, len(columns))
+    for i, column := range columns {
+        cleared[i] = column + " = NULL"
+    }
+    sql := "UPDATE " + table + " SET " + strings.Join(cleared, ", ") + " WHERE deleted_at IS NOT NULL"
+    if err := db.Exec(sql).Error; err != nil {
+        return err
+    }
+
+    left := 0
+    err := db.Table(table).Where(columns[0] + " IS NOT NULL").Count(&left).Error
+    if err != nil || left > 0 {
+        return err
+    }
+
+    for _, column := range columns {
+        if err := db.Exec("ALTER TABLE " + table + " DROP COLUMN " + column).Error; err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Encrypt the plaintext metadata, folders and custom fields left in
+ *          the user's vaults from before they were encrypted. Needs the
+ *          user's key, so runs on login.
+ *
+ * @param:  db - pointer to dabase
+ * @param:  user - User to cipher metadata
+ *
+ * @return: nil on success, else error
+ **/
+func EncryptPlaintextMetadata(db *gorm.DB, user User) error {
+    if err := encryptPlaintextColumns(db, user); err != nil {
+        return err
+    }
+
+    var vaults []Vault
+    if err := db.Select("id, item_key_cipher").Where("user_id = ?", user.ID).Find(&vaults).Error; err != nil {
+        return err
+    }
+
+    if err := encryptPlaintextFolders(db, vaults, user); err != nil {
+        return err
+    }
+
+    return encryptPlaintextFields(db, vaults, user)
+}
+
+/**
+ * @brief:  Encrypt the email, username and application left in plaintext
+ *          columns of the user's vaults
+ *
+ * @param:  db - pointer to dabase
+ * @param:  user - User to cipher metadata
+ *
+ * @return: nil on success, else error
+ **/
+func encryptPlaintextColumns(db *gorm.DB, user User) error {
+    table := db.NewScope(&Vault{}).TableName()
+    for _, column := range plaintextColumns {
+        if !db.Dialect().HasColumn(table, column) {
+            return nil
+        }
+    }
+
+    rows, err := db.Raw("SELECT id, COALESCE(email, ''), COALESCE(username, ''), COALESCE(application, '') FROM " +
+        table + " WHERE user_id = ? AND application_cipher IS NULL", user.ID).Rows()
+    if err != nil {
+        return err
+    }
+
+    var vaults []Vault
+    for rows.Next() {
+        var vault Vault
+        if err := rows.Scan(&vault.ID, &vault.Email, &vault.Username, &vault.Application); err != nil {
+            rows.Close()
+            return err
+        }
+        vaults = append(vaults, vault)
+    }
+    rows.Close()
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        for _, vault := range vaults {
+            if err := encryptMetadata(&vault, user); err != nil {
+                return err
+            }
+
+            err := tx.Exec("UPDATE " + table + " SET email = NULL, username = NULL, application = NULL, " +
+                "email_cipher = ?, email_index = ?, username_cipher = ?, username_index = ?, " +
+                "application_cipher = ?, application_index = ? WHERE id = ?",
+                vault.EmailCipher, vault.EmailIndex, vault.UsernameCipher, vault.UsernameIndex,
+                vault.ApplicationCipher, vault.ApplicationIndex, vault.ID).Error
+            if err != nil {
+                return err
+            }
+        }
+
+        return nil
+    })
+}
+
+/**
+ * @brief:  Check if entries restored from a backup hold folders or custom
+ *          fields in plaintext, backups made before they were encrypted do
+ *
+ * @param:  vaults - Restored entries
+ *
+ * @return: true if any entry does, else false
+ **/
+func PlaintextEntries(vaults []Vault) bool {
+    for _, vault := range vaults {
+        if plaintextFields(vault) || (vault.Folder != "" && len(vault.FolderCipher) == 0) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * @brief:  Encrypt the folders and custom fields of entries restored from a
+ *          backup made before they were encrypted. Their vault key is
+ *          unsealed with the secret key, accounts from before vault keys
+ *          still use legacyVaultKey.
+ *
+ * @param:  vaults - Restored entries
+ * @param:  user - Restored account
+ * @param:  secretKey - Secret key of the account
+ *
+ * @return: nil on success, else error
+ **/
+func EncryptRestoredEntries(vaults []Vault, user User, secretKey string) error {
+    user.VaultKey = legacyVaultKey
+    if len(user.VaultKeyCipher) > 0 {
+        key, err := compat.OpenArchive(secretKey, user.VaultKeyCipher)
+        if err != nil {
+            return err
+        }
+        user.VaultKey = string(key)
+    }
+
+    for i := range vaults {
+        vault := &vaults[i]
+        if vault.Folder != "" && len(vault.FolderCipher) == 0 {
+            if err := runVaultValFns(vault, user, normalizeFolder, encryptFolder); err != nil {
+                return err
+            }
+        }
+
+        if !plaintextFields(*vault) {
+            continue
+        }
+        if err := runVaultValFns(vault, user, decryptFields, encryptFields); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
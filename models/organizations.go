@@ -0,0 +1,924 @@
+package models
+
+import (
+    "encoding/hex"
+    "fmt"
+    "strings"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+const (
+    /* Manages the organization, its members and their roles */
+    RoleOwner = "owner"
+
+    /* Manages collections and the members below admin */
+    RoleAdmin = "admin"
+
+    /* Reads, adds and edits entries of the collections */
+    RoleMember = "member"
+
+    /* Reads entries of the collections */
+    RoleReadOnly = "read-only"
+
+    /* Random bytes of an organization key, hex encoded in Organization.Key */
+    orgKeyBytes = 32
+)
+
+/**
+ * Roles from least to most allowed
+ **/
+var roleRanks = map[string]int{
+    RoleReadOnly: 1,
+    RoleMember: 2,
+    RoleAdmin: 3,
+    RoleOwner: 4,
+}
+
+/**
+ * @brief:  Check a role of a member
+ *
+ * @param:  role - RoleOwner, RoleAdmin, RoleMember or RoleReadOnly
+ *
+ * @return: nil on success, else ErrRoleInvalid
+ **/
+func ValidRole(role string) error {
+    if roleRanks[role] == 0 {
+        return ErrRoleInvalid
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Check that a role allows at least as much as another
+ *
+ * @param:  role - Role of the member
+ * @param:  least - Least role needed
+ *
+ * @return: nil on success, else ErrRoleForbidden
+ **/
+func requireRole(role string, least string) error {
+    if roleRanks[role] < roleRanks[least] {
+        return ErrRoleForbidden
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Lock an organization for the rest of a transaction and check the
+ *          member acting on it, who may have been removed or demoted since
+ *          it was opened
+ *
+ * @param:  tx - Transaction
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  user - Member acting on it
+ * @param:  least - Least role needed
+ *
+ * @return: Membership of the user on success
+ *          If the user is no longer a member, ErrNotFound
+ *          If the role doesn't allow it, ErrRoleForbidden
+ *          If the organization key was replaced, ErrOrgRekeyed
+ *          Else, error
+ **/
+func lockOrganization(tx *gorm.DB, org Organization, user User, least string) (Membership, error) {
+    var stored Organization
+    if err := first(tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", org.ID), &stored); err != nil {
+        return Membership{}, err
+    }
+
+    var member Membership
+    if err := first(tx.Where("organization_id = ? AND user_id = ?", org.ID, user.ID), &member); err != nil {
+        return Membership{}, err
+    }
+    if err := requireRole(member.Role, least); err != nil {
+        return Membership{}, err
+    }
+    if stored.KeyVersion != org.KeyVersion {
+        return Membership{}, ErrOrgRekeyed
+    }
+
+    return member, nil
+}
+
+/**
+ * @brief:  Record an event of an organization in the audit log of a user
+ *
+ * @param:  tx - Transaction
+ * @param:  user - User the event is logged for
+ * @param:  org - Organization
+ * @param:  source - Address the request came from, LocalSource on the command line
+ * @param:  detail - What happened
+ *
+ * @return: nil on success, else error
+ **/
+func recordOrgAudit(tx *gorm.DB, user User, org Organization, source string, detail string) error {
+    return recordAudit(tx, AuditEvent{UserID: user.ID, Username: user.Username, Source: source,
+        Action: "organization", Detail: fmt.Sprintf("%s: %s", org.Name, detail)})
+}
+
+/**
+ * @brief:  Create an organization with its own key, sealed to the public key
+ *          of its first owner
+ *
+ * @param:  db - Pointer to database
+ * @param:  name - Name of the organization
+ * @param:  owner - User with its vault key unlocked
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: Organization opened for the owner on success
+ *          If the name is missing or taken, ErrOrgNameRequired or ErrOrgNameTaken
+ *          If the owner has no key pair, ErrShareNoKey
+ *          Else, error
+ **/
+func CreateOrganization(db *gorm.DB, name string, owner User, source string) (Organization, error) {
+    name = strings.TrimSpace(name)
+    if name == "" {
+        return Organization{}, ErrOrgNameRequired
+    } else if owner.Remote || len(owner.PublicKey) == 0 {
+        return Organization{}, ErrShareNoKey
+    }
+
+    err := first(db.Where("name = ?", name), &Organization{})
+    if err == nil {
+        return Organization{}, ErrOrgNameTaken
+    } else if err != ErrNotFound {
+        return Organization{}, err
+    }
+
+    key, err := compat.Bytes(orgKeyBytes)
+    if err != nil {
+        return Organization{}, err
+    }
+    org := Organization{Name: name, KeyVersion: 1, Key: hex.EncodeToString(key), Role: RoleOwner}
+    sealed, err := compat.SealTo(owner.PublicKey, []byte(org.Key))
+    if err != nil {
+        return Organization{}, err
+    }
+
+    err = db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&org).Error; err != nil {
+            return err
+        }
+
+        member := Membership{OrganizationID: org.ID, UserID: owner.ID, Role: RoleOwner, KeyCipher: sealed}
+        if err := tx.Create(&member).Error; err != nil {
+            return err
+        }
+
+        return recordOrgAudit(tx, owner, org, source, "organization created")
+    })
+    if err != nil {
+        return Organization{}, err
+    }
+
+    return org, nil
+}
+
+/**
+ * @brief:  Find the organizations a user is a member of, without their keys
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Member
+ *
+ * @return: Organizations with the role of the user on success, else error
+ **/
+func Organizations(db *gorm.DB, user User) ([]Organization, error) {
+    var members []Membership
+    if err := db.Where("user_id = ?", user.ID).Order("organization_id").Find(&members).Error; err != nil {
+        return nil, err
+    }
+
+    orgs := []Organization{}
+    for _, member := range members {
+        var org Organization
+        if err := first(db.Where("id = ?", member.OrganizationID), &org); err != nil {
+            return nil, err
+        }
+        org.Role = member.Role
+        orgs = append(orgs, org)
+    }
+
+    return orgs, nil
+}
+
+/**
+ * @brief:  Open an organization of a user with its key
+ *
+ * @param:  db - Pointer to database
+ * @param:  id - ID of the organization
+ * @param:  user - Member with its vault key unlocked
+ *
+ * @return: Organization with its key and the role of the user on success,
+ *          ErrNotFound if the user isn't a member, else error
+ **/
+func OpenOrganization(db *gorm.DB, id uint, user User) (Organization, error) {
+    /* Read before the membership, so a key replaced in between makes the
+     * version stale instead of the key */
+    var org Organization
+    if err := first(db.Where("id = ?", id), &org); err != nil {
+        return Organization{}, err
+    }
+
+    var member Membership
+    if err := first(db.Where("organization_id = ? AND user_id = ?", id, user.ID), &member); err != nil {
+        return Organization{}, err
+    }
+
+    key, err := openSealedKey(user, member.KeyCipher)
+    if err != nil {
+        return Organization{}, err
+    }
+    org.Key, org.Role = key, member.Role
+
+    return org, nil
+}
+
+/**
+ * @brief:  Find the members of an organization
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization
+ *
+ * @return: Members with their usernames on success, else error
+ **/
+func Members(db *gorm.DB, org Organization) ([]Membership, error) {
+    var members []Membership
+    if err := db.Where("organization_id = ?", org.ID).Order("id").Find(&members).Error; err != nil {
+        return nil, err
+    }
+
+    for i := range members {
+        var member User
+        if err := first(db.Where("id = ?", members[i].UserID), &member); err != nil {
+            return nil, err
+        }
+        members[i].Username = member.Username
+    }
+
+    return members, nil
+}
+
+/**
+ * @brief:  Add a user to an organization, sealing the organization key to
+ *          their public key. Admins add members and read-only members, only
+ *          owners add admins and owners.
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  actor - Admin or owner adding the user
+ * @param:  username - User to add
+ * @param:  role - Role of the new member
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the user doesn't exist, ErrNotFound
+ *          If the user has no key pair yet, ErrShareNoKey
+ *          If the user is a member already, ErrMemberExists
+ *          If the role of the actor doesn't allow it, ErrRoleForbidden
+ *          Else, error
+ **/
+func AddMember(db *gorm.DB, org Organization, actor User, username string, role string, source string) error {
+    if err := ValidRole(role); err != nil {
+        return err
+    }
+    least := RoleAdmin
+    if roleRanks[role] >= roleRanks[RoleAdmin] {
+        least = RoleOwner
+    }
+
+    grantee, err := ByUsername(db, username)
+    if err != nil {
+        return err
+    }
+    if grantee.Remote || len(grantee.PublicKey) == 0 {
+        return ErrShareNoKey
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        if _, err := lockOrganization(tx, org, actor, least); err != nil {
+            return err
+        }
+
+        err := first(tx.Where("organization_id = ? AND user_id = ?", org.ID, grantee.ID), &Membership{})
+        if err == nil {
+            return ErrMemberExists
+        } else if err != ErrNotFound {
+            return err
+        }
+
+        sealed, err := compat.SealTo(grantee.PublicKey, []byte(org.Key))
+        if err != nil {
+            return err
+        }
+        member := Membership{OrganizationID: org.ID, UserID: grantee.ID, Role: role, KeyCipher: sealed}
+        if err := tx.Create(&member).Error; err != nil {
+            return err
+        }
+
+        detail := fmt.Sprintf("%s added as %s", grantee.Username, role)
+        if err := recordOrgAudit(tx, actor, org, source, detail); err != nil {
+            return err
+        }
+
+        return recordOrgAudit(tx, *grantee, org, source, detail + " by " + actor.Username)
+    })
+}
+
+/**
+ * @brief:  Find a member of an organization by username, for an actor
+ *          changing or removing them. Admins only act on members and
+ *          read-only members, owners on anyone.
+ *
+ * @param:  tx - Transaction the organization is locked in
+ * @param:  org - Organization
+ * @param:  actor - Membership of the actor
+ * @param:  username - Member to act on
+ *
+ * @return: Membership with its username on success
+ *          If the user isn't a member, ErrNotFound
+ *          If the role of the actor doesn't allow it, ErrRoleForbidden
+ *          Else, error
+ **/
+func memberToManage(tx *gorm.DB, org Organization, actor Membership, username string) (Membership, error) {
+    user, err := ByUsername(tx, username)
+    if err != nil {
+        return Membership{}, err
+    }
+
+    var member Membership
+    if err := first(tx.Where("organization_id = ? AND user_id = ?", org.ID, user.ID), &member); err != nil {
+        return Membership{}, err
+    }
+    member.Username = user.Username
+
+    if roleRanks[member.Role] >= roleRanks[RoleAdmin] && actor.UserID != member.UserID {
+        if err := requireRole(actor.Role, RoleOwner); err != nil {
+            return Membership{}, err
+        }
+    }
+
+    return member, nil
+}
+
+/**
+ * @brief:  Check that an organization keeps an owner besides a member
+ *
+ * @param:  tx - Transaction the organization is locked in
+ * @param:  member - Member leaving or being demoted
+ *
+ * @return: nil on success, ErrLastOwner if the member is its only owner,
+ *          else error
+ **/
+func keepOwner(tx *gorm.DB, member Membership) error {
+    if member.Role != RoleOwner {
+        return nil
+    }
+
+    owners := 0
+    err := tx.Model(&Membership{}).Where("organization_id = ? AND role = ? AND id <> ?",
+        member.OrganizationID, RoleOwner, member.ID).Count(&owners).Error
+    if err != nil {
+        return err
+    } else if owners == 0 {
+        return ErrLastOwner
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Change the role of a member. Admins switch members and read-only
+ *          members, only owners grant or take admin and owner.
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  actor - Admin or owner changing the role
+ * @param:  username - Member whose role changes
+ * @param:  role - New role
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the user isn't a member, ErrNotFound
+ *          If the role of the actor doesn't allow it, ErrRoleForbidden
+ *          If the last owner would be demoted, ErrLastOwner
+ *          Else, error
+ **/
+func SetMemberRole(db *gorm.DB, org Organization, actor User, username string, role string, source string) error {
+    if err := ValidRole(role); err != nil {
+        return err
+    }
+    least := RoleAdmin
+    if roleRanks[role] >= roleRanks[RoleAdmin] {
+        least = RoleOwner
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        acting, err := lockOrganization(tx, org, actor, least)
+        if err != nil {
+            return err
+        }
+
+        member, err := memberToManage(tx, org, acting, username)
+        if err != nil {
+            return err
+        }
+        if role != RoleOwner {
+            if err := keepOwner(tx, member); err != nil {
+                return err
+            }
+        }
+
+        if err := tx.Model(&member).UpdateColumn("role", role).Error; err != nil {
+            return err
+        }
+
+        detail := fmt.Sprintf("%s is now %s", member.Username, role)
+        if err := recordOrgAudit(tx, actor, org, source, detail); err != nil {
+            return err
+        }
+        if member.UserID == actor.ID {
+            return nil
+        }
+
+        return recordOrgAudit(tx, User{Model: gorm.Model{ID: member.UserID}, Username: member.Username},
+            org, source, detail + " by " + actor.Username)
+    })
+}
+
+/**
+ * @brief:  Remove a member from an organization, or have a member leave it.
+ *          The organization gets a new key, so what the member may have kept
+ *          of the old one opens nothing anymore.
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  actor - Admin or owner removing the member, or the member leaving
+ * @param:  username - Member to remove
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the user isn't a member, ErrNotFound
+ *          If the role of the actor doesn't allow it, ErrRoleForbidden
+ *          If the last owner would leave, ErrLastOwner
+ *          Else, error
+ **/
+func RemoveMember(db *gorm.DB, org Organization, actor User, username string, source string) error {
+    least := RoleAdmin
+    if username == actor.Username {
+        least = RoleReadOnly
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        acting, err := lockOrganization(tx, org, actor, least)
+        if err != nil {
+            return err
+        }
+
+        member, err := memberToManage(tx, org, acting, username)
+        if err != nil {
+            return err
+        }
+        if err := keepOwner(tx, member); err != nil {
+            return err
+        }
+
+        if err := tx.Unscoped().Delete(&member).Error; err != nil {
+            return err
+        }
+        if err := rekeyOrganization(tx, org); err != nil {
+            return err
+        }
+
+        detail := member.Username + " removed"
+        if member.UserID == actor.ID {
+            return recordOrgAudit(tx, actor, org, source, member.Username + " left")
+        }
+        if err := recordOrgAudit(tx, actor, org, source, detail); err != nil {
+            return err
+        }
+
+        return recordOrgAudit(tx, User{Model: gorm.Model{ID: member.UserID}, Username: member.Username},
+            org, source, detail + " by " + actor.Username)
+    })
+}
+
+/**
+ * @brief:  Encrypt the entries of an organization with a new key and seal
+ *          it to its remaining members
+ *
+ * @param:  tx - Transaction the organization is locked in
+ * @param:  org - Organization with its current key
+ *
+ * @return: nil on success, else error
+ **/
+func rekeyOrganization(tx *gorm.DB, org Organization) error {
+    key, err := compat.Bytes(orgKeyBytes)
+    if err != nil {
+        return err
+    }
+    orgKey := hex.EncodeToString(key)
+
+    var vaults []Vault
+    collections := tx.Model(&Collection{}).Select("id").Where("organization_id = ?", org.ID).QueryExpr()
+    err = tx.Set("gorm:query_option", "FOR UPDATE").Where("collection_id IN (?)", collections).Find(&vaults).Error
+    if err != nil {
+        return err
+    }
+    if err := reencryptVaults(tx, vaults, compat.NewAES(org.Key), compat.NewAES(orgKey)); err != nil {
+        return err
+    }
+
+    var members []Membership
+    if err := tx.Where("organization_id = ?", org.ID).Find(&members).Error; err != nil {
+        return err
+    }
+    for _, member := range members {
+        var user User
+        if err := first(tx.Where("id = ?", member.UserID), &user); err != nil {
+            return err
+        }
+
+        sealed, err := compat.SealTo(user.PublicKey, []byte(orgKey))
+        if err != nil {
+            return err
+        }
+        if err := tx.Model(&member).UpdateColumn("key_cipher", sealed).Error; err != nil {
+            return err
+        }
+    }
+
+    return tx.Model(&org).UpdateColumn("key_version", gorm.Expr("key_version + 1")).Error
+}
+
+/**
+ * @brief:  Create a collection in an organization
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  actor - Admin or owner creating it
+ * @param:  name - Name of the collection
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: Collection on success
+ *          If the name is missing or taken, ErrOrgNameRequired or ErrCollectionTaken
+ *          If the role of the actor doesn't allow it, ErrRoleForbidden
+ *          Else, error
+ **/
+func CreateCollection(db *gorm.DB, org Organization, actor User, name string, source string) (Collection, error) {
+    collection := Collection{OrganizationID: org.ID, Name: strings.TrimSpace(name)}
+    if collection.Name == "" {
+        return Collection{}, ErrOrgNameRequired
+    }
+
+    err := db.Transaction(func(tx *gorm.DB) error {
+        if _, err := lockOrganization(tx, org, actor, RoleAdmin); err != nil {
+            return err
+        }
+
+        err := first(tx.Where("organization_id = ? AND name = ?", org.ID, collection.Name), &Collection{})
+        if err == nil {
+            return ErrCollectionTaken
+        } else if err != ErrNotFound {
+            return err
+        }
+
+        if err := tx.Create(&collection).Error; err != nil {
+            return err
+        }
+
+        return recordOrgAudit(tx, actor, org, source, "collection " + collection.Name + " created")
+    })
+    if err != nil {
+        return Collection{}, err
+    }
+
+    return collection, nil
+}
+
+/**
+ * @brief:  Find the collections of an organization
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization
+ *
+ * @return: Collections by name on success, else error
+ **/
+func Collections(db *gorm.DB, org Organization) ([]Collection, error) {
+    collections := []Collection{}
+    if err := db.Where("organization_id = ?", org.ID).Order("name").Find(&collections).Error; err != nil {
+        return nil, err
+    }
+
+    return collections, nil
+}
+
+/**
+ * @brief:  Find a collection of an organization
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization
+ * @param:  id - ID of the collection
+ *
+ * @return: Collection on success, ErrNotFound if the organization has none
+ *          with the ID, else error
+ **/
+func collectionByID(db *gorm.DB, org Organization, id uint) (Collection, error) {
+    var collection Collection
+    err := first(db.Where("id = ? AND organization_id = ?", id, org.ID), &collection)
+
+    return collection, err
+}
+
+/**
+ * @brief:  Delete an empty collection of an organization
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  actor - Admin or owner deleting it
+ * @param:  id - ID of the collection
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the organization has no such collection, ErrNotFound
+ *          If it still has entries, ErrCollectionNotEmpty
+ *          If the role of the actor doesn't allow it, ErrRoleForbidden
+ *          Else, error
+ **/
+func DeleteCollection(db *gorm.DB, org Organization, actor User, id uint, source string) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        if _, err := lockOrganization(tx, org, actor, RoleAdmin); err != nil {
+            return err
+        }
+
+        collection, err := collectionByID(tx, org, id)
+        if err != nil {
+            return err
+        }
+
+        entries := 0
+        if err := tx.Model(&Vault{}).Where("collection_id = ?", collection.ID).Count(&entries).Error; err != nil {
+            return err
+        } else if entries > 0 {
+            return ErrCollectionNotEmpty
+        }
+
+        if err := tx.Unscoped().Delete(&collection).Error; err != nil {
+            return err
+        }
+
+        return recordOrgAudit(tx, actor, org, source, "collection " + collection.Name + " deleted")
+    })
+}
+
+/**
+ * @brief:  Check that the key of an organization is still current
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ *
+ * @return: nil on success, ErrOrgRekeyed if it was replaced, else error
+ **/
+func currentOrgKey(db *gorm.DB, org Organization) error {
+    var stored Organization
+    if err := first(db.Where("id = ?", org.ID), &stored); err != nil {
+        return err
+    } else if stored.KeyVersion != org.KeyVersion {
+        return ErrOrgRekeyed
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Find the entries of a collection, with their metadata decrypted
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  id - ID of the collection
+ *
+ * @return: Entries on success
+ *          If the organization has no such collection, ErrNotFound
+ *          If the organization key changed, ErrOrgRekeyed
+ *          Else, error
+ **/
+func CollectionEntries(db *gorm.DB, org Organization, id uint) ([]Vault, error) {
+    if err := currentOrgKey(db, org); err != nil {
+        return nil, err
+    }
+
+    collection, err := collectionByID(db, org, id)
+    if err != nil {
+        return nil, err
+    }
+
+    vaults := []Vault{}
+    if err := db.Where("collection_id = ?", collection.ID).Order("id").Find(&vaults).Error; err != nil {
+        return nil, err
+    }
+    for i := range vaults {
+        vaults[i].ItemKey = org.Key
+    }
+
+    /* Members have no key of their own to do it at login */
+    if err := encryptPlaintextFolders(db, vaults, User{}); err != nil {
+        return nil, err
+    }
+    if err := encryptPlaintextFields(db, vaults, User{}); err != nil {
+        return nil, err
+    }
+
+    if err := DecryptMetadata(vaults, User{}); err != nil {
+        return nil, err
+    }
+    SortVaults(vaults)
+
+    return vaults, nil
+}
+
+/**
+ * @brief:  Find an entry of an organization and decrypt it
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  id - ID of the entry
+ *
+ * @return: Entry on success
+ *          If the organization has no entry with the ID, ErrNotFound
+ *          If the organization key changed, ErrOrgRekeyed
+ *          Else, error
+ **/
+func OrgEntryByID(db *gorm.DB, org Organization, id uint) (Vault, error) {
+    if err := currentOrgKey(db, org); err != nil {
+        return Vault{}, err
+    }
+
+    vault, err := orgEntry(db, org, id)
+    if err != nil {
+        return Vault{}, err
+    }
+
+    vaults := []Vault{vault}
+    if err := LoadFields(db, vaults); err != nil {
+        return Vault{}, err
+    }
+    vault = vaults[0]
+    vault.ItemKey = org.Key
+
+    if err := DecryptEntry(&vault, User{}); err != nil {
+        return Vault{}, err
+    }
+
+    return vault, nil
+}
+
+/**
+ * @brief:  Find an entry in one of the collections of an organization, still
+ *          encrypted
+ *
+ * @param:  db - Pointer to database or transaction
+ * @param:  org - Organization
+ * @param:  id - ID of the entry
+ *
+ * @return: Entry on success, ErrNotFound if the organization has none with
+ *          the ID, else error
+ **/
+func orgEntry(db *gorm.DB, org Organization, id uint) (Vault, error) {
+    var vault Vault
+    collections := db.Model(&Collection{}).Select("id").Where("organization_id = ?", org.ID).QueryExpr()
+    err := first(db.Where("id = ? AND collection_id IN (?)", id, collections), &vault)
+
+    return vault, err
+}
+
+/**
+ * @brief:  Add an entry to a collection, encrypted with the organization
+ *          key. The entry belongs to the organization, not the member.
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  actor - Member adding the entry, read-only members can't
+ * @param:  vault - New entry, its CollectionID set
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the organization has no such collection, ErrNotFound
+ *          If the role of the actor doesn't allow it, ErrRoleForbidden
+ *          If the organization key changed, ErrOrgRekeyed
+ *          Else, error
+ **/
+func CreateOrgEntry(db *gorm.DB, org Organization, actor User, vault *Vault, source string) error {
+    if err := requireRole(org.Role, RoleMember); err != nil {
+        return err
+    }
+    collection, err := collectionByID(db, org, vault.CollectionID)
+    if err != nil {
+        return err
+    }
+
+    vault.UserID, vault.ItemKey, vault.ItemKeyCipher = 0, org.Key, nil
+    if err := ValidateVaultEntry(vault, actor); err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        if _, err := lockOrganization(tx, org, actor, RoleMember); err != nil {
+            return err
+        }
+
+        if err := tx.Create(vault).Error; err != nil {
+            return err
+        }
+        if err := saveFields(tx, vault); err != nil {
+            return err
+        }
+
+        detail := fmt.Sprintf("entry %d added to %s", vault.ID, collection.Name)
+        return recordOrgAudit(tx, actor, org, source, detail)
+    })
+}
+
+/**
+ * @brief:  Save an edited entry of an organization. It stays in its
+ *          collection.
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  actor - Member editing the entry, read-only members can't
+ * @param:  vault - Edited entry, opened by OrgEntryByID
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the organization has no entry with the ID, ErrNotFound
+ *          If the role of the actor doesn't allow it, ErrRoleForbidden
+ *          If the organization key changed, ErrOrgRekeyed
+ *          Else, error
+ **/
+func UpdateOrgEntry(db *gorm.DB, org Organization, actor User, vault *Vault, source string) error {
+    if err := requireRole(org.Role, RoleMember); err != nil {
+        return err
+    }
+
+    vault.UserID, vault.ItemKey, vault.ItemKeyCipher = 0, org.Key, nil
+    if vault.CollectionID == 0 {
+        return ErrNotFound
+    }
+    if err := ValidateVaultEntry(vault, actor); err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        if _, err := lockOrganization(tx, org, actor, RoleMember); err != nil {
+            return err
+        }
+
+        stored, err := orgEntry(tx, org, vault.ID)
+        if err != nil {
+            return err
+        }
+        vault.CollectionID, vault.FolderCipher = stored.CollectionID, stored.FolderCipher
+
+        if err := tx.Save(vault).Error; err != nil {
+            return err
+        }
+        if err := saveFields(tx, vault); err != nil {
+            return err
+        }
+
+        return recordOrgAudit(tx, actor, org, source, fmt.Sprintf("entry %d edited", vault.ID))
+    })
+}
+
+/**
+ * @brief:  Delete an entry of an organization
+ *
+ * @param:  db - Pointer to database
+ * @param:  org - Organization opened by OpenOrganization
+ * @param:  actor - Member deleting the entry, read-only members can't
+ * @param:  id - ID of the entry
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the organization has no entry with the ID, ErrNotFound
+ *          If the role of the actor doesn't allow it, ErrRoleForbidden
+ *          Else, error
+ **/
+func DeleteOrgEntry(db *gorm.DB, org Organization, actor User, id uint, source string) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        if _, err := lockOrganization(tx, org, actor, RoleMember); err != nil {
+            return err
+        }
+
+        if _, err := orgEntry(tx, org, id); err != nil {
+            return err
+        }
+        if err := DeleteID(tx, id); err != nil {
+            return err
+        }
+
+        return recordOrgAudit(tx, actor, org, source, fmt.Sprintf("entry %d deleted", id))
+    })
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+const (
+    /* Peppers as "<version>:<secret>", separated by commas or new lines */
+    pepperEnv = "VAULTDEPOT_PEPPER"
+
+    /* File holding the peppers, one per line, takes precedence over pepperEnv */
+    pepperFileEnv = "VAULTDEPOT_PEPPER_FILE"
+
+    /* bcrypt cost of new hashes */
+    bcryptCostEnv = "VAULTDEPOT_BCRYPT_COST"
+
+    /* Shortest pepper accepted */
+    minPepperLen = 16
+)
+
+/**
+ * Peppers by version. Version 1 is the pepper vaultDepot always used, so
+ * hashes made before peppers were configurable keep working until their
+ * owner logs in and they are rehashed. It's reserved, configured peppers
+ * start at version 2.
+ **/
+var peppers = map[int]string{1: userPwPepper}
+
+/**
+ * Version of the pepper new hashes are made with, the highest one
+ **/
+var currentPepper = 1
+
+/**
+ * Cost of new bcrypt hashes, older hashes are rehashed at login
+ **/
+var bcryptCost = bcrypt.DefaultCost
+
+/**
+ * @brief:  Load the peppers and bcrypt cost from the environment. Peppers
+ *          are read from the file named by VAULTDEPOT_PEPPER_FILE, which
+ *          only its owner may read, else from VAULTDEPOT_PEPPER, as
+ *          "<version>:<secret>" entries, from version 2 on. Without
+ *          either the built-in pepper (version 1) is used.
+ *
+ * @return: nil on success, else error
+ **/
+func LoadHashing() error {
+    spec := os.Getenv(pepperEnv)
+    if path := os.Getenv(pepperFileEnv); path != "" {
+        data, err := readPrivateFile(path, ErrPepperFileMode)
+        if err != nil {
+            return err
+        }
+        spec = string(data)
+    }
+
+    if err := parsePeppers(spec); err != nil {
+        return err
+    }
+
+    if cost := os.Getenv(bcryptCostEnv); cost != "" {
+        n, err := strconv.Atoi(strings.TrimSpace(cost))
+        if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+            return ErrBcryptCostInvalid
+        }
+        bcryptCost = n
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Add peppers to the known ones, the highest version becomes the
+ *          current one. Empty lines and lines starting with '#' are skipped.
+ *
+ * @param:  spec - "<version>:<secret>" entries, separated by commas or new
+ *                 lines
+ *
+ * @return: nil on success, ErrPepperReserved if version 1 is configured,
+ *          else ErrPepperInvalid
+ **/
+func parsePeppers(spec string) error {
+    entries := strings.FieldsFunc(spec, func(r rune) bool {
+        return r == ',' || r == '\n'
+    })
+
+    for _, entry := range entries {
+        entry = strings.TrimSpace(entry)
+        if entry == "" || strings.HasPrefix(entry, "#") {
+            continue
+        }
+
+        parts := strings.SplitN(entry, ":", 2)
+        if len(parts) != 2 {
+            return ErrPepperInvalid
+        }
+        version, err := strconv.Atoi(parts[0])
+        if err != nil || version < 1 || len(parts[1]) < minPepperLen {
+            return ErrPepperInvalid
+        }
+
+        /* Replacing the built-in pepper would lock out every legacy hash */
+        if version == 1 {
+            return ErrPepperReserved
+        }
+
+        peppers[version] = parts[1]
+        if version > currentPepper {
+            currentPepper = version
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Hash a secret with the current pepper and cost
+ *
+ * @param:  secret - Password, secret key or auth hash
+ *
+ * @return: bcrypt hash on success, else error
+ **/
+func hashSecret(secret string) (string, error) {
+    hashed, err := bcrypt.GenerateFromPassword([]byte(secret + peppers[currentPepper]), bcryptCost)
+    if err != nil {
+        return "", err
+    }
+
+    return string(hashed), nil
+}
+
+/**
+ * @brief:  Check if a hash was made with an older pepper or another cost
+ *
+ * @param:  hash - bcrypt hash
+ * @param:  version - Pepper version of the hash
+ *
+ * @return: true if it should be rehashed, else false
+ **/
+func hashOutdated(hash string, version int) bool {
+    if version != currentPepper {
+        return true
+    }
+
+    cost, err := bcrypt.Cost([]byte(hash))
+    return err != nil || cost != bcryptCost
+}
+
+/**
+ * @brief:  Rehash the credentials of a user that just logged in, if they
+ *          were hashed with an older pepper or another cost
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Authenticated user, its hashes are updated
+ * @param:  password - Password, or auth hash of a remote account
+ * @param:  secretKey - Secret key, empty for remote accounts
+ *
+ * @return: nil on success, else error
+ **/
+func rehashIfOutdated(db *gorm.DB, user *User, password string, secretKey string) error {
+    outdated := hashOutdated(user.PasswordHash, user.PepperVersion)
+    if !user.Remote && hashOutdated(user.SecretKeyHash, user.PepperVersion) {
+        outdated = true
+    }
+    if !outdated {
+        return nil
+    }
+
+    rehashed := User{Password: password, SecretKey: secretKey}
+    if err := runUserValFns(&rehashed, bcryptPassword, bcryptSecretKey); err != nil {
+        return err
+    }
+
+    columns := map[string]interface{}{
+        "password_hash": rehashed.PasswordHash,
+        "pepper_version": rehashed.PepperVersion,
+    }
+    if !user.Remote {
+        columns["secret_key_hash"] = rehashed.SecretKeyHash
+    }
+    if err := db.Model(user).UpdateColumns(columns).Error; err != nil {
+        return err
+    }
+
+    user.PasswordHash = rehashed.PasswordHash
+    user.PepperVersion = rehashed.PepperVersion
+    if !user.Remote {
+        user.SecretKeyHash = rehashed.SecretKeyHash
+    }
+
+    return nil
+}
@@ -0,0 +1,304 @@
+package models
+
+import (
+    "encoding/hex"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * @brief:  Seal the vault key of a user with a new recovery key
+ *
+ * @param:  user - User with its vault key unlocked
+ *
+ * @return: Recovery words and the sealed vault key on success, else error
+ **/
+func newRecoveryKey(user User) (string, []byte, error) {
+    if user.Remote {
+        return "", nil, ErrRemoteAccount
+    } else if user.VaultKey == "" {
+        return "", nil, ErrVaultLocked
+    }
+
+    key, err := compat.Bytes(compat.RecoveryKeyBytes)
+    if err != nil {
+        return "", nil, err
+    }
+    words, err := compat.RecoveryWords(key)
+    if err != nil {
+        return "", nil, err
+    }
+
+    sealed, err := compat.SealArchive(hex.EncodeToString(key), []byte(user.VaultKey))
+    if err != nil {
+        return "", nil, err
+    }
+
+    return words, sealed, nil
+}
+
+/**
+ * @brief:  Create a recovery key for a user, replacing any older one. It
+ *          seals the vault key on its own, so the account can be recovered
+ *          without the secret key (see RecoverAccount).
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - User with its vault key unlocked
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: Recovery words, shown once, on success, else error
+ **/
+func CreateRecoveryKey(db *gorm.DB, user *User, source string) (string, error) {
+    words, sealed, err := newRecoveryKey(*user)
+    if err != nil {
+        return "", err
+    }
+
+    err = db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Model(user).UpdateColumn("recovery_key_cipher", sealed).Error; err != nil {
+            return err
+        }
+
+        return recordAudit(tx, AuditEvent{UserID: user.ID, Username: user.Username, Source: source,
+            Action: "recovery key", Detail: "recovery key created"})
+    })
+    if err != nil {
+        return "", err
+    }
+    user.RecoveryKeyCipher = sealed
+
+    return words, nil
+}
+
+/**
+ * @brief:  Reset the password and secret key of an account with its
+ *          recovery key. The vault key is sealed with the new secret key,
+ *          every session is revoked and the recovery key is replaced.
+ *          Failed attempts are throttled like logins.
+ *
+ * @param:  db - Pointer to database
+ * @param:  username - Username of the account
+ * @param:  words - Recovery words
+ * @param:  reset - New password and secret key
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: On success, the user with its vault key and the new recovery words
+ *          If the words aren't a recovery key, compat.ErrRecoveryWordsInvalid
+ *          If the username or recovery key is wrong, ErrRecoveryFailed
+ *          If the account or source failed too often, ErrLoginThrottled
+ *          Else, error
+ **/
+func RecoverAccount(db *gorm.DB, username string, words string, reset User, source string) (*User, string, error) {
+    key, err := compat.ParseRecoveryWords(words)
+    if err != nil {
+        return nil, "", err
+    }
+
+    err = runUserValFns(&reset,
+        userPasswordRequired,
+        secretKeyRequired,
+        passwordMinLength,
+        secretKeyMinLength,
+    )
+    if err != nil {
+        return nil, "", err
+    }
+
+    user, err := guardLogin(db, username, source, func() (*User, error) {
+        foundUser, err := ByUsername(db, username)
+        if err == ErrNotFound {
+            return nil, ErrRecoveryFailed
+        } else if err != nil {
+            return nil, err
+        }
+        if foundUser.Remote || len(foundUser.RecoveryKeyCipher) == 0 {
+            return foundUser, ErrRecoveryFailed
+        }
+
+        vaultKey, err := compat.OpenArchive(hex.EncodeToString(key), foundUser.RecoveryKeyCipher)
+        if err == compat.ErrArchivePassphrase {
+            return foundUser, ErrRecoveryFailed
+        } else if err != nil {
+            return nil, err
+        }
+        foundUser.VaultKey = string(vaultKey)
+
+        return foundUser, nil
+    })
+    if err != nil {
+        return nil, "", err
+    }
+
+    reset.VaultKey = user.VaultKey
+    if err := runUserValFns(&reset, wrapVaultKey, bcryptPassword, bcryptSecretKey); err != nil {
+        return nil, "", err
+    }
+    newWords, sealed, err := newRecoveryKey(*user)
+    if err != nil {
+        return nil, "", err
+    }
+
+    err = db.Transaction(func(tx *gorm.DB) error {
+        err := tx.Model(user).UpdateColumns(map[string]interface{}{
+            "password_hash": reset.PasswordHash,
+            "secret_key_hash": reset.SecretKeyHash,
+            "pepper_version": reset.PepperVersion,
+            "vault_key_cipher": reset.VaultKeyCipher,
+            "recovery_key_cipher": sealed,
+        }).Error
+        if err != nil {
+            return err
+        }
+
+        if err := RevokeSessions(tx, user.ID, 0); err != nil {
+            return err
+        }
+
+        return recordAudit(tx, AuditEvent{UserID: user.ID, Username: user.Username, Source: source,
+            Action: "recovery", Detail: "password and secret key reset with the recovery key"})
+    })
+    if err != nil {
+        return nil, "", err
+    }
+    user.PasswordHash, user.SecretKeyHash = reset.PasswordHash, reset.SecretKeyHash
+    user.PepperVersion = reset.PepperVersion
+    user.VaultKeyCipher, user.RecoveryKeyCipher = reset.VaultKeyCipher, sealed
+
+    return user, newWords, nil
+}
+
+/**
+ * @brief:  Split a recovery key into shares for several trusted people,
+ *          any threshold of them can recover the account together
+ *
+ * @param:  words - Recovery words
+ * @param:  shares - Number of shares, threshold to compat.MaxShares
+ * @param:  threshold - Shares needed to recover, at least 2
+ *
+ * @return: Words of each share on success, else error
+ **/
+func SplitRecoveryKey(words string, shares int, threshold int) ([]string, error) {
+    key, err := compat.ParseRecoveryWords(words)
+    if err != nil {
+        return nil, err
+    }
+
+    return compat.RecoveryShares(key, shares, threshold)
+}
+
+/**
+ * @brief:  Combine shares of a recovery key back into its words, to recover
+ *          the account with (see RecoverAccount)
+ *
+ * @param:  shares - Words of at least the threshold of shares
+ *
+ * @return: Recovery words on success, else error
+ **/
+func CombineRecoveryShares(shares []string) (string, error) {
+    parsed := make([]compat.RecoveryShare, len(shares))
+    for i, words := range shares {
+        share, err := compat.ParseRecoveryShare(words)
+        if err != nil {
+            return "", err
+        }
+        parsed[i] = share
+    }
+
+    key, err := compat.CombineRecoveryShares(parsed)
+    if err != nil {
+        return "", err
+    }
+
+    return compat.RecoveryWords(key)
+}
+
+/**
+ * @brief:  Lay out a recovery key as a printable emergency kit
+ *
+ * @param:  username - Account of the key
+ * @param:  words - Recovery words
+ *
+ * @return: Text of the kit
+ **/
+func EmergencyKit(username string, words string) string {
+    return emergencyKit(username, "Recovery key", words,
+        "The last word is a checksum, mistyped words are caught before the key\n" +
+        "is used.\n\n" +
+        "Forgot your password or secret key? Run vaultDepot with \"recover\" and\n" +
+        "enter your username and these words to choose new ones. All sessions\n" +
+        "are logged out and you get a new kit, this one stops working.\n\n" +
+        "Print this page or write the words down and keep it offline, somewhere\n" +
+        "safe. Anyone holding it can reset your account, two-factor login still\n" +
+        "asks for its code afterwards.\n")
+}
+
+/**
+ * @brief:  Lay out a share of a recovery key as a printable emergency kit,
+ *          for one of the people holding the shares
+ *
+ * @param:  username - Account of the key
+ * @param:  share - Words of the share
+ * @param:  shares - Number of shares the key was split into
+ *
+ * @return: Text of the kit, else error if the share can't be read
+ **/
+func ShareKit(username string, share string, shares int) (string, error) {
+    parsed, err := compat.ParseRecoveryShare(share)
+    if err != nil {
+        return "", err
+    }
+
+    title := fmt.Sprintf("Recovery share %d of %d", parsed.Index, shares)
+    notes := fmt.Sprintf("The key of this account is split into %d shares, any %d of them recover\n" +
+        "it together. Fewer shares reveal nothing about the key.\n\n" +
+        "The last word is a checksum, mistyped words are caught before the\n" +
+        "share is used.\n\n" +
+        "To recover the account, run vaultDepot with \"recover\", pick the\n" +
+        "shares and have %d holders enter their words. All sessions are logged\n" +
+        "out and the shares stop working.\n\n" +
+        "Print this page or write the words down and keep it offline, somewhere\n" +
+        "safe. Don't keep it together with other shares.\n", shares, parsed.Threshold, parsed.Threshold)
+
+    return emergencyKit(username, title, share, notes), nil
+}
+
+/**
+ * @brief:  Lay out words as a printable emergency kit
+ *
+ * @param:  username - Account of the key
+ * @param:  title - What the words are
+ * @param:  words - Words to write down
+ * @param:  notes - Instructions printed below the words
+ *
+ * @return: Text of the kit
+ **/
+func emergencyKit(username string, title string, words string, notes string) string {
+    list := strings.Fields(words)
+    rows := (len(list) + 2) / 3
+
+    var kit strings.Builder
+    kit.WriteString("vaultDepot emergency kit\n")
+    kit.WriteString("========================\n\n")
+    fmt.Fprintf(&kit, "Account:  %s\n", username)
+    fmt.Fprintf(&kit, "Created:  %s\n\n", time.Now().Format("2006-01-02"))
+    title = fmt.Sprintf("%s (%d words)", title, len(list))
+    fmt.Fprintf(&kit, "%s\n%s\n", title, strings.Repeat("-", len(title)))
+    for row := 0; row < rows; row++ {
+        line := ""
+        for col := 0; col < 3; col++ {
+            i := col * rows + row
+            if i < len(list) {
+                line += fmt.Sprintf("%3d. %-12s", i + 1, list[i])
+            }
+        }
+        kit.WriteString(strings.TrimRight(line, " ") + "\n")
+    }
+    kit.WriteString("\n" + notes)
+
+    return kit.String()
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+    "encoding/hex"
+
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * Length of the auth hash a remote client derives (see
+ * compat.DeriveAccountKeys), hex encoded
+ **/
+const authHashLen = 64
+
+/**
+ * Largest sealed entry accepted from a remote client, attachments aren't
+ * part of entries
+ **/
+const maxSealedLen = 512 << 10
+
+/**
+ * @brief:  Check the password of a remote account is an auth hash
+ *
+ * @param:  user - Contains the auth hash as password
+ *
+ * @return: nil on success, else ErrAuthHashInvalid
+ **/
+func authHashFormat(user *User) error {
+    if len(user.Password) != authHashLen {
+        return ErrAuthHashInvalid
+    }
+    if _, err := hex.DecodeString(user.Password); err != nil {
+        return ErrAuthHashInvalid
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Create the account of a remote client. The client derives an auth
+ *          hash from the password and secret key, the server only keeps its
+ *          bcrypt hash and never sees the key the entries are sealed with.
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Username, and the auth hash as password
+ *
+ * @return: nil on success, else error
+ **/
+func CreateRemoteUser(db *gorm.DB, user *User) error {
+    user.Remote = true
+    err := runUserValFns(user,
+        userPasswordRequired,
+        authHashFormat,
+        bcryptPassword,
+        passwordHashRequired,
+    )
+    if err != nil {
+        return err
+    }
+
+    return db.Create(&user).Error
+}
+
+/**
+ * @brief:  Authenticate a remote client with the auth hash it derived
+ *
+ * @param:  userdb - Pointer to database
+ * @param:  username - Username of the account
+ * @param:  authHash - Auth hash derived by the client
+ * @param:  otp - Two-factor code, empty if none was asked for yet
+ * @param:  source - Address the login came from
+ *
+ * @return: If the account or source failed too often, return ErrLoginThrottled
+ *          If the username or auth hash is invalid, or the account isn't
+ *          remote, return ErrLoginFailed
+ *          If the two-factor code is missing, return ErrTwoFactorRequired
+ *          If the two-factor code is wrong, return ErrTwoFactorInvalid
+ *          If both are vaild, return user, rehashed if its hash was
+ *          outdated
+ *          Else, error
+ **/
+func AuthenticateRemote(userdb *gorm.DB, username string, authHash string, otp string, source string) (*User, error) {
+    user, err := guardLogin(userdb, username, source, func() (*User, error) {
+        foundUser, err := ByUsername(userdb, username)
+        if err == ErrNotFound {
+            return nil, compareSecret("", authHash, currentPepper)
+        } else if err != nil {
+            return nil, err
+        }
+
+        if err := compareSecret(foundUser.PasswordHash, authHash, foundUser.PepperVersion); err != nil {
+            return foundUser, err
+        }
+        if !foundUser.Remote {
+            return foundUser, ErrLoginFailed
+        }
+
+        return foundUser, secondFactor(userdb, foundUser, otp, source)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if err := rehashIfOutdated(userdb, user, authHash, ""); err != nil {
+        return nil, err
+    }
+
+    return user, nil
+}
+
+/**
+ * @brief:  Find the sealed entries of a remote account
+ *
+ * @param:  db - Pointer to database
+ * @param:  userID - ID of the remote account
+ *
+ * @return: Entries on success, else error
+ **/
+func RemoteEntries(db *gorm.DB, userID uint) ([]RemoteEntry, error) {
+    var entries []RemoteEntry
+    if err := find(db.Where("user_id = ?", userID).Order("id"), &entries); err != nil {
+        return nil, err
+    }
+
+    return entries, nil
+}
+
+/**
+ * @brief:  Add a sealed entry, or replace one the account owns
+ *
+ * @param:  db - Pointer to database
+ * @param:  entry - Entry with the owner and cipher, and its ID to replace it
+ *
+ * @return: nil on success, ErrNotFound if the entry to replace isn't the
+ *          account's, else error
+ **/
+func SaveRemoteEntry(db *gorm.DB, entry *RemoteEntry) error {
+    if len(entry.Cipher) == 0 || len(entry.Cipher) > maxSealedLen {
+        return ErrSealedInvalid
+    }
+
+    if entry.ID == 0 {
+        return db.Create(entry).Error
+    }
+
+    var found RemoteEntry
+    if err := first(db.Where("id = ? AND user_id = ?", entry.ID, entry.UserID), &found); err != nil {
+        return err
+    }
+    found.Cipher = entry.Cipher
+    if err := db.Save(&found).Error; err != nil {
+        return err
+    }
+    *entry = found
+
+    return nil
+}
+
+/**
+ * @brief:  Delete a sealed entry the account owns
+ *
+ * @param:  db - Pointer to database
+ * @param:  userID - ID of the remote account
+ * @param:  id - ID of the entry
+ *
+ * @return: nil on success, ErrNotFound if the account has no such entry,
+ *          else error
+ **/
+func DeleteRemoteEntry(db *gorm.DB, userID uint, id uint) error {
+    result := db.Unscoped().Where("id = ? AND user_id = ?", id, userID).Delete(&RemoteEntry{})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrNotFound
+    }
+
+    return nil
+}
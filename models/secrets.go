@@ -0,0 +1,216 @@
+package models
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+const (
+    /* Key of the HMACs of remember tokens and recovery codes */
+    hmacKeyEnv = "VAULTDEPOT_HMAC_KEY"
+
+    /* File holding the HMAC key, takes precedence over hmacKeyEnv */
+    hmacKeyFileEnv = "VAULTDEPOT_HMAC_KEY_FILE"
+
+    /* Key the TOTP secrets of two-factor login are encrypted with */
+    totpKeyEnv = "VAULTDEPOT_TOTP_KEY"
+
+    /* File holding the TOTP key, takes precedence over totpKeyEnv */
+    totpKeyFileEnv = "VAULTDEPOT_TOTP_KEY_FILE"
+
+    /* TOTP key replaced by totpKeyEnv, optional, only read by MigrateTwoFactor */
+    totpPreviousKeyEnv = "VAULTDEPOT_TOTP_PREVIOUS_KEY"
+
+    /* File holding the previous TOTP key, takes precedence over totpPreviousKeyEnv */
+    totpPreviousKeyFileEnv = "VAULTDEPOT_TOTP_PREVIOUS_KEY_FILE"
+
+    /* Shortest server key accepted */
+    minServerKeyLen = 32
+)
+
+/**
+ * Built-in keys of older versions. Only used to read what was stored with
+ * them: MigrateTwoFactor encrypts the TOTP secrets again, and recovery
+ * codes hashed with it are accepted until they're replaced.
+ **/
+var (
+    legacyTwoFactorAES = compat.NewAES(totpSecretKey)
+    legacyRecoveryHMAC = compat.NewHMAC(hmacSecretKey)
+)
+
+/**
+ * Keys TOTP secrets may still be encrypted with until MigrateTwoFactor
+ * encrypts them again: the previous TOTP key if one is configured, and the
+ * built-in one. Set by LoadSecrets.
+ **/
+var previousTwoFactorAES []compat.AES
+
+/**
+ * Fingerprint of the configured TOTP key, stored with the secrets it
+ * encrypts (see MigrateTwoFactor). Set by LoadSecrets.
+ **/
+var totpKeyID string
+
+/**
+ * @brief:  Load the HMAC and TOTP keys of the server from the environment.
+ *          Each is read from the file named by its _FILE variable, which
+ *          only its owner may read, else from the variable itself. There's
+ *          no default: without both keys, or with the built-in ones, the
+ *          server refuses to start. A previous TOTP key is optional.
+ *
+ * @return: nil on success, else error
+ **/
+func LoadSecrets() error {
+    hmacKey, err := readServerKey(hmacKeyEnv, hmacKeyFileEnv, hmacSecretKey)
+    if err != nil {
+        return err
+    }
+    totpKey, err := readServerKey(totpKeyEnv, totpKeyFileEnv, totpSecretKey)
+    if err != nil {
+        return err
+    }
+
+    previousKey, err := readServerKey(totpPreviousKeyEnv, totpPreviousKeyFileEnv, totpSecretKey)
+    if err != nil && err != ErrServerKeyMissing {
+        return err
+    }
+
+    rememberHMAC = compat.NewHMAC(hmacKey)
+    recoveryHMAC = compat.NewHMAC(hmacKey)
+    twoFactorAES = compat.NewAES(totpKey)
+    totpKeyID = compat.NewHMAC(totpKey).Hash(totpKeyEnv)
+
+    previousTwoFactorAES = []compat.AES{legacyTwoFactorAES}
+    if previousKey != "" {
+        previousTwoFactorAES = append([]compat.AES{compat.NewAES(previousKey)}, previousTwoFactorAES...)
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Read a key of the server from a file or the environment
+ *
+ * @param:  env - Variable holding the key
+ * @param:  fileEnv - Variable naming the file holding the key
+ * @param:  builtIn - Key of older versions, which is refused
+ *
+ * @return: Key on success
+ *          If neither variable is set, ErrServerKeyMissing
+ *          If the key is short or the built-in one, ErrServerKeyInvalid
+ *          Else, error
+ **/
+func readServerKey(env string, fileEnv string, builtIn string) (string, error) {
+    key := os.Getenv(env)
+    if path := os.Getenv(fileEnv); path != "" {
+        data, err := readPrivateFile(path, ErrServerKeyFileMode)
+        if err != nil {
+            return "", err
+        }
+        key = string(data)
+    }
+
+    key = strings.TrimSpace(key)
+    if key == "" {
+        return "", ErrServerKeyMissing
+    } else if key == builtIn || len(key) < minServerKeyLen {
+        return "", ErrServerKeyInvalid
+    }
+
+    return key, nil
+}
+
+/**
+ * @brief:  Read a file holding secrets, which only its owner may read
+ *
+ * @param:  path - Path of the file
+ * @param:  modeErr - Error if others may read it
+ *
+ * @return: Content of the file on success, else error
+ **/
+func readPrivateFile(path string, modeErr error) ([]byte, error) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return nil, err
+    }
+    if info.Mode().Perm() & 0077 != 0 {
+        return nil, modeErr
+    }
+
+    return ioutil.ReadFile(path)
+}
+
+/**
+ * @brief:  Encrypt the TOTP secrets that aren't encrypted with the
+ *          configured key yet: those of older versions, encrypted with the
+ *          built-in key, and those encrypted with the previous key. Only
+ *          accounts whose secrets have another key fingerprint are read, so
+ *          it's done once. A secret that opens with none of the keys is
+ *          reported and left as it is, that account can't log in with a
+ *          code until its key is configured again.
+ *
+ * @param:  db - pointer to dabase
+ *
+ * @return: nil on success, else error
+ **/
+func MigrateTwoFactor(db *gorm.DB) error {
+    var users []User
+    err := db.Select("id, username, totp_cipher, totp_pending_cipher").
+        Where("(totp_cipher IS NOT NULL OR totp_pending_cipher IS NOT NULL) AND totp_key_id <> ?", totpKeyID).
+        Find(&users).Error
+    if err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        for _, user := range users {
+            columns, err := reencryptTwoFactor(user)
+            if err != nil {
+                fmt.Printf("Two-factor secret of %s opens with no configured TOTP key, leaving it...\n", user.Username)
+                continue
+            }
+
+            if err := tx.Model(&User{}).Where("id = ?", user.ID).UpdateColumns(columns).Error; err != nil {
+                return err
+            }
+        }
+
+        return nil
+    })
+}
+
+/**
+ * @brief:  Encrypt the TOTP secrets of an account with the configured key
+ *
+ * @param:  user - Account with its TOTP ciphers
+ *
+ * @return: Columns to update on success, else error if a secret opens with
+ *          none of the keys
+ **/
+func reencryptTwoFactor(user User) (map[string]interface{}, error) {
+    columns := map[string]interface{}{"totp_key_id": totpKeyID}
+    for column, cipher := range map[string][]byte{
+        "totp_cipher": user.TOTPCipher,
+        "totp_pending_cipher": user.TOTPPendingCipher,
+    } {
+        if len(cipher) == 0 {
+            continue
+        }
+
+        uri, err := openTwoFactor(cipher)
+        if err != nil {
+            return nil, err
+        }
+        if columns[column], err = twoFactorAES.Encrypt(uri); err != nil {
+            return nil, err
+        }
+    }
+
+    return columns, nil
+}
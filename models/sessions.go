@@ -0,0 +1,380 @@
+package models
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * How long a session stays valid after login
+ **/
+const sessionTTL = 30 * 24 * time.Hour
+
+/**
+ * Hashes remember tokens before they are stored or looked up, set by
+ * LoadSecrets
+ **/
+var rememberHMAC compat.HMAC
+
+type sessionValFn func(*Session) error
+
+/**
+ * @brief:  Iterate over each validation and
+ *          normalization function
+ *
+ * @param:  session - Session that is being validated and
+ *                    normalized
+ * @param:  fns - List of functions to run
+ *
+ * @return: nil on success, else error
+ **/
+func runSessionValFns(session *Session, fns ...sessionValFn) error {
+    for _, fn := range fns {
+        if err := fn(session); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Generate a remember token if the session has none
+ *
+ * @param:  session - Contains remember token
+ *
+ * @return: nil on success, else error
+ **/
+func setRememberIfUnset(session *Session) error {
+    if session.Remember != "" {
+        return nil
+    }
+
+    token, err := compat.RememberToken()
+    if err != nil {
+        return err
+    }
+    session.Remember = token
+
+    return nil
+}
+
+/**
+ * @brief:  Check the remember token holds at least RememberTokenBytes
+ *          random bytes
+ *
+ * @param:  session - Contains remember token
+ *
+ * @return: nil on success, else ErrRememberTooShort
+ **/
+func rememberMinBytes(session *Session) error {
+    n, err := compat.NBytes(session.Remember)
+    if err != nil || n < compat.RememberTokenBytes {
+        return ErrRememberTooShort
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Hash the remember token, the token itself is never stored
+ *
+ * @param:  session - Contains remember token
+ *
+ * @return: nil
+ **/
+func hmacRemember(session *Session) error {
+    session.RememberHash = rememberHMAC.Hash(session.Remember)
+
+    return nil
+}
+
+/**
+ * @brief:  Check the remember token was hashed
+ *
+ * @param:  session - Contains remember hash
+ *
+ * @return: nil on success, else ErrRememberRequired
+ **/
+func rememberHashRequired(session *Session) error {
+    if session.RememberHash == "" {
+        return ErrRememberRequired
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Start a session for a logged in user. The remember token is only
+ *          returned here, the database keeps its HMAC.
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Logged in user
+ * @param:  label - Where the session was started
+ *
+ * @return: Session with its remember token on success, else error
+ **/
+func CreateSession(db *gorm.DB, user User, label string) (Session, error) {
+    now := time.Now()
+    session := Session{
+        UserID: user.ID,
+        Label: label,
+        ExpiresAt: now.Add(sessionTTL),
+        LastUsedAt: now,
+    }
+
+    err := runSessionValFns(&session,
+        setRememberIfUnset,
+        rememberMinBytes,
+        hmacRemember,
+        rememberHashRequired,
+    )
+    if err != nil {
+        return Session{}, err
+    }
+
+    if user.VaultKey != "" {
+        session.VaultKeyCipher, err = compat.NewAES(session.Remember).Encrypt(user.VaultKey)
+        if err != nil {
+            return Session{}, err
+        }
+    }
+
+    /* Expired sessions can't be used anymore, no need to keep them */
+    err = db.Unscoped().Where("user_id = ? AND expires_at < ?", user.ID, now).Delete(&Session{}).Error
+    if err != nil {
+        return Session{}, err
+    }
+
+    if err := db.Create(&session).Error; err != nil {
+        return Session{}, err
+    }
+
+    return session, nil
+}
+
+/**
+ * @brief:  Look up the session and user of a remember token, mark the
+ *          session as used and unseal the user's vault key with the token
+ *
+ * @param:  db - Pointer to database
+ * @param:  token - Remember token
+ *
+ * @return: Session and user on success, ErrSessionInvalid if the token is
+ *          unknown, expired or revoked, or the session has no vault key
+ *          to unseal, else error
+ **/
+func ByRemember(db *gorm.DB, token string) (*Session, *User, error) {
+    session, user, err := findSession(db, token)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    /* Sessions from before vault keys can't unlock a migrated vault */
+    if len(session.VaultKeyCipher) > 0 {
+        key, err := compat.NewAES(token).Decrypt(session.VaultKeyCipher)
+        if err != nil {
+            return nil, nil, ErrSessionInvalid
+        }
+        user.VaultKey = key
+    } else if len(user.VaultKeyCipher) > 0 {
+        return nil, nil, ErrSessionInvalid
+    }
+
+    return session, user, nil
+}
+
+/**
+ * @brief:  Look up the session and user of a remember token, and mark the
+ *          session as used
+ *
+ * @param:  db - Pointer to database
+ * @param:  token - Remember token
+ *
+ * @return: Session and user, still locked, on success, ErrSessionInvalid
+ *          if the token is unknown, expired or revoked, else error
+ **/
+func findSession(db *gorm.DB, token string) (*Session, *User, error) {
+    session := Session{Remember: token}
+    if err := runSessionValFns(&session, rememberMinBytes, hmacRemember); err != nil {
+        return nil, nil, ErrSessionInvalid
+    }
+
+    now := time.Now()
+    query := db.Where("remember_hash = ? AND revoked_at IS NULL AND expires_at > ?", session.RememberHash, now)
+    if err := first(query, &session); err != nil {
+        if err == ErrNotFound {
+            return nil, nil, ErrSessionInvalid
+        }
+        return nil, nil, err
+    }
+
+    var user User
+    if err := first(db.Where("id = ?", session.UserID), &user); err != nil {
+        if err == ErrNotFound {
+            return nil, nil, ErrSessionInvalid
+        }
+        return nil, nil, err
+    }
+
+    session.LastUsedAt = now
+    if err := db.Model(&session).UpdateColumn("last_used_at", now).Error; err != nil {
+        return nil, nil, err
+    }
+
+    return &session, &user, nil
+}
+
+/**
+ * @brief:  List the sessions of a user that can still be used, most
+ *          recently used first
+ *
+ * @param:  db - Pointer to database
+ * @param:  userID - ID of the user
+ *
+ * @return: Sessions on success, else error
+ **/
+func ActiveSessions(db *gorm.DB, userID uint) ([]Session, error) {
+    var sessions []Session
+    query := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+        Order("last_used_at DESC")
+    if err := find(query, &sessions); err != nil && err != ErrNotFound {
+        return nil, err
+    }
+
+    return sessions, nil
+}
+
+/**
+ * @brief:  Revoke a session of a user
+ *
+ * @param:  db - Pointer to database
+ * @param:  userID - ID of the user owning the session
+ * @param:  id - ID of the session
+ *
+ * @return: nil on success, ErrNotFound if the user has no such active
+ *          session, else error
+ **/
+func RevokeSession(db *gorm.DB, userID uint, id uint) error {
+    query := db.Model(&Session{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+        UpdateColumn("revoked_at", time.Now())
+    if query.Error != nil {
+        return query.Error
+    }
+    if query.RowsAffected == 0 {
+        return ErrNotFound
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Revoke every session of a user but one, e.g. the current one
+ *
+ * @param:  db - Pointer to database
+ * @param:  userID - ID of the user
+ * @param:  keepID - ID of the session to keep, 0 to revoke all
+ *
+ * @return: nil on success, else error
+ **/
+func RevokeSessions(db *gorm.DB, userID uint, keepID uint) error {
+    return db.Model(&Session{}).Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepID).
+        UpdateColumn("revoked_at", time.Now()).Error
+}
+
+/**
+ * @brief:  Log out by revoking the session of a remember token. The vault
+ *          key isn't needed, sessions that can't unseal it log out too.
+ *
+ * @param:  db - Pointer to database
+ * @param:  token - Remember token
+ *
+ * @return: nil on success, ErrSessionInvalid if the session isn't active,
+ *          else error
+ **/
+func Logout(db *gorm.DB, token string) error {
+    session, _, err := findSession(db, token)
+    if err != nil {
+        return err
+    }
+
+    return RevokeSession(db, session.UserID, session.ID)
+}
+
+/**
+ * @brief:  Get the file the remember token of the command line is kept in
+ *
+ * @return: Path on success, else error
+ **/
+func rememberFile() (string, error) {
+    dir, err := os.UserConfigDir()
+    if err != nil {
+        return "", err
+    }
+
+    return filepath.Join(dir, "vaultdepot", "session"), nil
+}
+
+/**
+ * @brief:  Keep the remember token for the next run, readable only by the
+ *          user
+ *
+ * @param:  token - Remember token
+ *
+ * @return: nil on success, else error
+ **/
+func SaveRememberToken(token string) error {
+    path, err := rememberFile()
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+        return err
+    }
+
+    return ioutil.WriteFile(path, []byte(token), 0600)
+}
+
+/**
+ * @brief:  Read the remember token kept by SaveRememberToken
+ *
+ * @return: Token, empty if there is none
+ **/
+func LoadRememberToken() string {
+    path, err := rememberFile()
+    if err != nil {
+        return ""
+    }
+
+    token, err := ioutil.ReadFile(path)
+    if err != nil {
+        return ""
+    }
+
+    return strings.TrimSpace(string(token))
+}
+
+/**
+ * @brief:  Remove the remember token kept by SaveRememberToken
+ *
+ * @return: nil on success, else error
+ **/
+func ForgetRememberToken() error {
+    path, err := rememberFile()
+    if err != nil {
+        return err
+    }
+
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+
+    return nil
+}
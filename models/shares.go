@@ -0,0 +1,558 @@
+package models
+
+import (
+    "bytes"
+    "encoding/hex"
+    "fmt"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+const (
+    /* Whom an entry is shared with can read it */
+    PermissionReadOnly = "read-only"
+
+    /* Whom an entry is shared with can read and edit it */
+    PermissionReadWrite = "read-write"
+
+    /* Random bytes of an item key, hex encoded in Vault.ItemKey */
+    itemKeyBytes = 32
+)
+
+/**
+ * @brief:  Get the key an entry is encrypted with: its item key once it's
+ *          shared, else the owner's vault key
+ *
+ * @param:  vault - Entry, its ItemKey is set when opened through a share
+ * @param:  user - Owner of the entry, or whom it's shared with
+ *
+ * @return: Key on success, else error
+ **/
+func entryAES(vault Vault, user User) (compat.AES, error) {
+    if vault.ItemKey != "" {
+        return compat.NewAES(vault.ItemKey), nil
+    } else if len(vault.ItemKeyCipher) == 0 {
+        return compat.NewAES(user.VaultKey), nil
+    }
+
+    itemKey, err := compat.NewAES(user.VaultKey).Decrypt(vault.ItemKeyCipher)
+    if err != nil {
+        return compat.AES{}, err
+    }
+
+    return compat.NewAES(itemKey), nil
+}
+
+/**
+ * @brief:  Give a new user a key pair, the private half encrypted with
+ *          the vault key. Runs after wrapVaultKey.
+ *
+ * @param:  user - Contains vault key
+ *
+ * @return: nil on success, else error
+ **/
+func generateKeyPair(user *User) error {
+    if user.VaultKey == "" {
+        return nil
+    }
+
+    public, private, err := compat.NewKeyPair()
+    if err != nil {
+        return err
+    }
+
+    privateCipher, err := compat.NewAES(user.VaultKey).Encrypt(hex.EncodeToString(private))
+    if err != nil {
+        return err
+    }
+    user.PublicKey, user.PrivateKeyCipher = public, privateCipher
+
+    return nil
+}
+
+/**
+ * @brief:  Give an account from before key pairs a key pair
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - User with its vault key unlocked
+ *
+ * @return: nil on success, else error
+ **/
+func ensureKeyPair(db *gorm.DB, user *User) error {
+    if len(user.PublicKey) > 0 {
+        return nil
+    }
+
+    if err := generateKeyPair(user); err != nil {
+        return err
+    }
+
+    /* Another login may have made one meanwhile, keep the first */
+    result := db.Model(&User{}).Where("id = ? AND public_key IS NULL", user.ID).UpdateColumns(map[string]interface{}{
+        "public_key": user.PublicKey,
+        "private_key_cipher": user.PrivateKeyCipher,
+    })
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        var stored User
+        if err := first(db.Where("id = ?", user.ID), &stored); err != nil {
+            return err
+        }
+        user.PublicKey, user.PrivateKeyCipher = stored.PublicKey, stored.PrivateKeyCipher
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Open an item or organization key sealed to a user's public key
+ *
+ * @param:  user - User with its vault key unlocked
+ * @param:  sealed - Key sealed by SealTo
+ *
+ * @return: Key on success, else error
+ **/
+func openSealedKey(user User, sealed []byte) (string, error) {
+    private, err := compat.NewAES(user.VaultKey).Decrypt(user.PrivateKeyCipher)
+    if err != nil {
+        return "", err
+    }
+    privateKey, err := hex.DecodeString(private)
+    if err != nil {
+        return "", err
+    }
+
+    key, err := compat.OpenSealed(user.PublicKey, privateKey, sealed)
+    if err != nil {
+        return "", err
+    }
+
+    return string(key), nil
+}
+
+/**
+ * @brief:  Check a permission of a share
+ *
+ * @param:  permission - PermissionReadOnly or PermissionReadWrite
+ *
+ * @return: nil on success, else ErrPermissionInvalid
+ **/
+func ValidPermission(permission string) error {
+    if permission != PermissionReadOnly && permission != PermissionReadWrite {
+        return ErrPermissionInvalid
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Lock an entry of its owner for the rest of a transaction
+ *
+ * @param:  tx - Transaction
+ * @param:  id - ID of the entry
+ * @param:  owner - Owner of the entry
+ *
+ * @return: Entry as stored on success, ErrNotFound if the owner has none
+ *          with the ID, else error
+ **/
+func lockEntry(tx *gorm.DB, id uint, owner User) (Vault, error) {
+    var vault Vault
+    err := first(tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ? AND user_id = ?", id, owner.ID), &vault)
+
+    return vault, err
+}
+
+/**
+ * @brief:  Share an entry with another user, or change the permission of
+ *          a share. The first time an entry is shared it gets an item key
+ *          and is encrypted with it instead of the vault key; the item key
+ *          is sealed to the user's public key.
+ *
+ * @param:  db - Pointer to database
+ * @param:  vault - Entry to share
+ * @param:  owner - Owner of the entry, with its vault key unlocked
+ * @param:  username - User to share the entry with
+ * @param:  permission - PermissionReadOnly or PermissionReadWrite
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If the user doesn't exist, ErrNotFound
+ *          If the entry is shared with its owner, ErrShareSelf
+ *          If the user has no key pair yet, ErrShareNoKey
+ *          Else, error
+ **/
+func ShareEntry(db *gorm.DB, vault Vault, owner User, username string, permission string, source string) error {
+    if err := ValidPermission(permission); err != nil {
+        return err
+    }
+
+    grantee, err := ByUsername(db, username)
+    if err != nil {
+        return err
+    }
+    if grantee.ID == owner.ID {
+        return ErrShareSelf
+    } else if grantee.Remote || len(grantee.PublicKey) == 0 {
+        return ErrShareNoKey
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        stored, err := lockEntry(tx, vault.ID, owner)
+        if err != nil {
+            return err
+        }
+
+        itemKey, err := itemizeEntry(tx, stored, owner)
+        if err != nil {
+            return err
+        }
+        sealed, err := compat.SealTo(grantee.PublicKey, []byte(itemKey))
+        if err != nil {
+            return err
+        }
+
+        var share Share
+        err = first(tx.Where("vault_id = ? AND user_id = ?", stored.ID, grantee.ID), &share)
+        if err == ErrNotFound {
+            share = Share{VaultID: stored.ID, UserID: grantee.ID}
+        } else if err != nil {
+            return err
+        }
+        share.ItemKeyCipher, share.Permission = sealed, permission
+        if err := tx.Save(&share).Error; err != nil {
+            return err
+        }
+
+        detail := fmt.Sprintf("entry %d shared %s", stored.ID, permission)
+        err = recordAudit(tx, AuditEvent{UserID: owner.ID, Username: owner.Username, Source: source,
+            Action: "share", Detail: detail + " with " + grantee.Username})
+        if err != nil {
+            return err
+        }
+
+        return recordAudit(tx, AuditEvent{UserID: grantee.ID, Username: grantee.Username, Source: source,
+            Action: "share", Detail: detail + " by " + owner.Username})
+    })
+}
+
+/**
+ * @brief:  Give an entry an item key and encrypt it with it, unless it has
+ *          one already
+ *
+ * @param:  tx - Transaction the entry is locked in
+ * @param:  vault - Entry as stored
+ * @param:  owner - Owner of the entry, with its vault key unlocked
+ *
+ * @return: Item key on success, else error
+ **/
+func itemizeEntry(tx *gorm.DB, vault Vault, owner User) (string, error) {
+    vaultAES := compat.NewAES(owner.VaultKey)
+    if len(vault.ItemKeyCipher) > 0 {
+        return vaultAES.Decrypt(vault.ItemKeyCipher)
+    }
+
+    key, err := compat.Bytes(itemKeyBytes)
+    if err != nil {
+        return "", err
+    }
+    itemKey := hex.EncodeToString(key)
+
+    if err := reencryptVaults(tx, []Vault{vault}, vaultAES, compat.NewAES(itemKey)); err != nil {
+        return "", err
+    }
+
+    itemKeyCipher, err := vaultAES.Encrypt(itemKey)
+    if err != nil {
+        return "", err
+    }
+    if err := tx.Model(&vault).UpdateColumn("item_key_cipher", itemKeyCipher).Error; err != nil {
+        return "", err
+    }
+
+    return itemKey, nil
+}
+
+/**
+ * @brief:  Find whom an entry is shared with
+ *
+ * @param:  db - Pointer to database
+ * @param:  vault - Entry of the owner
+ *
+ * @return: Shares with the usernames they're shared with on success, else
+ *          error
+ **/
+func EntryShares(db *gorm.DB, vault Vault) ([]Share, error) {
+    var shares []Share
+    if err := db.Where("vault_id = ?", vault.ID).Order("id").Find(&shares).Error; err != nil {
+        return nil, err
+    }
+
+    for i := range shares {
+        var grantee User
+        if err := first(db.Where("id = ?", shares[i].UserID), &grantee); err != nil {
+            return nil, err
+        }
+        shares[i].Username = grantee.Username
+    }
+
+    return shares, nil
+}
+
+/**
+ * @brief:  Stop sharing an entry with a user. The entry gets a new item key,
+ *          sealed again to whom it's still shared with, so what the user may
+ *          have kept of the old one opens nothing anymore.
+ *
+ * @param:  db - Pointer to database
+ * @param:  vault - Entry of the owner
+ * @param:  owner - Owner of the entry, with its vault key unlocked
+ * @param:  username - User to stop sharing with
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success, ErrNotFound if it isn't shared with the user,
+ *          else error
+ **/
+func RevokeShare(db *gorm.DB, vault Vault, owner User, username string, source string) error {
+    grantee, err := ByUsername(db, username)
+    if err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        stored, err := lockEntry(tx, vault.ID, owner)
+        if err != nil {
+            return err
+        }
+
+        result := tx.Unscoped().Where("vault_id = ? AND user_id = ?", stored.ID, grantee.ID).Delete(&Share{})
+        if result.Error != nil {
+            return result.Error
+        } else if result.RowsAffected == 0 {
+            return ErrNotFound
+        }
+
+        if err := rotateItemKey(tx, stored, owner); err != nil {
+            return err
+        }
+
+        detail := fmt.Sprintf("entry %d no longer shared", stored.ID)
+        err = recordAudit(tx, AuditEvent{UserID: owner.ID, Username: owner.Username, Source: source,
+            Action: "unshare", Detail: detail + " with " + grantee.Username})
+        if err != nil {
+            return err
+        }
+
+        return recordAudit(tx, AuditEvent{UserID: grantee.ID, Username: grantee.Username, Source: source,
+            Action: "unshare", Detail: detail + " by " + owner.Username})
+    })
+}
+
+/**
+ * @brief:  Encrypt an entry with a new item key and seal it to whom the
+ *          entry is shared with
+ *
+ * @param:  tx - Transaction the entry is locked in
+ * @param:  vault - Entry as stored, with an item key
+ * @param:  owner - Owner of the entry, with its vault key unlocked
+ *
+ * @return: nil on success, else error
+ **/
+func rotateItemKey(tx *gorm.DB, vault Vault, owner User) error {
+    vaultAES := compat.NewAES(owner.VaultKey)
+    oldKey, err := vaultAES.Decrypt(vault.ItemKeyCipher)
+    if err != nil {
+        return err
+    }
+
+    key, err := compat.Bytes(itemKeyBytes)
+    if err != nil {
+        return err
+    }
+    itemKey := hex.EncodeToString(key)
+
+    if err := reencryptVaults(tx, []Vault{vault}, compat.NewAES(oldKey), compat.NewAES(itemKey)); err != nil {
+        return err
+    }
+
+    itemKeyCipher, err := vaultAES.Encrypt(itemKey)
+    if err != nil {
+        return err
+    }
+    if err := tx.Model(&vault).UpdateColumn("item_key_cipher", itemKeyCipher).Error; err != nil {
+        return err
+    }
+
+    var shares []Share
+    if err := tx.Where("vault_id = ?", vault.ID).Find(&shares).Error; err != nil {
+        return err
+    }
+    for _, share := range shares {
+        var grantee User
+        if err := first(tx.Where("id = ?", share.UserID), &grantee); err != nil {
+            return err
+        }
+
+        sealed, err := compat.SealTo(grantee.PublicKey, []byte(itemKey))
+        if err != nil {
+            return err
+        }
+        if err := tx.Model(&share).UpdateColumn("item_key_cipher", sealed).Error; err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Find the entries shared with a user, with their metadata
+ *          decrypted
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - User with its vault key unlocked
+ *
+ * @return: Entries, each with the Share it's opened through, on success,
+ *          else error
+ **/
+func SharedEntries(db *gorm.DB, user User) ([]Vault, error) {
+    var shares []Share
+    if err := db.Where("user_id = ?", user.ID).Order("id").Find(&shares).Error; err != nil {
+        return nil, err
+    }
+
+    vaults := []Vault{}
+    for i := range shares {
+        vault, err := openShare(db, &shares[i], user)
+        if err != nil {
+            return nil, err
+        }
+        if err := decryptMetadata(&vault, user); err != nil {
+            return nil, err
+        }
+        vaults = append(vaults, vault)
+    }
+    SortVaults(vaults)
+
+    return vaults, nil
+}
+
+/**
+ * @brief:  Find an entry shared with a user and decrypt it
+ *
+ * @param:  db - Pointer to database
+ * @param:  id - ID of the entry
+ * @param:  user - User with its vault key unlocked
+ *
+ * @return: Entry with the Share it's opened through on success,
+ *          ErrNotFound if it isn't shared with the user, else error
+ **/
+func SharedByID(db *gorm.DB, id uint, user User) (Vault, error) {
+    var share Share
+    if err := first(db.Where("vault_id = ? AND user_id = ?", id, user.ID), &share); err != nil {
+        return Vault{}, err
+    }
+
+    vault, err := openShare(db, &share, user)
+    if err != nil {
+        return Vault{}, err
+    }
+
+    vaults := []Vault{vault}
+    if err := LoadFields(db, vaults); err != nil {
+        return Vault{}, err
+    }
+    vault = vaults[0]
+
+    if err := DecryptEntry(&vault, user); err != nil {
+        return Vault{}, err
+    }
+
+    return vault, nil
+}
+
+/**
+ * @brief:  Load the entry of a share with its item key, still encrypted
+ *
+ * @param:  db - Pointer to database
+ * @param:  share - Share of the user, its owner's username is set
+ * @param:  user - User with its vault key unlocked
+ *
+ * @return: Entry on success, else error
+ **/
+func openShare(db *gorm.DB, share *Share, user User) (Vault, error) {
+    var vault Vault
+    if err := first(db.Where("id = ?", share.VaultID), &vault); err != nil {
+        return Vault{}, err
+    }
+
+    var owner User
+    if err := first(db.Where("id = ?", vault.UserID), &owner); err != nil {
+        return Vault{}, err
+    }
+    share.Username = owner.Username
+
+    itemKey, err := openSealedKey(user, share.ItemKeyCipher)
+    if err != nil {
+        return Vault{}, err
+    }
+    vault.ItemKey = itemKey
+    vault.Share = share
+
+    return vault, nil
+}
+
+/**
+ * @brief:  Save an edited entry shared read-write with a user. The share is
+ *          checked again, and the edit refused if the item key changed
+ *          since the entry was opened.
+ *
+ * @param:  db - Pointer to database
+ * @param:  vault - Edited entry, opened by SharedByID
+ * @param:  user - User the entry is shared with
+ *
+ * @return: nil on success
+ *          If it's no longer shared with the user, ErrNotFound
+ *          If it's shared read-only, ErrShareReadOnly
+ *          Else, error
+ **/
+func UpdateSharedEntry(db *gorm.DB, vault *Vault, user User) error {
+    if vault.Share == nil {
+        return ErrNotFound
+    } else if vault.Share.Permission != PermissionReadWrite {
+        return ErrShareReadOnly
+    }
+
+    if err := ValidateVaultEntry(vault, user); err != nil {
+        return err
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        var stored Vault
+        if err := first(tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", vault.ID), &stored); err != nil {
+            return err
+        }
+
+        var share Share
+        if err := first(tx.Where("vault_id = ? AND user_id = ?", vault.ID, user.ID), &share); err != nil {
+            return err
+        }
+        if share.Permission != PermissionReadWrite {
+            return ErrShareReadOnly
+        }
+        if !bytes.Equal(stored.ItemKeyCipher, vault.ItemKeyCipher) {
+            return ErrShareChanged
+        }
+
+        /* Shared entries stay with their owner */
+        vault.UserID, vault.FolderCipher = stored.UserID, stored.FolderCipher
+        if err := tx.Save(vault).Error; err != nil {
+            return err
+        }
+
+        return saveFields(tx, vault)
+    })
+}
@@ -0,0 +1,275 @@
+package models
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+/**
+ * Source of logins typed on this machine's command line
+ **/
+const LocalSource = "local"
+
+/**
+ * How many failed logins a subject gets
+ **/
+type throttlePolicy struct {
+    /* Failures before each new one delays the next attempt */
+    free    int
+
+    /* Failures that lock the subject out for lockoutDuration */
+    lockout int
+}
+
+var (
+    /* Guessing one account's credentials */
+    accountPolicy = throttlePolicy{free: 3, lockout: 10}
+
+    /* One address trying many accounts */
+    sourcePolicy = throttlePolicy{free: 10, lockout: 50}
+)
+
+const (
+    /* Delay after the first failure past the free ones, doubled after each */
+    backoffBase     = 2 * time.Second
+
+    lockoutDuration = 15 * time.Minute
+
+    /* Failures older than this are forgotten */
+    failureWindow   = time.Hour
+)
+
+/**
+ * Hash compared against when the username is unknown, so failing takes as
+ * long whether the account exists or not
+ **/
+var dummyHash []byte
+var dummyHashOnce sync.Once
+
+func unknownUserHash() []byte {
+    dummyHashOnce.Do(func() {
+        dummyHash, _ = bcrypt.GenerateFromPassword([]byte("vaultdepot unknown user"), bcryptCost)
+    })
+
+    return dummyHash
+}
+
+func accountSubject(username string) string {
+    return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func sourceSubject(source string) string {
+    return "source:" + source
+}
+
+/**
+ * @brief:  Get the subjects a login is throttled by. Command line logins
+ *          are only throttled per account: every user of the machine shares
+ *          LocalSource, so one of them failing mustn't lock out the others.
+ *
+ * @param:  username - Username tried
+ * @param:  source - Address the login came from, LocalSource on the command line
+ *
+ * @return: Account subject, and the source subject unless it's LocalSource
+ **/
+func throttleSubjects(username string, source string) []string {
+    if source == LocalSource {
+        return []string{accountSubject(username)}
+    }
+
+    return []string{accountSubject(username), sourceSubject(source)}
+}
+
+/**
+ * @brief:  Check none of the subjects is locked out
+ *
+ * @param:  db - Pointer to database
+ * @param:  subjects - Account and source subjects of a login
+ *
+ * @return: nil if the login may be tried, ErrLoginThrottled if a subject is
+ *          waiting out a delay or lockout, else error
+ **/
+func checkThrottle(db *gorm.DB, subjects ...string) error {
+    for _, subject := range subjects {
+        var throttle LoginThrottle
+        err := first(db.Where("subject = ?", subject), &throttle)
+        if err == ErrNotFound {
+            continue
+        } else if err != nil {
+            return err
+        }
+
+        if time.Now().Before(throttle.LockedUntil) {
+            return ErrLoginThrottled
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Count a failed login against a subject. Past the free failures
+ *          every failure delays the next attempt twice as long, at the
+ *          lockout the subject is locked out and the lockout audited.
+ *
+ * @param:  db - Pointer to database
+ * @param:  subject - Account or source subject
+ * @param:  policy - Failures the subject gets
+ * @param:  event - Audited if the subject gets locked out
+ *
+ * @return: nil on success, else error
+ **/
+func countFailure(db *gorm.DB, subject string, policy throttlePolicy, event AuditEvent) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        var throttle LoginThrottle
+        err := first(tx.Set("gorm:query_option", "FOR UPDATE").Where("subject = ?", subject), &throttle)
+        if err == ErrNotFound {
+            throttle = LoginThrottle{Subject: subject}
+        } else if err != nil {
+            return err
+        }
+
+        now := time.Now()
+        if now.Sub(throttle.LastFailureAt) > failureWindow {
+            throttle.Failures = 0
+        }
+        throttle.Failures++
+        throttle.LastFailureAt = now
+
+        if throttle.Failures >= policy.lockout {
+            throttle.LockedUntil = now.Add(lockoutDuration)
+            event.Action = "lockout"
+            event.Detail = fmt.Sprintf("%s locked for %s after %d failed logins", subject, lockoutDuration, throttle.Failures)
+            if err := recordAudit(tx, event); err != nil {
+                return err
+            }
+        } else if throttle.Failures > policy.free {
+            delay := backoffBase << uint(throttle.Failures - policy.free - 1)
+            throttle.LockedUntil = now.Add(delay)
+        }
+
+        return tx.Save(&throttle).Error
+    })
+}
+
+/**
+ * @brief:  Count a failed login against the account and, unless it's
+ *          LocalSource, the source
+ *
+ * @param:  db - Pointer to database
+ * @param:  username - Username tried
+ * @param:  user - Account of the username, nil if it's unknown
+ * @param:  source - Address the login came from
+ *
+ * @return: nil on success, else error
+ **/
+func recordFailure(db *gorm.DB, username string, user *User, source string) error {
+    event := AuditEvent{Username: username, Source: source}
+    if user != nil {
+        event.UserID = user.ID
+    }
+
+    if err := countFailure(db, accountSubject(username), accountPolicy, event); err != nil {
+        return err
+    } else if source == LocalSource {
+        return nil
+    }
+
+    return countFailure(db, sourceSubject(source), sourcePolicy, event)
+}
+
+/**
+ * @brief:  Forget the failed logins of an account after it logged in. The
+ *          source keeps its count, logging into an account of your own
+ *          mustn't let you guess others.
+ *
+ * @param:  db - Pointer to database
+ * @param:  username - Username that logged in
+ *
+ * @return: nil on success, else error
+ **/
+func clearFailures(db *gorm.DB, username string) error {
+    return db.Unscoped().Where("subject = ?", accountSubject(username)).Delete(&LoginThrottle{}).Error
+}
+
+/**
+ * @brief:  Throttle a login. The credentials of locked out accounts and
+ *          sources aren't checked at all, failures are counted and every
+ *          failure is reported as ErrLoginFailed, ErrTwoFactorInvalid once
+ *          the credentials were right, or ErrRecoveryFailed for recoveries.
+ *
+ * @param:  db - Pointer to database
+ * @param:  username - Username tried
+ * @param:  source - Address the login came from, LocalSource on the command line
+ * @param:  verify - Checks the credentials, returns the account (nil if
+ *                   the username is unknown) and ErrLoginFailed if they're
+ *                   wrong, ErrTwoFactorInvalid if the code is or
+ *                   ErrRecoveryFailed if the recovery key is
+ *
+ * @return: User on success, ErrLoginThrottled, ErrLoginFailed,
+ *          ErrTwoFactorInvalid, ErrRecoveryFailed, else error
+ **/
+func guardLogin(db *gorm.DB, username string, source string, verify func() (*User, error)) (*User, error) {
+    if err := checkThrottle(db, throttleSubjects(username, source)...); err != nil {
+        return nil, err
+    }
+
+    user, err := verify()
+    if err == ErrLoginFailed || err == ErrTwoFactorInvalid || err == ErrRecoveryFailed {
+        if err := recordFailure(db, username, user, source); err != nil {
+            return nil, err
+        }
+        return nil, err
+    } else if err != nil {
+        return nil, err
+    }
+
+    if err := clearFailures(db, username); err != nil {
+        return nil, err
+    }
+
+    return user, nil
+}
+
+/**
+ * @brief:  Compare a secret with its peppered bcrypt hash
+ *
+ * @param:  hash - bcrypt hash, the unknown user hash if empty
+ * @param:  secret - Secret to check
+ * @param:  version - Version of the pepper the hash was made with
+ *
+ * @return: nil if it matches, ErrLoginFailed if not, ErrPepperUnknown if
+ *          the pepper isn't configured, else error
+ **/
+func compareSecret(hash string, secret string, version int) error {
+    hashed := []byte(hash)
+    if hash == "" {
+        hashed, version = unknownUserHash(), currentPepper
+    }
+
+    pepper, ok := peppers[version]
+    if !ok {
+        return ErrPepperUnknown
+    }
+
+    err := bcrypt.CompareHashAndPassword(hashed, []byte(secret + pepper))
+    if err == bcrypt.ErrMismatchedHashAndPassword {
+        return ErrLoginFailed
+    } else if err != nil {
+        return err
+    }
+
+    /* The unknown user hash never lets anyone in */
+    if hash == "" {
+        return ErrLoginFailed
+    }
+
+    return nil
+}
@@ -0,0 +1,481 @@
+package models
+
+import (
+    "crypto/subtle"
+    "encoding/base32"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+const (
+    /* Issuer shown by authenticator apps */
+    twoFactorIssuer = "vaultDepot"
+
+    /* Random bytes of a TOTP secret, the size RFC 4226 recommends */
+    twoFactorSecretBytes = 20
+
+    /* Recovery codes handed out at once, and their random bytes */
+    recoveryCodeCount = 10
+    recoveryCodeBytes = 10
+)
+
+/**
+ * Encrypts the TOTP secrets of two-factor login, set by LoadSecrets
+ **/
+var twoFactorAES compat.AES
+
+/**
+ * Hashes recovery codes before they are stored or looked up, set by
+ * LoadSecrets
+ **/
+var recoveryHMAC compat.HMAC
+
+/**
+ * @brief:  Check if logging in needs a two-factor code
+ *
+ * @return: true if two-factor login is on, else false
+ **/
+func (user User) TwoFactorEnabled() bool {
+    return len(user.TOTPCipher) > 0
+}
+
+/**
+ * @brief:  Start enrolling an account in two-factor login. The secret is
+ *          kept aside until ConfirmTwoFactor sees a code of it, so a
+ *          mistyped scan doesn't lock the account.
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Account to enroll
+ *
+ * @return: otpauth URI to scan or type into an authenticator app on
+ *          success, ErrTwoFactorEnabled if it's on already, else error
+ **/
+func BeginTwoFactor(db *gorm.DB, user *User) (string, error) {
+    if user.TwoFactorEnabled() {
+        return "", ErrTwoFactorEnabled
+    }
+
+    secret, err := compat.Bytes(twoFactorSecretBytes)
+    if err != nil {
+        return "", err
+    }
+
+    totp := compat.TOTP{
+        Secret: secret,
+        Algorithm: "SHA1",
+        Digits: 6,
+        Period: 30,
+        Issuer: twoFactorIssuer,
+        Account: user.Username,
+    }
+    uri := totp.URI()
+
+    pending, err := twoFactorAES.Encrypt(uri)
+    if err != nil {
+        return "", err
+    }
+    columns := map[string]interface{}{"totp_pending_cipher": pending, "totp_key_id": totpKeyID}
+    if err := db.Model(user).UpdateColumns(columns).Error; err != nil {
+        return "", err
+    }
+    user.TOTPPendingCipher, user.TOTPKeyID = pending, totpKeyID
+
+    return uri, nil
+}
+
+/**
+ * @brief:  Turn two-factor login on with a code of the secret from
+ *          BeginTwoFactor
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Account being enrolled
+ * @param:  code - Code shown by the authenticator app
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: Recovery codes, shown once, on success
+ *          If there's no enrollment to confirm, ErrTwoFactorDisabled
+ *          If the code is wrong, ErrTwoFactorInvalid
+ *          Else, error
+ **/
+func ConfirmTwoFactor(db *gorm.DB, user *User, code string, source string) ([]string, error) {
+    if user.TwoFactorEnabled() {
+        return nil, ErrTwoFactorEnabled
+    } else if len(user.TOTPPendingCipher) == 0 {
+        return nil, ErrTwoFactorDisabled
+    }
+
+    var codes []string
+    err := db.Transaction(func(tx *gorm.DB) error {
+        if err := matchTOTP(tx, user, user.TOTPPendingCipher, code); err != nil {
+            return err
+        }
+
+        err := tx.Model(user).Updates(map[string]interface{}{
+            "totp_cipher": user.TOTPPendingCipher,
+            "totp_pending_cipher": nil,
+        }).Error
+        if err != nil {
+            return err
+        }
+
+        codes, err = replaceRecoveryCodes(tx, *user)
+        if err != nil {
+            return err
+        }
+
+        return recordAudit(tx, AuditEvent{UserID: user.ID, Username: user.Username, Source: source,
+            Action: "2fa enabled", Detail: "two-factor login turned on"})
+    })
+    if err != nil {
+        return nil, err
+    }
+    user.TOTPCipher, user.TOTPPendingCipher = user.TOTPPendingCipher, nil
+
+    return codes, nil
+}
+
+/**
+ * @brief:  Replace the recovery codes of an account, the old ones stop
+ *          working. Failed codes are throttled like logins.
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Account with two-factor login
+ * @param:  code - Two-factor or recovery code
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: New recovery codes on success
+ *          If two-factor login is off, ErrTwoFactorDisabled
+ *          If the code is wrong, ErrTwoFactorInvalid
+ *          Else, error
+ **/
+func RegenerateRecoveryCodes(db *gorm.DB, user *User, code string, source string) ([]string, error) {
+    if !user.TwoFactorEnabled() {
+        return nil, ErrTwoFactorDisabled
+    }
+
+    _, err := guardLogin(db, user.Username, source, func() (*User, error) {
+        return user, secondFactor(db, user, code, source)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var codes []string
+    err = db.Transaction(func(tx *gorm.DB) error {
+        codes, err = replaceRecoveryCodes(tx, *user)
+        if err != nil {
+            return err
+        }
+
+        return recordAudit(tx, AuditEvent{UserID: user.ID, Username: user.Username, Source: source,
+            Action: "recovery codes", Detail: "recovery codes replaced"})
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return codes, nil
+}
+
+/**
+ * @brief:  Turn two-factor login off. Failed codes are throttled like
+ *          logins.
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Account with two-factor login
+ * @param:  code - Two-factor or recovery code
+ * @param:  source - Address the request came from, LocalSource on the command line
+ *
+ * @return: nil on success
+ *          If two-factor login is off, ErrTwoFactorDisabled
+ *          If the code is wrong, ErrTwoFactorInvalid
+ *          Else, error
+ **/
+func DisableTwoFactor(db *gorm.DB, user *User, code string, source string) error {
+    if !user.TwoFactorEnabled() {
+        return ErrTwoFactorDisabled
+    }
+
+    _, err := guardLogin(db, user.Username, source, func() (*User, error) {
+        return user, secondFactor(db, user, code, source)
+    })
+    if err != nil {
+        return err
+    }
+
+    err = db.Transaction(func(tx *gorm.DB) error {
+        err := tx.Model(user).Updates(map[string]interface{}{
+            "totp_cipher": nil,
+            "totp_pending_cipher": nil,
+        }).Error
+        if err != nil {
+            return err
+        }
+
+        if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+            return err
+        }
+
+        return recordAudit(tx, AuditEvent{UserID: user.ID, Username: user.Username, Source: source,
+            Action: "2fa disabled", Detail: "two-factor login turned off"})
+    })
+    if err != nil {
+        return err
+    }
+    user.TOTPCipher, user.TOTPPendingCipher = nil, nil
+
+    return nil
+}
+
+/**
+ * @brief:  Check the second factor of a login whose credentials are right.
+ *          Takes a code of the authenticator app, or an unused recovery
+ *          code which is then used up.
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Account logging in
+ * @param:  code - Code typed by the user, empty if none was asked for yet
+ * @param:  source - Address the login came from
+ *
+ * @return: nil if two-factor login is off or the code is right
+ *          If no code was given, ErrTwoFactorRequired
+ *          If the code is wrong or was used, ErrTwoFactorInvalid
+ *          Else, error
+ **/
+func secondFactor(db *gorm.DB, user *User, code string, source string) error {
+    if !user.TwoFactorEnabled() {
+        return nil
+    }
+
+    code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+    if code == "" {
+        return ErrTwoFactorRequired
+    }
+
+    err := matchTOTP(db, user, user.TOTPCipher, code)
+    if err != ErrTwoFactorInvalid {
+        return err
+    }
+
+    return useRecoveryCode(db, user, code, source)
+}
+
+/**
+ * @brief:  Check a code of a TOTP secret, allowing a time step of clock
+ *          drift either way. A matching code is claimed, so it can't be
+ *          used again.
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Account of the secret, its counter is updated
+ * @param:  cipher - Encrypted otpauth URI of the secret
+ * @param:  code - Code to check
+ *
+ * @return: nil on success, ErrTwoFactorInvalid if the code is wrong or was
+ *          used, else error
+ **/
+func matchTOTP(db *gorm.DB, user *User, cipher []byte, code string) error {
+    uri, err := twoFactorAES.Decrypt(cipher)
+    if err != nil {
+        return err
+    }
+    totp, err := compat.ParseTOTP(uri)
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+    period := time.Duration(totp.Period) * time.Second
+    for _, at := range []time.Time{now.Add(-period), now, now.Add(period)} {
+        counter := at.Unix() / int64(totp.Period)
+        if counter <= user.TOTPCounter {
+            continue
+        }
+        if subtle.ConstantTimeCompare([]byte(totp.Code(at)), []byte(code)) != 1 {
+            continue
+        }
+
+        /* Another login may have claimed the code meanwhile */
+        result := db.Model(&User{}).Where("id = ? AND totp_counter < ?", user.ID, counter).
+            UpdateColumn("totp_counter", counter)
+        if result.Error != nil {
+            return result.Error
+        }
+        if result.RowsAffected == 0 {
+            return ErrTwoFactorInvalid
+        }
+        user.TOTPCounter = counter
+
+        return nil
+    }
+
+    return ErrTwoFactorInvalid
+}
+
+/**
+ * @brief:  Hash a recovery code of an account, dashes, spaces and case
+ *          don't matter
+ *
+ * @param:  userID - ID of the account
+ * @param:  code - Recovery code
+ *
+ * @return: HMAC of the code
+ **/
+func recoveryHash(userID uint, code string) string {
+    return recoveryHMAC.Hash(recoveryInput(userID, code))
+}
+
+/**
+ * @brief:  Get what a recovery code of an account is hashed from
+ *
+ * @param:  userID - ID of the account
+ * @param:  code - Recovery code
+ *
+ * @return: Account ID and code without dashes or spaces, upper cased
+ **/
+func recoveryInput(userID uint, code string) string {
+    code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+    return fmt.Sprintf("%d:%s", userID, code)
+}
+
+/**
+ * @brief:  Use up an unused recovery code
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Account logging in
+ * @param:  code - Recovery code
+ * @param:  source - Address the login came from
+ *
+ * @return: nil on success, ErrTwoFactorInvalid if the code isn't an unused
+ *          one of the account, else error
+ **/
+func useRecoveryCode(db *gorm.DB, user *User, code string, source string) error {
+    /* Codes handed out before the HMAC key was configurable were hashed with the built-in one */
+    hashes := []string{recoveryHash(user.ID, code), legacyRecoveryHMAC.Hash(recoveryInput(user.ID, code))}
+    result := db.Model(&RecoveryCode{}).
+        Where("user_id = ? AND code_hash IN (?) AND used_at IS NULL", user.ID, hashes).
+        UpdateColumn("used_at", time.Now())
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrTwoFactorInvalid
+    }
+
+    var left int
+    if err := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&left).Error; err != nil {
+        return err
+    }
+
+    return recordAudit(db, AuditEvent{UserID: user.ID, Username: user.Username, Source: source,
+        Action: "recovery code", Detail: fmt.Sprintf("recovery code used, %d left", left)})
+}
+
+/**
+ * @brief:  Generate new recovery codes for an account, dropping the old ones
+ *
+ * @param:  db - Pointer to database, or a transaction
+ * @param:  user - Account to generate the codes for
+ *
+ * @return: Codes, e.g. "abcd-efgh-ijkl-mnop", on success, else error
+ **/
+func replaceRecoveryCodes(db *gorm.DB, user User) ([]string, error) {
+    if err := db.Unscoped().Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+        return nil, err
+    }
+
+    codes := make([]string, recoveryCodeCount)
+    for i := range codes {
+        random, err := compat.Bytes(recoveryCodeBytes)
+        if err != nil {
+            return nil, err
+        }
+
+        code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random))
+        codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+
+        recovery := RecoveryCode{UserID: user.ID, CodeHash: recoveryHash(user.ID, codes[i])}
+        if err := db.Create(&recovery).Error; err != nil {
+            return nil, err
+        }
+    }
+
+    return codes, nil
+}
+
+/**
+ * @brief:  Decrypt the TOTP secret of an account, so a backup holds it
+ *          without the TOTP key of the server
+ *
+ * @param:  user - Account to back up
+ *
+ * @return: otpauth URI on success (empty without two-factor login), else error
+ **/
+func TwoFactorSecret(user User) (string, error) {
+    if !user.TwoFactorEnabled() {
+        return "", nil
+    }
+
+    return openTwoFactor(user.TOTPCipher)
+}
+
+/**
+ * @brief:  Encrypt the TOTP secret of a restored account with the TOTP key
+ *          of the server. Backups made before the secret was backed up hold
+ *          it encrypted, which only opens on the server it was made on. An
+ *          enrollment that wasn't confirmed is dropped.
+ *
+ * @param:  user - Restored account
+ * @param:  secret - otpauth URI from the backup, empty in older backups
+ *
+ * @return: nil on success, ErrTwoFactorRestore if the secret of an older
+ *          backup doesn't open on this server, else error
+ **/
+func RestoreTwoFactor(user *User, secret string) error {
+    user.TOTPPendingCipher = nil
+    if secret == "" && user.TwoFactorEnabled() {
+        var err error
+        if secret, err = openTwoFactor(user.TOTPCipher); err != nil {
+            return ErrTwoFactorRestore
+        }
+    }
+    if secret == "" {
+        user.TOTPCipher = nil
+        return nil
+    }
+
+    cipher, err := twoFactorAES.Encrypt(secret)
+    if err != nil {
+        return err
+    }
+    user.TOTPCipher, user.TOTPKeyID = cipher, totpKeyID
+
+    return nil
+}
+
+/**
+ * @brief:  Decrypt a TOTP secret, with the previous or built-in key if
+ *          MigrateTwoFactor hasn't encrypted it again yet
+ *
+ * @param:  cipher - Encrypted otpauth URI
+ *
+ * @return: otpauth URI on success, else error of the configured key
+ **/
+func openTwoFactor(cipher []byte) (string, error) {
+    uri, err := twoFactorAES.Decrypt(cipher)
+    if err == nil {
+        return uri, nil
+    }
+
+    for _, aes := range previousTwoFactorAES {
+        if uri, previousErr := aes.Decrypt(cipher); previousErr == nil {
+            return uri, nil
+        }
+    }
+
+    return "", err
+}
@@ -2,31 +2,236 @@ package models
 
 import (
     "fmt"
+    "time"
 
     "github.com/jinzhu/gorm"
 )
 
 const (
+    /* Pepper version 1, configure newer ones with LoadHashing */
     userPwPepper = "secret-random-string"
+
+    /* Built-in keys of older versions, refused by LoadSecrets and only used
+     * to read what was stored with them */
+    hmacSecretKey = "secret-hmac-key"
+    totpSecretKey = "secret-totp-key"
 )
 
 type User struct {
     gorm.Model
     Username    string `gorm:"not null;unique_index"`
-    Password    string `gorm:"-"`
+    Password    string `gorm:"-" json:"-"`
     PasswordHash string `gorm:"not null"`
-    SecretKey   string `gorm:"-"`
+    SecretKey   string `gorm:"-" json:"-"`
     SecretKeyHash string `gorm:"not null"`
+
+    /* Version of the pepper both hashes were made with (see LoadHashing) */
+    PepperVersion int `gorm:"not null;default:1"`
+
+    /* Random key the vault is encrypted with, only in memory once unlocked
+     * (see unlockVault) */
+    VaultKey    string `gorm:"-" json:"-"`
+
+    /* VaultKey sealed with the secret key, empty for accounts made before
+     * vault keys until their next login */
+    VaultKeyCipher []byte
+
+    /* VaultKey sealed with the recovery key, empty without one (see
+     * CreateRecoveryKey) */
+    RecoveryKeyCipher []byte
+
+    /* Account of a remote client (see CreateRemoteUser), Password holds the
+     * auth hash it derived and there is no secret key */
+    Remote      bool `gorm:"not null;default:false"`
+
+    /* X25519 key pair entries are shared to (see ShareEntry), the private
+     * half encrypted with the vault key. Empty for remote accounts, and for
+     * older accounts until their next login. */
+    PublicKey   []byte
+    PrivateKeyCipher []byte
+
+    /* Code typed for two-factor login, see ConfirmTwoFactor */
+    OTP         string `gorm:"-"`
+
+    /* TOTP secret of two-factor login, encrypted with the TOTP key of the
+     * server (see LoadSecrets) since remote accounts have no secret key on
+     * the server */
+    TOTPCipher  []byte
+
+    /* Secret shown by BeginTwoFactor until a code of it is confirmed */
+    TOTPPendingCipher []byte
+
+    /* Fingerprint of the TOTP key both secrets are encrypted with, empty
+     * for secrets of older versions (see MigrateTwoFactor) */
+    TOTPKeyID   string `gorm:"not null;default:''"`
+
+    /* Time step of the last code used, a code only logs in once */
+    TOTPCounter int64 `gorm:"not null;default:0"`
+}
+
+/**
+ * Logged in session of a user, only the HMAC of the remember token is stored
+ **/
+type Session struct {
+    gorm.Model
+    UserID      uint `gorm:"not null;index"`
+    Remember    string `gorm:"-"`
+    RememberHash string `gorm:"not null;unique_index"`
+
+    /* Where the session was started, e.g. the host name */
+    Label       string
+    ExpiresAt   time.Time `gorm:"not null"`
+    LastUsedAt  time.Time
+    RevokedAt   *time.Time
+
+    /* Vault key of the user sealed with the remember token, so the web
+     * interface and API can decrypt while the session is active */
+    VaultKeyCipher []byte
+}
+
+/**
+ * Single-use recovery code of two-factor login, only its HMAC is stored
+ **/
+type RecoveryCode struct {
+    gorm.Model
+    UserID      uint `gorm:"not null;index"`
+    CodeHash    string `gorm:"not null;unique_index"`
+    UsedAt      *time.Time
+}
+
+/**
+ * Failed logins of an account or a source address (see recordFailure)
+ **/
+type LoginThrottle struct {
+    gorm.Model
+
+    /* "user:<username>" or "source:<address>" */
+    Subject     string `gorm:"not null;unique_index"`
+    Failures    int `gorm:"not null"`
+    LastFailureAt time.Time
+    LockedUntil time.Time
+}
+
+/**
+ * Security event kept for the audit trail, e.g. a lockout
+ **/
+type AuditEvent struct {
+    gorm.Model
+
+    /* Account the event is about, 0 if none (e.g. an unknown username) */
+    UserID      uint `gorm:"index"`
+    Username    string
+    Source      string
+    Action      string `gorm:"not null;index"`
+    Detail      string
+}
+
+/**
+ * Entry of a remote account, sealed by the client. The server can't read
+ * anything about it but its size and when it changed.
+ **/
+type RemoteEntry struct {
+    gorm.Model
+    UserID      uint `gorm:"not null;index"`
+    Cipher      []byte `gorm:"not null"`
 }
 
 type Vault struct {
     gorm.Model
     UserID      uint `gorm:"not_null;index"`
-    Email       string `gorm:"not null"`
-    Username    string
-    Application string `gorm:"not null"`
-    Password    string `gorm:"-"`
+    Type        string `gorm:"not null;default:'login'"`
+    Email       string `gorm:"-"`
+    EmailCipher []byte
+    EmailIndex  string `gorm:"index"`
+    Username    string `gorm:"-"`
+    UsernameCipher []byte
+    UsernameIndex string `gorm:"index"`
+    Application string `gorm:"-"`
+    ApplicationCipher []byte
+    ApplicationIndex string `gorm:"index"`
+    Folder      string `gorm:"-"`
+    FolderCipher []byte
+    Tags        []string `gorm:"-"`
+    TagsCipher  []byte
+    URIs        []URI `gorm:"-"`
+    URIsCipher  []byte
+    Password    string `gorm:"-" json:"-"`
     PasswordCipher []byte `gorm:"not null"`
+    Notes       string `gorm:"-"`
+    NotesCipher []byte
+    TOTP        string `gorm:"-"`
+    TOTPCipher  []byte
+    Payload     map[string]string `gorm:"-"`
+    PayloadCipher []byte
+    Fields      []Field `gorm:"-"`
+
+    /* Key of an entry that was shared, only in memory. Entries never shared
+     * are encrypted with the owner's vault key (see entryAES). */
+    ItemKey     string `gorm:"-" json:"-"`
+
+    /* ItemKey encrypted with the owner's vault key */
+    ItemKeyCipher []byte
+
+    /* Share the entry was opened through, nil for the owner */
+    Share       *Share `gorm:"-" json:"-"`
+
+    /* Collection of an organization's entry, which has no UserID and is
+     * encrypted with the organization key. 0 for entries of a user. */
+    CollectionID uint `gorm:"not null;default:0;index"`
+}
+
+/**
+ * Entry shared by its owner with another user (see ShareEntry)
+ **/
+type Share struct {
+    gorm.Model
+    VaultID     uint `gorm:"not null;unique_index:idx_share_vault_user"`
+    UserID      uint `gorm:"not null;unique_index:idx_share_vault_user;index"`
+
+    /* Item key of the entry sealed to the user's public key */
+    ItemKeyCipher []byte `gorm:"not null"`
+
+    /* PermissionReadOnly or PermissionReadWrite */
+    Permission  string `gorm:"not null;default:'read-only'"`
+
+    /* Owner, or user it's shared with, depending on who lists it */
+    Username    string `gorm:"-"`
+}
+
+/**
+ * Custom field of a vault entry, its name and value are encrypted with the
+ * key of the entry (see encryptFields)
+ **/
+type Field struct {
+    gorm.Model
+    VaultID     uint `gorm:"not null;index"`
+    Name        string `gorm:"-"`
+    NameCipher  []byte
+    Hidden      bool
+    Value       string `gorm:"-"`
+    ValueCipher []byte
+}
+
+/**
+ * File attached to a vault entry, stored encrypted in AttachmentChunks
+ **/
+type Attachment struct {
+    gorm.Model
+    VaultID     uint `gorm:"not null;index"`
+    Name        string `gorm:"-"`
+    NameCipher  []byte `gorm:"not null"`
+    Size        int64 `gorm:"not null"`
+    Chunks      int `gorm:"not null"`
+
+    /* Random reference the chunks are bound to, survives backup and restore */
+    Ref         []byte `gorm:"not null"`
+}
+
+type AttachmentChunk struct {
+    ID          uint `gorm:"primary_key"`
+    AttachmentID uint `gorm:"not null;index"`
+    Seq         int `gorm:"not null"`
+    DataCipher  []byte `gorm:"not null"`
 }
 
 func (user User) String() string {
@@ -34,7 +239,110 @@ func (user User) String() string {
         user.Username, user.PasswordHash, user.SecretKeyHash)
 }
 
+func (session Session) String() string {
+    return fmt.Sprintf("Session(ID=%d, Label='%s', LastUsed='%s', Expires='%s')",
+        session.ID, session.Label, session.LastUsedAt.Format(time.RFC1123),
+        session.ExpiresAt.Format(time.RFC1123))
+}
+
+/**
+ * Organization sharing collections of entries among its members
+ **/
+type Organization struct {
+    gorm.Model
+    Name        string `gorm:"not null;unique_index"`
+
+    /* Bumped each time the organization key is replaced (see rekeyOrganization) */
+    KeyVersion  int `gorm:"not null;default:1"`
+
+    /* Organization key and the role of the member that opened it, only in
+     * memory (see OpenOrganization) */
+    Key         string `gorm:"-" json:"-"`
+    Role        string `gorm:"-" json:"-"`
+}
+
+/**
+ * Member of an organization
+ **/
+type Membership struct {
+    gorm.Model
+    OrganizationID uint `gorm:"not null;unique_index:idx_membership_org_user"`
+    UserID      uint `gorm:"not null;unique_index:idx_membership_org_user;index"`
+
+    /* RoleOwner, RoleAdmin, RoleMember or RoleReadOnly */
+    Role        string `gorm:"not null"`
+
+    /* Organization key sealed to the member's public key */
+    KeyCipher   []byte `gorm:"not null"`
+
+    /* Username of the member, only in memory */
+    Username    string `gorm:"-"`
+}
+
+/**
+ * Named group of an organization's entries
+ **/
+type Collection struct {
+    gorm.Model
+    OrganizationID uint `gorm:"not null;unique_index:idx_collection_org_name"`
+    Name        string `gorm:"not null;unique_index:idx_collection_org_name"`
+}
+
+/**
+ * Trusted user who can ask for access to another user's vault, granted
+ * unless the owner rejects it within the waiting period (see
+ * RequestEmergencyAccess)
+ **/
+type EmergencyAccess struct {
+    gorm.Model
+    GrantorID   uint `gorm:"not null;unique_index:idx_emergency_grantor_grantee"`
+    GranteeID   uint `gorm:"not null;unique_index:idx_emergency_grantor_grantee;index"`
+
+    /* EmergencyRead or EmergencyTakeover */
+    Access      string `gorm:"not null"`
+    WaitDays    int `gorm:"not null"`
+
+    /* EmergencyIdle, EmergencyRequested or EmergencyGranted */
+    Status      string `gorm:"not null;default:'idle'"`
+    RequestedAt *time.Time
+
+    /* Vault key of the grantor sealed to the grantee's public key, only
+     * opened once access is granted */
+    VaultKeyCipher []byte `gorm:"not null"`
+
+    /* Username of the other user, only in memory */
+    Username    string `gorm:"-"`
+}
+
+func (share Share) String() string {
+    return fmt.Sprintf("%s (%s)", share.Username, share.Permission)
+}
+
+func (access EmergencyAccess) String() string {
+    state := access.Status
+    if access.Status == EmergencyRequested && access.Granted(time.Now()) {
+        state = "granted, the wait is over"
+    } else if access.Status == EmergencyRequested {
+        state = fmt.Sprintf("requested, granted on %s unless rejected", access.GrantsAt().Format("2006-01-02 15:04"))
+    }
+
+    return fmt.Sprintf("%s (%s access after %d days): %s", access.Username, access.Access, access.WaitDays, state)
+}
+
+func (org Organization) String() string {
+    return fmt.Sprintf("%s (%s)", org.Name, org.Role)
+}
+
+func (member Membership) String() string {
+    return fmt.Sprintf("%s (%s)", member.Username, member.Role)
+}
+
 func (vault Vault) String() string {
+    if vault.ItemType() != TypeLogin {
+        return fmt.Sprintf("Vault(Type='%s', Name='%s')",
+            vault.ItemType(), vault.Application)
+    }
+
     return fmt.Sprintf("Vault(Email='%s', Username='%s', Application='%s')",
         vault.Email, vault.Username, vault.Application)
 }
@@ -0,0 +1,349 @@
+package models
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/url"
+    "regexp"
+    "strings"
+
+    "golang.org/x/net/publicsuffix"
+)
+
+/**
+ * How the URI of an entry is compared with the URL of a site
+ **/
+const (
+    /* Same registrable domain, e.g. login.example.co.uk and example.co.uk */
+    MatchDomain     = "domain"
+
+    /* Same host and port */
+    MatchHost       = "host"
+
+    /* The URL starts with the URI */
+    MatchStartsWith = "startswith"
+
+    /* The whole URL matches the URI as a regular expression, case insensitive */
+    MatchRegex      = "regex"
+
+    /* The URL is the URI */
+    MatchExact      = "exact"
+)
+
+var matchModes = []string{MatchDomain, MatchHost, MatchStartsWith, MatchRegex, MatchExact}
+
+/**
+ * Site an entry belongs to
+ **/
+type URI struct {
+    URI   string `json:"uri"`
+    Match string `json:"match,omitempty"`
+}
+
+/**
+ * @brief:  Parse a URI as entered, `<uri> [match]`. The match mode is
+ *          optional and defaults to MatchDomain.
+ *
+ * @param:  line - URI and match mode
+ *
+ * @return: URI
+ **/
+func ParseURI(line string) URI {
+    line = strings.TrimSpace(line)
+
+    if i := strings.LastIndexAny(line, " \t"); i > 0 {
+        match := strings.ToLower(line[i + 1:])
+        for _, mode := range matchModes {
+            if match == mode {
+                return URI{URI: strings.TrimSpace(line[:i]), Match: mode}
+            }
+        }
+    }
+
+    return URI{URI: line, Match: MatchDomain}
+}
+
+/**
+ * @brief:  Parse a list of URIs, one `<uri> [match]` per line. Lines without
+ *          a regular expression may hold several URIs separated by commas, as
+ *          other password managers export them.
+ *
+ * @param:  value - List of URIs
+ *
+ * @return: URIs, nil if none
+ **/
+func ParseURIs(value string) []URI {
+    var uris []URI
+    for _, line := range strings.Split(value, "\n") {
+        uri := ParseURI(line)
+        if uri.Match == MatchRegex {
+            uris = append(uris, uri)
+            continue
+        }
+
+        for _, part := range strings.Split(uri.URI, ",") {
+            if part = strings.TrimSpace(part); part != "" {
+                uris = append(uris, URI{URI: part, Match: uri.Match})
+            }
+        }
+    }
+
+    return uris
+}
+
+/**
+ * @brief:  Format URIs like ParseURIs reads them, the default match mode is
+ *          left out
+ *
+ * @param:  uris - URIs of an entry
+ *
+ * @return: One `<uri> [match]` per line
+ **/
+func FormatURIs(uris []URI) string {
+    lines := make([]string, len(uris))
+    for i, uri := range uris {
+        lines[i] = uri.URI
+        if uri.Match != MatchDomain {
+            lines[i] += " " + uri.Match
+        }
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+/**
+ * @brief:  Parse a URL, sites are usually given without a scheme
+ *
+ * @param:  raw - URL
+ *
+ * @return: URL with a host on success, else ErrURIInvalid
+ **/
+func parseSiteURL(raw string) (*url.URL, error) {
+    raw = strings.TrimSpace(raw)
+    if !strings.Contains(raw, "://") {
+        raw = "https://" + raw
+    }
+
+    site, err := url.Parse(raw)
+    if err != nil || site.Hostname() == "" {
+        return nil, ErrURIInvalid
+    }
+
+    return site, nil
+}
+
+/**
+ * @brief:  Get the registrable domain of a host, the public suffix plus one
+ *          label. IP addresses and hosts like localhost are their own domain.
+ *
+ * @param:  host - Host name without port
+ *
+ * @return: Domain, lower cased
+ **/
+func baseDomain(host string) string {
+    host = strings.ToLower(strings.TrimSuffix(host, "."))
+    if net.ParseIP(host) != nil {
+        return host
+    }
+
+    domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+    if err != nil {
+        return host
+    }
+
+    return domain
+}
+
+/**
+ * @brief:  Check if a site's URL matches the URI
+ *
+ * @param:  site - Parsed URL of the site
+ * @param:  raw - URL of the site as given
+ *
+ * @return: true if it matches, else false
+ **/
+func (uri URI) Matches(site *url.URL, raw string) bool {
+    switch uri.Match {
+    case MatchHost:
+        own, err := parseSiteURL(uri.URI)
+        return err == nil && strings.EqualFold(own.Host, site.Host)
+
+    case MatchStartsWith:
+        return strings.HasPrefix(strings.ToLower(raw), strings.ToLower(uri.URI))
+
+    /* Anchored, so a pattern can't match inside another site's URL */
+    case MatchRegex:
+        pattern, err := regexp.Compile("(?i)^(?:" + uri.URI + ")$")
+        return err == nil && pattern.MatchString(raw)
+
+    case MatchExact:
+        return strings.EqualFold(raw, uri.URI)
+    }
+
+    own, err := parseSiteURL(uri.URI)
+    return err == nil && baseDomain(own.Hostname()) == baseDomain(site.Hostname())
+}
+
+/**
+ * @brief:  Find the entries a site's credentials may be in. Entries without
+ *          URIs match when their application is the site's whole registrable
+ *          domain: a bare name like github would match github.xyz just as
+ *          well as github.com.
+ *
+ * @param:  vaults - Vaults with decrypted metadata
+ * @param:  raw - URL of the site
+ *
+ * @return: Matching vaults on success, ErrNotFound if none match, else
+ *          ErrURIInvalid
+ **/
+func MatchURL(vaults []Vault, raw string) ([]Vault, error) {
+    raw = strings.TrimSpace(raw)
+    site, err := parseSiteURL(raw)
+    if err != nil {
+        return nil, err
+    }
+
+    domain := baseDomain(site.Hostname())
+
+    var matched []Vault
+    for _, vault := range vaults {
+        if len(vault.URIs) == 0 {
+            if strings.ToLower(vault.Application) == domain {
+                matched = append(matched, vault)
+            }
+            continue
+        }
+
+        for _, uri := range vault.URIs {
+            if uri.Matches(site, raw) {
+                matched = append(matched, vault)
+                break
+            }
+        }
+    }
+
+    if len(matched) == 0 {
+        return nil, ErrNotFound
+    }
+
+    return matched, nil
+}
+
+/**
+ * @brief:  Trim the URIs of a vault and check they can be matched against
+ *
+ * @param:  vault - Contains URIs
+ *
+ * @return: nil on success, ErrMatchInvalid if the match mode isn't known,
+ *          else ErrURIInvalid
+ **/
+func normalizeURIs(vault *Vault, user User) error {
+    var uris []URI
+    for _, uri := range vault.URIs {
+        uri.URI = strings.TrimSpace(uri.URI)
+        uri.Match = strings.ToLower(strings.TrimSpace(uri.Match))
+        if uri.URI == "" || uri.URI == clearValue {
+            continue
+        }
+        if uri.Match == "" {
+            uri.Match = MatchDomain
+        }
+
+        switch uri.Match {
+        case MatchDomain, MatchHost:
+            if _, err := parseSiteURL(uri.URI); err != nil {
+                return err
+            }
+        case MatchRegex:
+            if _, err := regexp.Compile(uri.URI); err != nil {
+                return ErrURIInvalid
+            }
+        case MatchStartsWith, MatchExact:
+        default:
+            return ErrMatchInvalid
+        }
+
+        uris = append(uris, uri)
+    }
+    vault.URIs = uris
+
+    return nil
+}
+
+/**
+ * @brief:  Encrypt the URIs with the key of the entry
+ *
+ * @param:  vault - Contains URIs
+ * @param:  user - User to cipher URIs
+ *
+ * @return: nil on success, else error
+ **/
+func encryptURIs(vault *Vault, user User) error {
+    if len(vault.URIs) == 0 {
+        vault.URIsCipher = nil
+        return nil
+    }
+
+    uris, err := json.Marshal(vault.URIs)
+    if err != nil {
+        return err
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    urisCipher, err := aes.Encrypt(string(uris))
+    if err != nil {
+        return err
+    }
+    vault.URIsCipher = urisCipher
+
+    return nil
+}
+
+/**
+ * @brief:  Decrypt the URIs of a vault
+ *
+ * @param:  vault - Vault to decrypt
+ * @param:  user - User to decipher URIs
+ *
+ * @return: nil on success, else error
+ **/
+func decryptURIs(vault *Vault, user User) error {
+    vault.URIs = nil
+    if len(vault.URIsCipher) == 0 {
+        return nil
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    uris, err := aes.Decrypt(vault.URIsCipher)
+    if err != nil {
+        return err
+    }
+
+    return json.Unmarshal([]byte(uris), &vault.URIs)
+}
+
+/**
+ * @brief:  Ask for the URIs of an item, one `<uri> [match]` per line
+ *
+ * @param:  reader - Reads the user input
+ * @param:  vault - Item to set the URIs of
+ **/
+func askURIs(reader *bufio.Reader, vault *Vault) {
+    prompt := fmt.Sprintf("Enter URLs, one per line with an optional match (%s)",
+        strings.Join(matchModes, ", "))
+
+    lines := askLines(reader, prompt)
+    if strings.TrimSpace(lines) == clearValue {
+        vault.URIs = []URI{{URI: clearValue}}
+        return
+    }
+    vault.URIs = ParseURIs(lines)
+}
@@ -9,8 +9,6 @@ import (
     "github.com/loerac/vaultDepot/compat"
     "github.com/jinzhu/gorm"
     _ "github.com/lib/pq"
-
-    "golang.org/x/crypto/bcrypt"
 )
 
 /**
@@ -76,7 +74,14 @@ func Login(userdb *gorm.DB) (User, error) {
         SecretKey: secret_key,
     }
 
-    user, err := Authenticate(userdb, login_user)
+    user, err := Authenticate(userdb, login_user, LocalSource)
+    if err == ErrTwoFactorRequired {
+        login_user.OTP, err = UserInput("Enter two-factor code or recovery code")
+        if err != nil {
+            return User{}, err
+        }
+        user, err = Authenticate(userdb, login_user, LocalSource)
+    }
     compat.CheckError(err)
 
 	return *user, nil
@@ -105,38 +110,112 @@ func ByUsername(userdb *gorm.DB, username string) (*User, error) {
 
 /**
  * @brief:  Authenticate a user with provided
- *          username and password
+ *          username and password, and the two-factor
+ *          code if the account has two-factor login
  *
  * @param:  userdb - db pointer to database
  * @param:  auth_user - User to authenticate
+ * @param:  source - Address the login came from, LocalSource on the command line
  *
- * @return: If username is invalid, return ErrNotFound
- *          If password is invalid, return ErrPasswordIncorrect
- *          If both are vaild, return user
+ * @return: If the account or source failed too often, return ErrLoginThrottled
+ *          If the username, password or secret key is invalid, or the
+ *          account is remote, return ErrLoginFailed
+ *          If the two-factor code is missing, return ErrTwoFactorRequired
+ *          If the two-factor code is wrong, return ErrTwoFactorInvalid
+ *          If all are vaild, return user with its vault key, rehashed
+ *          if its hashes were outdated (see rehashIfOutdated)
  *          Else, error
  **/
-func Authenticate(userdb *gorm.DB, auth_user User) (*User, error) {
-    foundUser, err := ByUsername(userdb, auth_user.Username)
+func Authenticate(userdb *gorm.DB, auth_user User, source string) (*User, error) {
+    user, err := guardLogin(userdb, auth_user.Username, source, func() (*User, error) {
+        foundUser, err := ByUsername(userdb, auth_user.Username)
+        if err == ErrNotFound {
+            foundUser, err = nil, nil
+        } else if err != nil {
+            return nil, err
+        }
+
+        /* Check both whatever happens, failing takes as long either way */
+        var password_hash, secret_key_hash string
+        version := currentPepper
+        if foundUser != nil {
+            password_hash, secret_key_hash = foundUser.PasswordHash, foundUser.SecretKeyHash
+            version = foundUser.PepperVersion
+        }
+        password_err := compareSecret(password_hash, auth_user.Password, version)
+        secret_key_err := compareSecret(secret_key_hash, auth_user.SecretKey, version)
+        if password_err != nil && password_err != ErrLoginFailed {
+            return nil, password_err
+        } else if secret_key_err != nil && secret_key_err != ErrLoginFailed {
+            return nil, secret_key_err
+        } else if password_err != nil || secret_key_err != nil || foundUser.Remote {
+            return foundUser, ErrLoginFailed
+        }
+
+        return foundUser, secondFactor(userdb, foundUser, auth_user.OTP, source)
+    })
     if err != nil {
         return nil, err
     }
 
-    password_err := bcrypt.CompareHashAndPassword(
-        []byte(foundUser.PasswordHash),
-        []byte(auth_user.Password + userPwPepper),
-    )
-    secret_key_err := bcrypt.CompareHashAndPassword(
-        []byte(foundUser.SecretKeyHash),
-        []byte(auth_user.SecretKey + userPwPepper),
-    )
-    if password_err == bcrypt.ErrMismatchedHashAndPassword {
-        return nil, ErrPasswordIncorrect
-    } else if secret_key_err == bcrypt.ErrMismatchedHashAndPassword {
-        return nil, ErrSecretKeyIncorrect
-    } else if password_err == nil &&
-              secret_key_err == nil {
-        return foundUser, nil
+    /* Only now are the credentials in hand to hash them anew */
+    if err := rehashIfOutdated(userdb, user, auth_user.Password, auth_user.SecretKey); err != nil {
+        return nil, err
+    }
+
+    if err := unlockVault(userdb, user, auth_user.SecretKey); err != nil {
+        return nil, err
+    }
+
+    return user, nil
+}
+
+/**
+ * @brief:  Log user back in with the remember token of a session, only the
+ *          secret key is asked for
+ *
+ * @param:  userdb - Pointer to database
+ * @param:  token - Remember token of the session
+ *
+ * @return: On success, user with its vault key and session
+ *          If the session isn't active, ErrSessionInvalid
+ *          If the secret key is invalid, ErrLoginFailed
+ *          If the account failed too often, ErrLoginThrottled
+ *          Else, an error
+ **/
+func ResumeSession(userdb *gorm.DB, token string) (User, Session, error) {
+    session, user, err := findSession(userdb, token)
+    if err != nil {
+        return User{}, Session{}, err
+    }
+
+    fmt.Printf("Welcome back %s\n", user.Username)
+    secret_key, err := UserInput("Enter secret key")
+    if err != nil {
+        return User{}, Session{}, err
+    }
+
+    _, err = guardLogin(userdb, user.Username, LocalSource, func() (*User, error) {
+        return user, compareSecret(user.SecretKeyHash, secret_key, user.PepperVersion)
+    })
+    if err != nil {
+        return User{}, Session{}, err
+    }
+
+    if err := unlockVault(userdb, user, secret_key); err != nil {
+        return User{}, Session{}, err
+    }
+
+    /* Sessions from before vault keys get theirs sealed now */
+    if len(session.VaultKeyCipher) == 0 {
+        session.VaultKeyCipher, err = compat.NewAES(token).Encrypt(user.VaultKey)
+        if err != nil {
+            return User{}, Session{}, err
+        }
+        if err := userdb.Model(session).UpdateColumn("vault_key_cipher", session.VaultKeyCipher).Error; err != nil {
+            return User{}, Session{}, err
+        }
     }
 
-    return nil, err
+    return *user, *session, nil
 }
@@ -1,12 +1,11 @@
 package models
 
 import (
+    "encoding/json"
     "strings"
 
     "github.com/loerac/vaultDepot/compat"
     "github.com/jinzhu/gorm"
-
-    "golang.org/x/crypto/bcrypt"
 )
 
 type userValFn func(*User) error
@@ -65,6 +64,8 @@ func CreateUser(db *gorm.DB, user *User) error {
         secretKeyRequired,
         passwordMinLength,
         secretKeyMinLength,
+        wrapVaultKey,
+        generateKeyPair,
         bcryptPassword,
         bcryptSecretKey,
         passwordHashRequired,
@@ -78,28 +79,104 @@ func CreateUser(db *gorm.DB, user *User) error {
 }
 
 /**
- * @brief:  Create provide user
+ * @brief:  Validate, normalize and encrypt a vault without saving it
  *
- * @param:  vault - Vault to add to the database
+ * @param:  vault - Vault to validate
  * @param:  user - User to cipher password
  *
  * @return: nil on success, else error
  **/
-func CreateVaultEntry(db *gorm.DB, vault *Vault, user User) error {
-    err := runVaultValFns(vault, user,
+func ValidateVaultEntry(vault *Vault, user User) error {
+    if err := runVaultValFns(vault, user, normalizeEmail, requireEmail); err != nil {
+        return err
+    }
+
+    return ValidateImportedEntry(vault, user)
+}
+
+/**
+ * @brief:  Validate, normalize and encrypt an imported vault without saving
+ *          it. Other password managers don't ask for an email, so logins
+ *          may come without one.
+ *
+ * @param:  vault - Vault to validate
+ * @param:  user - User to cipher password
+ *
+ * @return: nil on success, else error
+ **/
+func ValidateImportedEntry(vault *Vault, user User) error {
+    return runVaultValFns(vault, user,
         userIDRequired,
+        normalizeType,
         vaultPasswordRequired,
         encryptPassword,
+        noteRequired,
+        encryptNotes,
+        normalizeTOTP,
+        encryptTOTP,
+        typeFieldsRequired,
+        encryptPayload,
+        fieldNamesRequired,
+        encryptFields,
+        applicationRequired,
+        normalizeApplication,
+        normalizeEmail,
+        normalizeFolder,
+        encryptFolder,
+        normalizeTags,
+        encryptTags,
+        normalizeURIs,
+        encryptURIs,
+        encryptMetadata,
+    )
+}
+
+/**
+ * @brief:  Validate and normalize a vault without encrypting it, for entries
+ *          a remote client seals itself
+ *
+ * @param:  vault - Vault to validate
+ *
+ * @return: nil on success, else error
+ **/
+func NormalizeVaultEntry(vault *Vault) error {
+    return runVaultValFns(vault, User{},
+        normalizeType,
+        vaultPasswordRequired,
+        noteRequired,
+        normalizeTOTP,
+        typeFieldsRequired,
+        fieldNamesRequired,
         applicationRequired,
         normalizeApplication,
         normalizeEmail,
         requireEmail,
+        normalizeFolder,
+        normalizeTags,
+        normalizeURIs,
     )
-    if err != nil {
+}
+
+/**
+ * @brief:  Create provide user
+ *
+ * @param:  vault - Vault to add to the database
+ * @param:  user - User to cipher password
+ *
+ * @return: nil on success, else error
+ **/
+func CreateVaultEntry(db *gorm.DB, vault *Vault, user User) error {
+    if err := ValidateVaultEntry(vault, user); err != nil {
         return err
     }
 
-    return db.Create(&vault).Error
+    return db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&vault).Error; err != nil {
+            return err
+        }
+
+        return saveFields(tx, vault)
+    })
 }
 
 /**
@@ -111,31 +188,29 @@ func CreateVaultEntry(db *gorm.DB, vault *Vault, user User) error {
  * @return: nil on success, else error
  **/
 func UpdateVaultEntry(db *gorm.DB, vault *Vault, user User) error {
-    err := runVaultValFns(vault, user,
-        userIDRequired,
-        vaultPasswordRequired,
-        encryptPassword,
-        applicationRequired,
-        normalizeApplication,
-        normalizeEmail,
-        requireEmail,
-    )
-    if err != nil {
+    if err := ValidateVaultEntry(vault, user); err != nil {
         return err
     }
 
-    return db.Save(&vault).Error
+    return db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Save(&vault).Error; err != nil {
+            return err
+        }
+
+        return saveFields(tx, vault)
+    })
 }
 
 /**
- * @brief:  Check if user ID is greater than 0
+ * @brief:  Check if user ID is greater than 0, unless the entry belongs to
+ *          a collection of an organization
  *
- * @param:  user - Contains ID
+ * @param:  vault - Contains user ID and collection ID
  *
- * @return: nil on success, else ErrIDInvalid
+ * @return: nil on success, else ErrUserIDRequried
  **/
 func userIDRequired(vault *Vault, user User) error {
-    if vault.UserID <= 0 {
+    if vault.UserID <= 0 && vault.CollectionID == 0 {
         return ErrUserIDRequried
     }
 
@@ -158,13 +233,17 @@ func userPasswordRequired(user *User) error {
 }
 
 /**
- * @brief:  Checks to see if password is provided
+ * @brief:  Checks to see if password is provided for a login
  *
  * @param:  vault - Contains password
  *
  * @return: nil on success, else ErrPasswordRequired
  **/
 func vaultPasswordRequired(vault *Vault, user User) error {
+    if vault.ItemType() != TypeLogin {
+        return nil
+    }
+
     if vault.Password == "" {
         return ErrPasswordRequired
     }
@@ -180,11 +259,15 @@ func vaultPasswordRequired(vault *Vault, user User) error {
  * @return: nil on success, else error
  **/
 func encryptPassword(vault *Vault, user User) error {
-    if vault.Password == "" {
+    /* Items other than logins have no password, but the column is required */
+    if vault.Password == "" && len(vault.PasswordCipher) > 0 {
         return nil
     }
 
-    aes := compat.NewAES(user.SecretKey)
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
     passwordCipher, err := aes.Encrypt(vault.Password)
     if err != nil {
         return err
@@ -251,7 +334,7 @@ func secretKeyMinLength(user *User) error {
 }
 
 /**
- * @brief:  Hash a user's password, with salt and pepper
+ * @brief:  Hash a user's password, with salt and the current pepper
  *
  * @param:  user - Contains users password
  *
@@ -263,23 +346,21 @@ func bcryptPassword(user *User) error {
     }
 
     /* Season textbased password with salt and pepper to get hash */
-    pwBytes := []byte(user.Password + userPwPepper)
-    hashedBytes, err := bcrypt.GenerateFromPassword(
-        pwBytes, bcrypt.DefaultCost,
-    )
+    hashed, err := hashSecret(user.Password)
     if err != nil {
         return err
     }
 
     /* Store hashed password and forget textbase password */
-    user.PasswordHash = string(hashedBytes)
+    user.PasswordHash = hashed
+    user.PepperVersion = currentPepper
     user.Password = ""
 
     return nil
 }
 
 /**
- * @brief:  Hash a user's secret key, with salt and pepper
+ * @brief:  Hash a user's secret key, with salt and the current pepper
  *
  * @param:  user - Contains users secret key
  *
@@ -291,16 +372,14 @@ func bcryptSecretKey(user *User) error {
     }
 
     /* Season textbased secret key with salt and pepper to get hash */
-    skBytes := []byte(user.SecretKey + userPwPepper)
-    hashedBytes, err := bcrypt.GenerateFromPassword(
-        skBytes, bcrypt.DefaultCost,
-    )
+    hashed, err := hashSecret(user.SecretKey)
     if err != nil {
         return err
     }
 
     /* Store hashed password and forget textbase password */
-    user.SecretKeyHash = string(hashedBytes)
+    user.SecretKeyHash = hashed
+    user.PepperVersion = currentPepper
     user.SecretKey = ""
 
     return nil
@@ -337,13 +416,195 @@ func secretKeyHashRequired(user *User) error {
 }
 
 /**
- * @brief:  Check to see if email is present
+ * @brief:  Encrypt the notes of a vault with the key of the entry
+ *
+ * @param:  vault - Contains notes
+ * @param:  user - User to cipher notes
+ *
+ * @return: nil on success, else error
+ **/
+func encryptNotes(vault *Vault, user User) error {
+    if vault.Notes == "" {
+        vault.NotesCipher = nil
+        return nil
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    notesCipher, err := aes.Encrypt(vault.Notes)
+    if err != nil {
+        return err
+    }
+
+    /* Store ciphered notes and forget textbase notes */
+    vault.NotesCipher = notesCipher
+    vault.Notes = ""
+
+    return nil
+}
+
+/**
+ * @brief:  Check the TOTP secret is valid and store it as an otpauth URI,
+ *          '-' removes it
+ *
+ * @param:  vault - Contains TOTP secret
+ *
+ * @return: nil on success, else ErrTOTPInvalid
+ **/
+func normalizeTOTP(vault *Vault, user User) error {
+    if vault.TOTP == "" || vault.TOTP == clearValue {
+        vault.TOTP = ""
+        return nil
+    }
+
+    totp, err := compat.ParseTOTP(vault.TOTP)
+    if err != nil {
+        return ErrTOTPInvalid
+    }
+    vault.TOTP = totp.URI()
+
+    return nil
+}
+
+/**
+ * @brief:  Encrypt the TOTP secret with the key of the entry
+ *
+ * @param:  vault - Contains TOTP secret
+ * @param:  user - User to cipher TOTP secret
+ *
+ * @return: nil on success, else error
+ **/
+func encryptTOTP(vault *Vault, user User) error {
+    if vault.TOTP == "" {
+        vault.TOTPCipher = nil
+        return nil
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    totpCipher, err := aes.Encrypt(vault.TOTP)
+    if err != nil {
+        return err
+    }
+
+    /* Store ciphered secret and forget textbase secret */
+    vault.TOTPCipher = totpCipher
+    vault.TOTP = ""
+
+    return nil
+}
+
+/**
+ * @brief:  Default the type to a login, lower case it and check it's known
+ *
+ * @param:  vault - Contains type
+ *
+ * @return: nil on success, else ErrTypeInvalid
+ **/
+func normalizeType(vault *Vault, user User) error {
+    vault.Type = strings.ToLower(strings.TrimSpace(vault.Type))
+    if vault.Type == "" {
+        vault.Type = TypeLogin
+    }
+
+    if _, ok := itemSchemas[vault.Type]; !ok {
+        return ErrTypeInvalid
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Check a secure note has a note
+ *
+ * @param:  vault - Contains notes
+ *
+ * @return: nil on success, else ErrNoteRequired
+ **/
+func noteRequired(vault *Vault, user User) error {
+    if vault.Type == TypeNote && vault.Notes == "" {
+        return ErrNoteRequired
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Check the required fields of the item's type are present and drop
+ *          fields the type doesn't have
+ *
+ * @param:  vault - Contains payload
+ *
+ * @return: nil on success, else ErrFieldRequired
+ **/
+func typeFieldsRequired(vault *Vault, user User) error {
+    payload := map[string]string{}
+    for _, field := range itemSchemas[vault.Type].Fields {
+        value := strings.TrimSpace(vault.Payload[field.Name])
+        if value == "" {
+            if field.Required {
+                return ErrFieldRequired
+            }
+            continue
+        }
+        payload[field.Name] = value
+    }
+    vault.Payload = payload
+
+    return nil
+}
+
+/**
+ * @brief:  Encrypt the payload of a typed item with the key of the entry
+ *
+ * @param:  vault - Contains payload
+ * @param:  user - User to cipher payload
+ *
+ * @return: nil on success, else error
+ **/
+func encryptPayload(vault *Vault, user User) error {
+    if len(vault.Payload) == 0 {
+        vault.PayloadCipher = nil
+        return nil
+    }
+
+    payload, err := json.Marshal(vault.Payload)
+    if err != nil {
+        return err
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    payloadCipher, err := aes.Encrypt(string(payload))
+    if err != nil {
+        return err
+    }
+
+    /* Store ciphered payload and forget textbase payload */
+    vault.PayloadCipher = payloadCipher
+    vault.Payload = nil
+
+    return nil
+}
+
+/**
+ * @brief:  Check to see if email is present for a login
  *
  * @param:  vault - Contains email
  *
  * @return: nil on success, else ErrEmailRequired
  **/
 func requireEmail(vault *Vault, user User) error {
+    if vault.ItemType() != TypeLogin {
+        return nil
+    }
+
     if vault.Email == "" {
         return ErrEmailRequired
     }
@@ -0,0 +1,307 @@
+package models
+
+import (
+    "encoding/hex"
+    "time"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+/**
+ * Random bytes of a vault key, hex encoded in User.VaultKey
+ **/
+const vaultKeyBytes = 32
+
+/**
+ * Key vaults were encrypted with before they had a vault key. The secret
+ * key was forgotten once hashed, so everything was encrypted with an empty
+ * one (see migrateVaultKey).
+ **/
+const legacyVaultKey = ""
+
+/**
+ * @brief:  Give a new user a random vault key, sealed with the secret key.
+ *          Runs before the secret key is hashed and forgotten.
+ *
+ * @param:  user - Contains secret key
+ *
+ * @return: nil on success, else error
+ **/
+func wrapVaultKey(user *User) error {
+    if user.SecretKey == "" {
+        return nil
+    }
+
+    if user.VaultKey == "" {
+        key, err := compat.Bytes(vaultKeyBytes)
+        if err != nil {
+            return err
+        }
+        user.VaultKey = hex.EncodeToString(key)
+    }
+
+    sealed, err := compat.SealArchive(user.SecretKey, []byte(user.VaultKey))
+    if err != nil {
+        return err
+    }
+    user.VaultKeyCipher = sealed
+
+    return nil
+}
+
+/**
+ * @brief:  Unseal the vault key of a user that just proved its secret key.
+ *          Accounts from before vault keys are given one and their vault
+ *          encrypted with it first, accounts from before key pairs are
+ *          given a key pair.
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Authenticated user, its vault key is set
+ * @param:  secretKey - Secret key of the user
+ *
+ * @return: nil on success, else error
+ **/
+func unlockVault(db *gorm.DB, user *User, secretKey string) error {
+    if user.Remote {
+        return nil
+    }
+
+    if len(user.VaultKeyCipher) == 0 {
+        if err := migrateVaultKey(db, user, secretKey); err != nil {
+            return err
+        }
+    } else {
+        key, err := compat.OpenArchive(secretKey, user.VaultKeyCipher)
+        if err != nil {
+            return err
+        }
+        user.VaultKey = string(key)
+    }
+
+    return ensureKeyPair(db, user)
+}
+
+/**
+ * @brief:  Give an account from before vault keys a vault key, and encrypt
+ *          its vault with it instead of legacyVaultKey. Its sessions have
+ *          no sealed vault key, they are revoked and have to log in again.
+ *
+ * @param:  db - Pointer to database
+ * @param:  user - Authenticated user, its vault key is set
+ * @param:  secretKey - Secret key of the user
+ *
+ * @return: nil on success, else error
+ **/
+func migrateVaultKey(db *gorm.DB, user *User, secretKey string) error {
+    migrated := User{SecretKey: secretKey}
+
+    err := db.Transaction(func(tx *gorm.DB) error {
+        /* Two logins at once mustn't both encrypt the vault */
+        var locked User
+        if err := first(tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", user.ID), &locked); err != nil {
+            return err
+        }
+        if len(locked.VaultKeyCipher) > 0 {
+            key, err := compat.OpenArchive(secretKey, locked.VaultKeyCipher)
+            if err != nil {
+                return err
+            }
+            migrated.VaultKey, migrated.VaultKeyCipher = string(key), locked.VaultKeyCipher
+            return nil
+        }
+
+        if err := wrapVaultKey(&migrated); err != nil {
+            return err
+        }
+        if err := reencryptAccount(tx, user.ID, compat.NewAES(legacyVaultKey), compat.NewAES(migrated.VaultKey)); err != nil {
+            return err
+        }
+
+        if err := tx.Model(user).UpdateColumn("vault_key_cipher", migrated.VaultKeyCipher).Error; err != nil {
+            return err
+        }
+
+        return tx.Model(&Session{}).Where("user_id = ? AND vault_key_cipher IS NULL AND revoked_at IS NULL", user.ID).
+            UpdateColumn("revoked_at", time.Now()).Error
+    })
+    if err != nil {
+        return err
+    }
+    user.VaultKey, user.VaultKeyCipher = migrated.VaultKey, migrated.VaultKeyCipher
+
+    return nil
+}
+
+/**
+ * @brief:  Decrypt a cipher with one key and encrypt it with another,
+ *          empty ciphers are left as they are
+ *
+ * @param:  from - Key the cipher was encrypted with
+ * @param:  to - Key to encrypt it with
+ * @param:  cipher - Cipher, replaced
+ *
+ * @return: nil on success, else error
+ **/
+func reencrypt(from compat.AES, to compat.AES, cipher *[]byte) error {
+    if len(*cipher) == 0 {
+        return nil
+    }
+
+    value, err := from.Decrypt(*cipher)
+    if err != nil {
+        return err
+    }
+
+    *cipher, err = to.Encrypt(value)
+    return err
+}
+
+/**
+ * @brief:  Encrypt everything in a user's vault with another key: entries,
+ *          their blind indexes, hidden fields and attachments
+ *
+ * @param:  tx - Transaction
+ * @param:  userID - ID of the user
+ * @param:  from - Key the vault is encrypted with
+ * @param:  to - Key to encrypt it with
+ *
+ * @return: nil on success, else error
+ **/
+func reencryptAccount(tx *gorm.DB, userID uint, from compat.AES, to compat.AES) error {
+    var vaults []Vault
+    if err := tx.Where("user_id = ?", userID).Find(&vaults).Error; err != nil {
+        return err
+    }
+
+    return reencryptVaults(tx, vaults, from, to)
+}
+
+/**
+ * @brief:  Encrypt entries with another key: their ciphers, blind indexes,
+ *          hidden fields and attachments
+ *
+ * @param:  tx - Transaction
+ * @param:  vaults - Entries as stored, still encrypted
+ * @param:  from - Key the entries are encrypted with
+ * @param:  to - Key to encrypt them with
+ *
+ * @return: nil on success, else error
+ **/
+func reencryptVaults(tx *gorm.DB, vaults []Vault, from compat.AES, to compat.AES) error {
+    if len(vaults) == 0 {
+        return nil
+    }
+
+    ids := make([]uint, len(vaults))
+    for i := range vaults {
+        vault := &vaults[i]
+        ids[i] = vault.ID
+
+        ciphers := []*[]byte{
+            &vault.PasswordCipher, &vault.NotesCipher, &vault.TOTPCipher,
+            &vault.PayloadCipher, &vault.FolderCipher, &vault.TagsCipher, &vault.URIsCipher,
+        }
+        for _, cipher := range ciphers {
+            if err := reencrypt(from, to, cipher); err != nil {
+                return err
+            }
+        }
+
+        /* Blind indexes are keyed too, see encryptMetadata */
+        indexed := []struct {
+            cipher *[]byte
+            index  *string
+        }{
+            {&vault.EmailCipher, &vault.EmailIndex},
+            {&vault.UsernameCipher, &vault.UsernameIndex},
+            {&vault.ApplicationCipher, &vault.ApplicationIndex},
+        }
+        for _, column := range indexed {
+            if len(*column.cipher) == 0 {
+                continue
+            }
+
+            value, err := from.Decrypt(*column.cipher)
+            if err != nil {
+                return err
+            }
+            if *column.cipher, err = to.Encrypt(value); err != nil {
+                return err
+            }
+            *column.index = to.BlindIndex(value)
+        }
+
+        /* Only the non-empty ciphers are updated, the times are kept */
+        err := tx.Model(&Vault{}).Where("id = ?", vault.ID).UpdateColumns(Vault{
+            PasswordCipher: vault.PasswordCipher,
+            NotesCipher: vault.NotesCipher,
+            TOTPCipher: vault.TOTPCipher,
+            PayloadCipher: vault.PayloadCipher,
+            FolderCipher: vault.FolderCipher,
+            TagsCipher: vault.TagsCipher,
+            URIsCipher: vault.URIsCipher,
+            EmailCipher: vault.EmailCipher,
+            EmailIndex: vault.EmailIndex,
+            UsernameCipher: vault.UsernameCipher,
+            UsernameIndex: vault.UsernameIndex,
+            ApplicationCipher: vault.ApplicationCipher,
+            ApplicationIndex: vault.ApplicationIndex,
+        }).Error
+        if err != nil {
+            return err
+        }
+    }
+
+    var fields []Field
+    if err := tx.Where("vault_id IN (?)", ids).Find(&fields).Error; err != nil {
+        return err
+    }
+    for _, field := range fields {
+        if err := reencrypt(from, to, &field.NameCipher); err != nil {
+            return err
+        }
+        if err := reencrypt(from, to, &field.ValueCipher); err != nil {
+            return err
+        }
+
+        err := tx.Model(&field).UpdateColumns(Field{NameCipher: field.NameCipher, ValueCipher: field.ValueCipher}).Error
+        if err != nil {
+            return err
+        }
+    }
+
+    var attachments []Attachment
+    if err := tx.Where("vault_id IN (?)", ids).Find(&attachments).Error; err != nil {
+        return err
+    }
+    for _, attachment := range attachments {
+        if err := reencrypt(from, to, &attachment.NameCipher); err != nil {
+            return err
+        }
+        if err := tx.Model(&attachment).UpdateColumn("name_cipher", attachment.NameCipher).Error; err != nil {
+            return err
+        }
+
+        var chunks []AttachmentChunk
+        if err := tx.Where("attachment_id = ?", attachment.ID).Find(&chunks).Error; err != nil {
+            return err
+        }
+        for _, chunk := range chunks {
+            ad := chunkAD(attachment, chunk.Seq)
+            data, err := from.Open(chunk.DataCipher, ad)
+            if err != nil {
+                return ErrAttachmentDamaged
+            }
+            if chunk.DataCipher, err = to.Seal(data, ad); err != nil {
+                return err
+            }
+            if err := tx.Model(&chunk).UpdateColumn("data_cipher", chunk.DataCipher).Error; err != nil {
+                return err
+            }
+        }
+    }
+
+    return nil
+}
@@ -2,23 +2,37 @@ package models
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
-	"os"
 	"strings"
+	"time"
 
     "github.com/loerac/vaultDepot/compat"
     "github.com/jinzhu/gorm"
     _ "github.com/lib/pq"
 )
 
+/**
+ * Number of entries inserted per statement by CreateVaultEntries
+ **/
+const vaultBatchSize = 100
+
 /**
  * @brief:  Ask for info to add to the vault
  *
  * @param:  id - User's ID to map item to user
  **/
 func EntryInfo(id uint) Vault {
-	reader := bufio.NewReader(os.Stdin)
+    return entryInfoOfType(id, askItemType())
+}
 
+/**
+ * @brief:  Ask for the info of a login
+ *
+ * @param:  reader - Reads the user input
+ * @param:  id - User's ID to map item to user
+ **/
+func loginInfo(reader *bufio.Reader, id uint) Vault {
 	fmt.Print("Enter email: ")
 	email, _ := reader.ReadString('\n')
     email = strings.TrimSpace(email)
@@ -33,23 +47,46 @@ func EntryInfo(id uint) Vault {
 
     password := HiddenInput("password")
 
-    return Vault {
+    totp, _ := UserInput("Enter TOTP secret or otpauth:// URI (optional, '-' for none)")
+
+    vault := Vault {
         UserID: id,
+        Type: TypeLogin,
         Email: email,
         Username: username,
         Application: app,
         Password: password,
+        TOTP: totp,
     }
+    askURIs(reader, &vault)
+    askFolderAndTags(reader, &vault)
+
+    return vault
 }
 
 /**
- * @brief:  Display all the items on the vault; only the application and email
+ * @brief:  Display all the items on the vault; only the application and email,
+ *          or the type and name of other items, with their folder and tags
  *
  * @param:  vaults - Array of item in the vault
  **/
 func DisplayVault(vaults []Vault) {
     for i, vault := range vaults {
-        fmt.Printf("%d.)\n\tApplication: %s\n\tEmail: %s\n", i + 1, vault.Application, vault.Email)
+        if vault.ItemType() == TypeLogin {
+            fmt.Printf("%d.)\n\tApplication: %s\n\tEmail: %s\n", i + 1, vault.Application, vault.Email)
+        } else {
+            fmt.Printf("%d.)\n\t%s: %s\n", i + 1, itemSchemas[vault.ItemType()].Label, vault.Application)
+        }
+
+        if vault.Folder != "" {
+            fmt.Printf("\tFolder: %s\n", vault.Folder)
+        }
+        if len(vault.Tags) > 0 {
+            fmt.Printf("\tTags: %s\n", strings.Join(vault.Tags, ", "))
+        }
+        if vault.Share != nil {
+            fmt.Printf("\tShared by: %s\n", *vault.Share)
+        }
     }
 }
 
@@ -87,6 +124,132 @@ func VaultEntry(db *gorm.DB, vault Vault, user User) (Vault, error) {
 	return vault, nil
 }
 
+/**
+ * @brief:  Insert validated vaults (see ValidateVaultEntry) in batches of
+ *          vaultBatchSize rows per statement, then their fields. IDs are
+ *          taken from the sequence before each insert: the rows a multi-row
+ *          INSERT returns aren't in any guaranteed order, and the fields
+ *          must go to the right entry.
+ *
+ * @param:  db - pointer to dabase, usually a transaction
+ * @param:  vaults - Validated vaults to insert
+ *
+ * @return: nil on success, else error
+ **/
+func CreateVaultEntries(db *gorm.DB, vaults []Vault) error {
+    now := time.Now()
+    for start := 0; start < len(vaults); start += vaultBatchSize {
+        end := start + vaultBatchSize
+        if end > len(vaults) {
+            end = len(vaults)
+        }
+
+        scope := db.NewScope(&Vault{})
+        ids, err := nextIDs(db, scope.TableName(), end - start)
+        if err != nil {
+            return err
+        }
+
+        var columns, rows []string
+        var values []interface{}
+        for i := start; i < end; i++ {
+            vaults[i].ID = ids[i - start]
+            /* Restored entries keep their timestamps */
+            if vaults[i].CreatedAt.IsZero() {
+                vaults[i].CreatedAt = now
+                vaults[i].UpdatedAt = now
+            }
+
+            var marks []string
+            for _, field := range db.NewScope(&vaults[i]).Fields() {
+                if field.IsIgnored || !field.IsNormal {
+                    continue
+                }
+
+                if i == start {
+                    columns = append(columns, scope.Quote(field.DBName))
+                }
+                marks = append(marks, "?")
+                values = append(values, field.Field.Interface())
+            }
+            rows = append(rows, "(" + strings.Join(marks, ", ") + ")")
+        }
+
+        sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+            scope.QuotedTableName(), strings.Join(columns, ", "), strings.Join(rows, ", "))
+        result := db.Exec(sql, values...)
+        if result.Error != nil {
+            return result.Error
+        } else if result.RowsAffected != int64(end - start) {
+            return ErrBatchMismatch
+        }
+
+        for i := start; i < end; i++ {
+            if err := saveFields(db, &vaults[i]); err != nil {
+                return err
+            }
+        }
+    }
+
+    return nil
+}
+
+/**
+ * @brief:  Take IDs for new rows from the sequence of a table
+ *
+ * @param:  db - pointer to dabase
+ * @param:  table - Table the rows go in
+ * @param:  count - Number of rows
+ *
+ * @return: IDs on success, ErrBatchMismatch if fewer came back, else error
+ **/
+func nextIDs(db *gorm.DB, table string, count int) ([]uint, error) {
+    rows, err := db.Raw("SELECT nextval(pg_get_serial_sequence(?, 'id')) FROM generate_series(1, ?)", table, count).Rows()
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []uint
+    for rows.Next() {
+        var id uint
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    if len(ids) != count {
+        return nil, ErrBatchMismatch
+    }
+
+    return ids, nil
+}
+
+/**
+ * @brief:  Save validated vaults (see ValidateVaultEntry) and their fields
+ *
+ * @param:  db - pointer to dabase, usually a transaction
+ * @param:  vaults - Validated vaults to save
+ *
+ * @return: nil on success, else error
+ **/
+func SaveVaultEntries(db *gorm.DB, vaults []Vault) error {
+    for i := range vaults {
+        if err := db.Save(&vaults[i]).Error; err != nil {
+            return err
+        }
+        if err := saveFields(db, &vaults[i]); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
 /**
  * @brief:  Update an item from the vault
  *
@@ -96,9 +259,7 @@ func VaultEntry(db *gorm.DB, vault Vault, user User) (Vault, error) {
  * @return: Updated vault on success, else error
  **/
 func UpdateEntry(db *gorm.DB, vault Vault, user User) (Vault, error) {
-    updated_vault := EntryInfo(vault.UserID)
-    updated_vault.ID = vault.ID
-
+    updated_vault := EditedEntry(vault)
     if err := UpdateVaultEntry(db, &updated_vault, user); err != nil{
         return Vault{}, err
     }
@@ -107,9 +268,54 @@ func UpdateEntry(db *gorm.DB, vault Vault, user User) (Vault, error) {
 }
 
 /**
- * @brief:  Find first vaults with provided ID.
+ * @brief:  Ask for the new info of an item, without saving it
+ *
+ * @param:  vault - Decrypted vault to edit
+ *
+ * @return: Edited vault
+ **/
+func EditedEntry(vault Vault) Vault {
+    updated_vault := entryInfoOfType(vault.UserID, vault.ItemType())
+    updated_vault.Model = vault.Model
+    updated_vault.Fields = vault.Fields
+    updated_vault.ItemKey, updated_vault.ItemKeyCipher = vault.ItemKey, vault.ItemKeyCipher
+    updated_vault.Share = vault.Share
+    updated_vault.CollectionID = vault.CollectionID
+
+    /* Left blank keeps the URIs, folder, tags and TOTP secret, '-' removes
+     * them. The folder is checked before NormalizeFolder drops the '-'. */
+    if len(updated_vault.URIs) == 0 {
+        updated_vault.URIs = vault.URIs
+    } else if updated_vault.URIs[0].URI == clearValue {
+        updated_vault.URIs = nil
+    }
+    if updated_vault.Folder == "" {
+        updated_vault.Folder = vault.Folder
+    } else if strings.TrimSpace(updated_vault.Folder) == clearValue {
+        updated_vault.Folder = ""
+    }
+    if len(updated_vault.Tags) == 0 {
+        updated_vault.Tags = vault.Tags
+    } else if updated_vault.Tags[0] == clearValue {
+        updated_vault.Tags = nil
+    }
+    if updated_vault.TOTP == "" {
+        updated_vault.TOTP = vault.TOTP
+    } else if updated_vault.TOTP == clearValue {
+        updated_vault.TOTP = ""
+    }
+    if updated_vault.ItemType() != TypeNote {
+        updated_vault.Notes = vault.Notes
+    }
+
+    return updated_vault
+}
+
+/**
+ * @brief:  Find first vaults with provided ID, owned by the user.
  *
  * @param:  id  - ID of the vault
+ * @param:  user - Owner of the vault, deciphers it
  *
  * @return: If vault is found, return vault
  *          If vault not found, return ErrNotFound
@@ -117,25 +323,102 @@ func UpdateEntry(db *gorm.DB, vault Vault, user User) (Vault, error) {
  **/
 func ByID(vaultdb *gorm.DB, id uint, user User) (Vault, error) {
     var vault Vault
-    db := vaultdb.Where("id = ?", id)
+    db := vaultdb.Where("id = ? AND user_id = ?", id, user.ID)
     err := first(db, &vault)
     if err != nil {
         return Vault{}, err
     }
 
-    aes := compat.NewAES(user.SecretKey)
-    password, err := aes.Decrypt(vault.PasswordCipher)
-    if err != nil {
+    vaults := []Vault{vault}
+    if err := LoadFields(vaultdb, vaults); err != nil {
         return Vault{}, err
     }
+    vault = vaults[0]
 
-    vault.Password = password
+    if err := DecryptEntry(&vault, user); err != nil {
+        return Vault{}, err
+    }
 
     return vault, nil
 }
 
 /**
- * @brief:  Find all vaults with provided ID.
+ * @brief:  Generate the current one-time code of a decrypted vault
+ *
+ * @param:  vault - Decrypted vault with a TOTP secret
+ *
+ * @return: Code and the seconds it stays valid on success, else error
+ **/
+func CurrentCode(vault Vault) (string, int, error) {
+    if vault.TOTP == "" {
+        return "", 0, ErrTOTPRequired
+    }
+
+    totp, err := compat.ParseTOTP(vault.TOTP)
+    if err != nil {
+        return "", 0, err
+    }
+
+    now := time.Now()
+    return totp.Code(now), totp.Remaining(now), nil
+}
+
+/**
+ * @brief:  Decrypt the ciphered fields of a vault
+ *
+ * @param:  vault - Vault to decrypt
+ * @param:  user - User to decipher the vault
+ *
+ * @return: nil on success, else error
+ **/
+func DecryptEntry(vault *Vault, user User) error {
+    if err := decryptMetadata(vault, user); err != nil {
+        return err
+    }
+
+    aes, err := entryAES(*vault, user)
+    if err != nil {
+        return err
+    }
+    password, err := aes.Decrypt(vault.PasswordCipher)
+    if err != nil {
+        return err
+    }
+    vault.Password = password
+
+    if len(vault.NotesCipher) > 0 {
+        notes, err := aes.Decrypt(vault.NotesCipher)
+        if err != nil {
+            return err
+        }
+        vault.Notes = notes
+    }
+
+    if len(vault.TOTPCipher) > 0 {
+        totp, err := aes.Decrypt(vault.TOTPCipher)
+        if err != nil {
+            return err
+        }
+        vault.TOTP = totp
+    }
+
+    if len(vault.PayloadCipher) > 0 {
+        payload, err := aes.Decrypt(vault.PayloadCipher)
+        if err != nil {
+            return err
+        }
+        if err := json.Unmarshal([]byte(payload), &vault.Payload); err != nil {
+            return err
+        }
+    }
+
+    return decryptFields(vault, user)
+}
+
+/**
+ * @brief:  Find all vaults with provided ID, with their (encrypted) fields.
+ *          Their folders are encrypted too, sort them with SortVaults once
+ *          decrypted.
  *
  * @param:  vaultdb - pointer to database
  * @param:  id  - ID of the user
@@ -146,17 +429,22 @@ func ByID(vaultdb *gorm.DB, id uint, user User) (Vault, error) {
  **/
 func FindAll(vaultdb *gorm.DB, id uint) ([]Vault, error) {
     var vault []Vault
-    db := vaultdb.Where("user_id = ?", id)
+    db := vaultdb.Where("user_id = ?", id).Order("id")
     err := find(db, &vault)
     if err != nil {
         return nil, err
     }
 
+    if err := LoadFields(vaultdb, vault); err != nil {
+        return nil, err
+    }
+
     return vault, nil
 }
 
 /**
- * @brief:  Removes item from vault
+ * @brief:  Removes item from vault, with its fields, shares and
+ *          attachments, in one transaction
  *
  * @param:  db - pointer to dabase
  * @param:  id - ID of item in vault
@@ -170,5 +458,24 @@ func DeleteID(vaultdb *gorm.DB, id uint) error {
         },
     }
 
-    return vaultdb.Delete(&vault).Error
+    return vaultdb.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("vault_id = ?", id).Delete(&Field{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Unscoped().Where("vault_id = ?", id).Delete(&Share{}).Error; err != nil {
+            return err
+        }
+
+        var attachments []Attachment
+        if err := tx.Where("vault_id = ?", id).Find(&attachments).Error; err != nil {
+            return err
+        }
+        for _, attachment := range attachments {
+            if err := DeleteAttachment(tx, attachment.ID); err != nil {
+                return err
+            }
+        }
+
+        return tx.Delete(&vault).Error
+    })
 }
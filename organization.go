@@ -0,0 +1,424 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+
+    "github.com/atotto/clipboard"
+    "github.com/jinzhu/gorm"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Roles in the order they're offered
+ **/
+var roles []string = []string{models.RoleOwner, models.RoleAdmin, models.RoleMember, models.RoleReadOnly}
+
+/**
+ * @brief:  Tell the user why a change was refused, when it's something
+ *          they can act on
+ *
+ * @arg:    err - Error of the change
+ *
+ * @return: true if the error was shown, false if it's unexpected
+ **/
+func refusal(err error) bool {
+    public, ok := err.(interface{ Public() string })
+    if !ok {
+        return false
+    }
+
+    fmt.Printf("%s\n\n", public.Public())
+    return true
+}
+
+/**
+ * @brief:  Ask for a name, spaces allowed
+ *
+ * @arg:    prompt - What the name is of
+ *
+ * @return: Name
+ **/
+func readName(prompt string) string {
+    reader := bufio.NewReader(os.Stdin)
+    fmt.Printf("Enter %s: ", prompt)
+    name, _ := reader.ReadString('\n')
+
+    return strings.TrimSpace(name)
+}
+
+/**
+ * @brief:  Let the user pick a role
+ *
+ * @return: Role
+ **/
+func chooseRole() string {
+    fmt.Println("Role:")
+    return roles[DisplayOptions(roles) - 1]
+}
+
+/**
+ * @brief:  List the organizations of the user and let them open one or
+ *          create one
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - User with its vault key unlocked
+ **/
+func manageOrganizations(db *gorm.DB, user models.User) {
+    for {
+        orgs, err := models.Organizations(db, user)
+        checkError(err)
+
+        if len(orgs) == 0 {
+            fmt.Println("You're not in any organization")
+        }
+        for i, org := range orgs {
+            fmt.Printf("%d.) %s\n", i + 1, org)
+        }
+        fmt.Println()
+
+        switch DisplayOptions([]string{"Open an organization", "Create an organization", "Done"}) {
+        case 1:
+            entry := 0
+            fmt.Print("Enter organization: ")
+            fmt.Scanln(&entry)
+            if entry < 1 || entry > len(orgs) {
+                fmt.Printf("Organization not found\n\n")
+                break
+            }
+            openOrganization(db, user, orgs[entry - 1].ID)
+
+        case 2:
+            org, err := models.CreateOrganization(db, readName("organization name"), user, models.LocalSource)
+            if err != nil && refusal(err) {
+                break
+            }
+            checkError(err)
+            fmt.Printf("Created %s\n\n", org.Name)
+
+        default:
+            return
+        }
+    }
+}
+
+/**
+ * @brief:  Menu of an organization. It's opened again each time, so a new
+ *          key or role takes effect right away.
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - Member with its vault key unlocked
+ * @arg:    id - ID of the organization
+ **/
+func openOrganization(db *gorm.DB, user models.User, id uint) {
+    for {
+        org, err := models.OpenOrganization(db, id, user)
+        if err == models.ErrNotFound {
+            fmt.Printf("You're no longer in the organization\n\n")
+            return
+        }
+        checkError(err)
+        fmt.Printf("%s\n\n", org)
+
+        switch DisplayOptions([]string{"Collections", "Members", "Leave", "Done"}) {
+        case 1:
+            orgCollections(db, user, org)
+
+        case 2:
+            orgMembers(db, user, org)
+
+        /* The organization gets a new key, so what the user kept opens nothing */
+        case 3:
+            answer := ""
+            fmt.Printf("Leave %s? (y or n): ", org.Name)
+            fmt.Scanln(&answer)
+            if strings.ToLower(answer) != "y" {
+                break
+            }
+
+            err := models.RemoveMember(db, org, user, user.Username, models.LocalSource)
+            if err != nil && refusal(err) {
+                break
+            }
+            checkError(err)
+            fmt.Printf("You left %s\n\n", org.Name)
+            return
+
+        default:
+            return
+        }
+    }
+}
+
+/**
+ * @brief:  List the collections of an organization and let the member open
+ *          one, or create or delete one as an admin
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - Member
+ * @arg:    org - Organization opened for the member
+ **/
+func orgCollections(db *gorm.DB, user models.User, org models.Organization) {
+    for {
+        collections, err := models.Collections(db, org)
+        checkError(err)
+
+        if len(collections) == 0 {
+            fmt.Printf("%s has no collections\n", org.Name)
+        }
+        for i, collection := range collections {
+            fmt.Printf("%d.) %s\n", i + 1, collection.Name)
+        }
+        fmt.Println()
+
+        input := DisplayOptions([]string{"Open a collection", "Create a collection", "Delete a collection", "Done"})
+        if input == 2 {
+            _, err := models.CreateCollection(db, org, user, readName("collection name"), models.LocalSource)
+            if err == models.ErrOrgRekeyed {
+                refusal(err)
+                return
+            } else if err != nil && refusal(err) {
+                continue
+            }
+            checkError(err)
+            fmt.Printf("Collection created\n\n")
+            continue
+        } else if input == 4 {
+            return
+        }
+
+        entry := 0
+        fmt.Print("Enter collection: ")
+        fmt.Scanln(&entry)
+        if entry < 1 || entry > len(collections) {
+            fmt.Printf("Collection not found\n\n")
+            continue
+        }
+        collection := collections[entry - 1]
+
+        if input == 1 {
+            if !collectionEntries(db, user, org, collection) {
+                return
+            }
+            continue
+        }
+
+        err = models.DeleteCollection(db, org, user, collection.ID, models.LocalSource)
+        if err == models.ErrOrgRekeyed {
+            refusal(err)
+            return
+        } else if err != nil && refusal(err) {
+            continue
+        }
+        checkError(err)
+        fmt.Printf("Deleted %s\n\n", collection.Name)
+    }
+}
+
+/**
+ * @brief:  List the entries of a collection and let the member open one or
+ *          add one
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - Member
+ * @arg:    org - Organization opened for the member
+ * @arg:    collection - Collection of the organization
+ *
+ * @return: false if the organization has to be opened again, else true
+ **/
+func collectionEntries(db *gorm.DB, user models.User, org models.Organization, collection models.Collection) bool {
+    for {
+        switch DisplayOptions([]string{"Get entry", "Add entry", "Done"}) {
+        case 1:
+            vaults, err := models.CollectionEntries(db, org, collection.ID)
+            if err == models.ErrOrgRekeyed || err == models.ErrNotFound {
+                refusal(err)
+                return false
+            }
+            checkError(err)
+            if len(vaults) == 0 {
+                fmt.Printf("Nothing in %s\n\n", collection.Name)
+                break
+            }
+
+            entry := getVaultItems(vaults) - 1
+            if entry < 0 {
+                break
+            }
+
+            vault, err := models.OrgEntryByID(db, org, vaults[entry].ID)
+            if err == models.ErrOrgRekeyed {
+                refusal(err)
+                return false
+            } else if err != nil && refusal(err) {
+                break
+            }
+            checkError(err)
+            if !orgEntryOptions(db, user, org, vault) {
+                return false
+            }
+
+        case 2:
+            vault := models.EntryInfo(0)
+            vault.CollectionID = collection.ID
+            err := models.CreateOrgEntry(db, org, user, &vault, models.LocalSource)
+            if err == models.ErrOrgRekeyed {
+                refusal(err)
+                return false
+            } else if err != nil && refusal(err) {
+                break
+            }
+            checkError(err)
+            fmt.Printf("New item added to %s: %s\n\n", collection.Name, vault)
+
+        default:
+            return true
+        }
+    }
+}
+
+/**
+ * @brief:  Let the member copy from an entry of an organization, or edit or
+ *          delete it unless they're read-only
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - Member
+ * @arg:    org - Organization opened for the member
+ * @arg:    vault - Decrypted entry
+ *
+ * @return: false if the organization has to be opened again, else true
+ **/
+func orgEntryOptions(db *gorm.DB, user models.User, org models.Organization, vault models.Vault) bool {
+    models.DisplayEntry(vault)
+
+    options := []string{"Copy password or secret to clipboard", "Copy current code", "Done"}
+    if org.Role != models.RoleReadOnly {
+        options = []string{"Copy password or secret to clipboard", "Copy current code", "Edit info", "Delete", "Done"}
+    }
+
+    var err error
+    switch DisplayOptions(options) {
+    case 1:
+        fmt.Println("Secret copied to clipboard")
+        clipboard.WriteAll(vault.Secret())
+
+    case 2:
+        code, remaining, err := models.CurrentCode(vault)
+        if err == models.ErrTOTPRequired {
+            fmt.Printf("%s has no TOTP secret\n\n", vault)
+            break
+        }
+        checkError(err)
+        clipboard.WriteAll(code)
+        fmt.Printf("Code copied to clipboard, valid for %d more seconds\n\n", remaining)
+
+    case 3:
+        if len(options) == 3 {
+            break
+        }
+
+        updated_vault := models.EditedEntry(vault)
+        err = models.UpdateOrgEntry(db, org, user, &updated_vault, models.LocalSource)
+        if err == nil {
+            fmt.Printf("Updated: %s\n\n", updated_vault)
+        }
+
+    case 4:
+        answer := ""
+        fmt.Printf("Delete %s from %s? (y or n): ", vault, org.Name)
+        fmt.Scanln(&answer)
+        if strings.ToLower(answer) != "y" {
+            fmt.Printf("\n%s wasn't deleted\n\n", vault)
+            break
+        }
+
+        err = models.DeleteOrgEntry(db, org, user, vault.ID, models.LocalSource)
+        if err == nil {
+            fmt.Printf("%s was deleted\n\n", vault)
+        }
+    }
+
+    if err != nil && !refusal(err) {
+        checkError(err)
+    }
+
+    return err != models.ErrOrgRekeyed && err != models.ErrNotFound
+}
+
+/**
+ * @brief:  List the members of an organization and let an admin add,
+ *          change or remove them. Removing a member replaces the
+ *          organization key.
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - Member
+ * @arg:    org - Organization opened for the member
+ **/
+func orgMembers(db *gorm.DB, user models.User, org models.Organization) {
+    members, err := models.Members(db, org)
+    checkError(err)
+    for i, member := range members {
+        fmt.Printf("%d.) %s\n", i + 1, member)
+    }
+    fmt.Println()
+
+    switch DisplayOptions([]string{"Add a member", "Change a role", "Remove a member", "Done"}) {
+    case 1:
+        username := ""
+        fmt.Print("Enter username: ")
+        fmt.Scanln(&username)
+
+        err = models.AddMember(db, org, user, username, chooseRole(), models.LocalSource)
+        if err == nil {
+            fmt.Printf("Added %s to %s\n\n", username, org.Name)
+        }
+
+    case 2:
+        member, ok := chooseMember(members)
+        if !ok {
+            break
+        }
+
+        role := chooseRole()
+        err = models.SetMemberRole(db, org, user, member.Username, role, models.LocalSource)
+        if err == nil {
+            fmt.Printf("%s is now %s\n\n", member.Username, role)
+        }
+
+    case 3:
+        member, ok := chooseMember(members)
+        if !ok {
+            break
+        }
+
+        err = models.RemoveMember(db, org, user, member.Username, models.LocalSource)
+        if err == nil {
+            fmt.Printf("Removed %s from %s\n\n", member.Username, org.Name)
+        }
+    }
+
+    if err != nil && !refusal(err) {
+        checkError(err)
+    }
+}
+
+/**
+ * @brief:  Let the user pick a member from a list
+ *
+ * @arg:    members - Listed members
+ *
+ * @return: Member, and false if the number isn't on the list
+ **/
+func chooseMember(members []models.Membership) (models.Membership, bool) {
+    input := 0
+    fmt.Print("Enter member: ")
+    fmt.Scanln(&input)
+    if input < 1 || input > len(members) {
+        fmt.Printf("Member not found\n\n")
+        return models.Membership{}, false
+    }
+
+    return members[input - 1], true
+}
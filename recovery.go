@@ -0,0 +1,176 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "strings"
+
+    "github.com/jinzhu/gorm"
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * @brief:  Show an emergency kit and offer to save it to a file
+ *
+ * @arg:    kit - Text of the kit
+ * @arg:    filename - File to save it to
+ **/
+func displayKit(kit string, filename string) {
+    fmt.Println()
+    fmt.Println(kit)
+
+    answer := ""
+    fmt.Print("Save the kit to a file to print? (y or n): ")
+    fmt.Scanln(&answer)
+    if strings.ToLower(answer) != "y" {
+        return
+    }
+
+    err := ioutil.WriteFile(filename, []byte(kit), 0600)
+    checkError(err)
+    fmt.Printf("Saved to %s, delete it once it's printed\n\n", filename)
+}
+
+/**
+ * @brief:  Show a new recovery key, whole or split into shares for several
+ *          trusted people. It isn't shown again.
+ *
+ * @arg:    username - Account of the recovery key
+ * @arg:    words - Recovery words
+ **/
+func displayRecoveryKey(username string, words string) {
+    answer := ""
+    fmt.Print("Split the key so several trusted people are needed to recover? (y or n): ")
+    fmt.Scanln(&answer)
+    if strings.ToLower(answer) != "y" {
+        displayKit(models.EmergencyKit(username, words),
+            fmt.Sprintf("vaultdepot-emergency-kit-%s.txt", username))
+        return
+    }
+
+    var shares []string
+    var count int
+    for shares == nil {
+        threshold := 0
+        fmt.Printf("Number of shares (2 - %d): ", compat.MaxShares)
+        fmt.Scanln(&count)
+        fmt.Print("Shares needed to recover: ")
+        fmt.Scanln(&threshold)
+
+        var err error
+        shares, err = models.SplitRecoveryKey(words, count, threshold)
+        if err == compat.ErrSharesCount {
+            fmt.Println(compat.ErrSharesCount.Public())
+            continue
+        }
+        checkError(err)
+    }
+
+    /* Each holder should only see their own share */
+    for i, share := range shares {
+        kit, err := models.ShareKit(username, share, count)
+        checkError(err)
+        displayKit(kit, fmt.Sprintf("vaultdepot-emergency-kit-%s-share-%d.txt", username, i + 1))
+
+        /* Clear the screen before the next holder looks */
+        fmt.Print("Press Enter once the share is handed over")
+        fmt.Scanln()
+        fmt.Print("\033[H\033[2J")
+    }
+}
+
+/**
+ * @brief:  Create a recovery key for the user, asking first if it replaces
+ *          one
+ *
+ * @arg:    db - Pointer to database
+ * @arg:    user - User with its vault key unlocked
+ **/
+func setupRecoveryKey(db *gorm.DB, user *models.User) {
+    if len(user.RecoveryKeyCipher) > 0 {
+        answer := ""
+        fmt.Print("Replace your recovery key? The old kit or shares stop working (y or n): ")
+        fmt.Scanln(&answer)
+        if strings.ToLower(answer) != "y" {
+            return
+        }
+    }
+
+    words, err := models.CreateRecoveryKey(db, user, models.LocalSource)
+    checkError(err)
+    displayRecoveryKey(user.Username, words)
+}
+
+/**
+ * @brief:  Have the holders of a split recovery key enter their shares,
+ *          until enough of them are in
+ *
+ * @return: Recovery words combined from the shares
+ **/
+func enterRecoveryShares() string {
+    var shares []string
+    entered := map[int]bool{}
+    threshold := 2
+    for len(shares) < threshold {
+        words, err := models.UserInput(fmt.Sprintf("Enter the words of share %d", len(shares) + 1))
+        checkError(err)
+
+        share, err := compat.ParseRecoveryShare(words)
+        if err != nil {
+            fmt.Println(compat.ErrSharesInvalid.Public())
+            continue
+        }
+        if entered[share.Index] {
+            fmt.Printf("Share %d was entered already\n", share.Index)
+            continue
+        }
+        entered[share.Index] = true
+        threshold = share.Threshold
+        shares = append(shares, words)
+        fmt.Printf("Share %d accepted, %d of %d entered\n", share.Index, len(shares), threshold)
+    }
+    fmt.Println()
+
+    words, err := models.CombineRecoveryShares(shares)
+    checkError(err)
+
+    return words
+}
+
+/**
+ * @brief:  Reset the password and secret key of an account with the words
+ *          of its emergency kit, or the shares of it
+ *
+ * @arg:    db - Pointer to database
+ **/
+func recoverAccount(db *gorm.DB) {
+    username := ""
+    for username == "" {
+        fmt.Print("Enter username: ")
+        fmt.Scanln(&username)
+    }
+
+    var words string
+    if DisplayOptions([]string{"Recover with the emergency kit", "Recover with shares of the key"}) == 2 {
+        words = enterRecoveryShares()
+    } else {
+        var err error
+        words, err = models.UserInput("Enter the recovery key words, separated by spaces")
+        checkError(err)
+        fmt.Println()
+    }
+
+    fmt.Println("Choose a new password and secret key")
+    reset := models.User{
+        Password: models.HiddenInput("password"),
+        SecretKey: models.HiddenInput("secret key"),
+    }
+
+    user, newWords, err := models.RecoverAccount(db, username, words, reset, models.LocalSource)
+    checkError(err)
+
+    fmt.Println("Password and secret key reset, every session was logged out")
+    fmt.Println("Your old kit or shares stop working, here is the new key")
+    displayRecoveryKey(user.Username, newWords)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+
+    "github.com/atotto/clipboard"
+    "github.com/loerac/vaultDepot/client"
+    "github.com/loerac/vaultDepot/models"
+)
+
+var remote_menu_options []string = []string{"Get vault item", "Search vault", "Filter by folder or tag", "Add vault item", "Two-factor authentication", "Log out"}
+var remote_vault_options []string = []string{"Copy password or secret to clipboard", "Copy current code", "Edit info", "Delete"}
+
+/**
+ * @brief:  Log into, or sign up on, a vaultDepot server
+ *
+ * @arg:    remote - Client of the server
+ **/
+func remoteLogin(remote *client.Client) {
+    menu := DisplayOptions(menu_options)
+
+    reader := bufio.NewReader(os.Stdin)
+    fmt.Print("Enter Username: ")
+    username, _ := reader.ReadString('\n')
+    username = strings.TrimSpace(username)
+
+    password := models.HiddenInput("password")
+    secret_key := models.HiddenInput("secret key")
+
+    /* Deriving the keys is slow on purpose */
+    fmt.Println("Deriving your keys...")
+    var err error
+    if menu == 1 {
+        err = remote.Login(username, password, secret_key)
+    } else {
+        err = remote.Signup(username, password, secret_key)
+    }
+
+    /* The keys are derived already, only the code is asked for */
+    for attempts := 3; err == models.ErrTwoFactorRequired || (err == models.ErrTwoFactorInvalid && attempts > 0); attempts-- {
+        if err == models.ErrTwoFactorInvalid {
+            fmt.Println("Wrong code, try again")
+        }
+
+        code, input_err := models.UserInput("Enter two-factor code or recovery code")
+        checkError(input_err)
+        err = remote.SecondFactor(code)
+    }
+    checkError(err)
+    fmt.Println("\nWelcome", username)
+}
+
+/**
+ * @brief:  Find the entries whose application or email is the term
+ *
+ * @arg:    vaults - Decrypted entries
+ * @arg:    term - Application or email
+ *
+ * @return: Entries found
+ **/
+func findExact(vaults []models.Vault, term string) []models.Vault {
+    term = strings.TrimSpace(term)
+
+    var found []models.Vault
+    for _, vault := range vaults {
+        if strings.EqualFold(vault.Application, term) || strings.EqualFold(vault.Email, term) {
+            found = append(found, vault)
+        }
+    }
+
+    return found
+}
+
+/**
+ * @brief:  Let user copy the secret or code of a remote entry, edit it or
+ *          delete it
+ *
+ * @arg:    remote - Logged in client
+ * @arg:    vault - Selected entry
+ **/
+func selectRemoteOptions(remote *client.Client, vault models.Vault) {
+    var char_input string
+
+    models.DisplayEntry(vault)
+    switch DisplayOptions(remote_vault_options) {
+    /* Copy password, or the item's secret, to clipboard */
+    case 1:
+        fmt.Println("Secret copied to clipboard")
+        clipboard.WriteAll(vault.Secret())
+
+    /* Copy TOTP code to clipboard */
+    case 2:
+        code, remaining, err := models.CurrentCode(vault)
+        if err == models.ErrTOTPRequired {
+            fmt.Printf("%s has no TOTP secret\n\n", vault)
+            break
+        }
+        checkError(err)
+        clipboard.WriteAll(code)
+        fmt.Printf("Code copied to clipboard, valid for %d more seconds\n\n", remaining)
+
+    /* Edit entry */
+    case 3:
+        fmt.Printf("Update %s? (y or n): ", vault)
+        fmt.Scanln(&char_input)
+        if strings.ToLower(char_input) == "y" {
+            updated_vault := models.EditedEntry(vault)
+            checkError(remote.Save(&updated_vault))
+            fmt.Printf("Updated: %s\n\n", updated_vault)
+        } else {
+            fmt.Printf("\n%s wasn't updated\n\n", vault)
+        }
+
+    /* Delete entry */
+    case 4:
+        fmt.Printf("Delete %s from vault? (y or n): ", vault)
+        fmt.Scanln(&char_input)
+        if strings.ToLower(char_input) == "y" {
+            checkError(remote.Delete(vault.ID))
+            fmt.Printf("%s was deleted\n\n", vault)
+        } else {
+            fmt.Printf("\n%s wasn't deleted\n\n", vault)
+        }
+    }
+}
+
+/**
+ * @brief:  Use the vault of a vaultDepot server. Entries are encrypted and
+ *          decrypted here, the server only ever sees ciphertext.
+ *
+ * @arg:    server - URL of the server
+ * @arg:    filter - Folder and tag to list
+ **/
+func remoteMain(server string, filter models.VaultFilter) {
+    remote, err := client.New(server)
+    checkError(err)
+
+    remoteLogin(remote)
+    defer remote.Logout()
+
+    vaults, err := remote.Entries()
+    checkError(err)
+    if len(vaults) == 0 {
+        fmt.Println("Looks like you have nothing in your vault, let's update that")
+        vault := models.EntryInfo(0)
+        checkError(remote.Save(&vault))
+        vaults = append(vaults, vault)
+    }
+
+    for {
+        switch DisplayOptions(remote_menu_options) {
+        /* Get vault item, in the folder and tag filtered on */
+        case 1:
+            shown := models.FilterVaults(vaults, filter)
+            if len(shown) == 0 {
+                fmt.Printf("Nothing in %s\n\n", filter)
+                break
+            }
+
+            input := getVaultItems(shown)
+            if input == -1 {
+                break
+            }
+            selectRemoteOptions(remote, shown[input - 1])
+
+        /* Search vault by exact application or email, in the filtered folder and tag */
+        case 2:
+            term := ""
+            fmt.Print("Enter application or email: ")
+            fmt.Scanln(&term)
+
+            found := models.FilterVaults(findExact(vaults, term), filter)
+            if len(found) == 0 {
+                fmt.Printf("Nothing found for %s\n\n", term)
+                break
+            }
+
+            entry := getVaultItems(found) - 1
+            if entry < 0 {
+                break
+            }
+            selectRemoteOptions(remote, found[entry])
+
+        /* Narrow the listed items down to a folder or tag */
+        case 3:
+            models.DisplayFolders(vaults)
+
+            reader := bufio.NewReader(os.Stdin)
+            fmt.Print("Enter folder (empty for all): ")
+            folder, _ := reader.ReadString('\n')
+            fmt.Print("Enter tag (empty for all): ")
+            tag, _ := reader.ReadString('\n')
+
+            filter = models.VaultFilter{
+                Folder: models.NormalizeFolder(folder),
+                Tag: strings.TrimSpace(tag),
+            }
+            fmt.Printf("Showing %s\n\n", filter)
+
+        /* Add vault item */
+        case 4:
+            vault := models.EntryInfo(0)
+            checkError(remote.Save(&vault))
+
+        /* Turn two-factor login on or off */
+        case 5:
+            manageTwoFactor(remote)
+
+        /* End the session on the server */
+        default:
+            fmt.Println("Logged out, bye bye")
+            return
+        }
+
+        vaults, err = remote.Entries()
+        checkError(err)
+    }
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "fmt"
+
+    "github.com/atotto/clipboard"
+    "github.com/jinzhu/gorm"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * @brief:  List whom an entry is shared with and let the owner share it
+ *          with someone else or stop sharing it
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - Owner of the entry
+ * @arg:    vault - Entry to share
+ **/
+func manageShares(db *gorm.DB, user models.User, vault models.Vault) {
+    for {
+        shares, err := models.EntryShares(db, vault)
+        checkError(err)
+
+        if len(shares) == 0 {
+            fmt.Printf("%s isn't shared\n", vault)
+        }
+        for i, share := range shares {
+            fmt.Printf("%d.) %s\n", i + 1, share)
+        }
+        fmt.Println()
+
+        switch DisplayOptions([]string{"Share with a user", "Stop sharing with a user", "Done"}) {
+        case 1:
+            username := ""
+            fmt.Print("Enter username: ")
+            fmt.Scanln(&username)
+
+            permission := models.PermissionReadOnly
+            fmt.Println("They can:")
+            if DisplayOptions([]string{"Read it", "Read and edit it"}) == 2 {
+                permission = models.PermissionReadWrite
+            }
+
+            err := models.ShareEntry(db, vault, user, username, permission, models.LocalSource)
+            switch err {
+            case models.ErrNotFound:
+                fmt.Printf("There's no user %s\n\n", username)
+                continue
+            case models.ErrShareSelf:
+                fmt.Printf("%s\n\n", models.ErrShareSelf.Public())
+                continue
+            case models.ErrShareNoKey:
+                fmt.Printf("%s\n\n", models.ErrShareNoKey.Public())
+                continue
+            }
+            checkError(err)
+            fmt.Printf("Shared %s with %s\n\n", vault, username)
+
+        /* The entry gets a new key, so what was shared can't be opened anymore */
+        case 2:
+            entry := 0
+            fmt.Print("Enter share: ")
+            fmt.Scanln(&entry)
+            if entry < 1 || entry > len(shares) {
+                fmt.Println("Share not found")
+                break
+            }
+
+            err := models.RevokeShare(db, vault, user, shares[entry - 1].Username, models.LocalSource)
+            checkError(err)
+            fmt.Printf("Stopped sharing %s with %s\n\n", vault, shares[entry - 1].Username)
+
+        default:
+            return
+        }
+    }
+}
+
+/**
+ * @brief:  List the entries others shared with the user and let them copy
+ *          from one, or edit it if it's shared read-write
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    user - User the entries are shared with
+ **/
+func sharedWithMe(db *gorm.DB, user models.User) {
+    shared, err := models.SharedEntries(db, user)
+    checkError(err)
+    if len(shared) == 0 {
+        fmt.Printf("Nothing is shared with you\n\n")
+        return
+    }
+
+    entry := getVaultItems(shared) - 1
+    if entry < 0 {
+        return
+    }
+
+    vault, err := models.SharedByID(db, shared[entry].ID, user)
+    checkError(err)
+    models.DisplayEntry(vault)
+
+    options := []string{"Copy password or secret to clipboard", "Copy current code", "Done"}
+    if vault.Share.Permission == models.PermissionReadWrite {
+        options = []string{"Copy password or secret to clipboard", "Copy current code", "Edit info", "Done"}
+    }
+
+    switch DisplayOptions(options) {
+    case 1:
+        fmt.Println("Secret copied to clipboard")
+        clipboard.WriteAll(vault.Secret())
+
+    case 2:
+        code, remaining, err := models.CurrentCode(vault)
+        if err == models.ErrTOTPRequired {
+            fmt.Printf("%s has no TOTP secret\n\n", vault)
+            break
+        }
+        checkError(err)
+        clipboard.WriteAll(code)
+        fmt.Printf("Code copied to clipboard, valid for %d more seconds\n\n", remaining)
+
+    case 3:
+        if len(options) == 3 {
+            break
+        }
+
+        updated_vault := models.EditedEntry(vault)
+        err := models.UpdateSharedEntry(db, &updated_vault, user)
+        switch err {
+        case models.ErrNotFound, models.ErrShareReadOnly:
+            fmt.Printf("%s is no longer shared with you to edit\n\n", vault)
+            return
+        case models.ErrShareChanged:
+            fmt.Printf("%s\n\n", models.ErrShareChanged.Public())
+            return
+        }
+        checkError(err)
+        fmt.Printf("Updated: %s\n\n", updated_vault)
+    }
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "fmt"
+
+    "github.com/jinzhu/gorm"
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Account whose two-factor login can be managed, local or on a server
+ **/
+type twoFactorAccount interface {
+    TwoFactorEnabled() (bool, error)
+    BeginTwoFactor() (string, error)
+    ConfirmTwoFactor(code string) ([]string, error)
+    RegenerateRecoveryCodes(code string) ([]string, error)
+    DisableTwoFactor(code string) error
+}
+
+/**
+ * Account in the database, the codes are typed on this machine
+ **/
+type localAccount struct {
+    db      *gorm.DB
+    user    *models.User
+}
+
+func (account localAccount) TwoFactorEnabled() (bool, error) {
+    return account.user.TwoFactorEnabled(), nil
+}
+
+func (account localAccount) BeginTwoFactor() (string, error) {
+    return models.BeginTwoFactor(account.db, account.user)
+}
+
+func (account localAccount) ConfirmTwoFactor(code string) ([]string, error) {
+    return models.ConfirmTwoFactor(account.db, account.user, code, models.LocalSource)
+}
+
+func (account localAccount) RegenerateRecoveryCodes(code string) ([]string, error) {
+    return models.RegenerateRecoveryCodes(account.db, account.user, code, models.LocalSource)
+}
+
+func (account localAccount) DisableTwoFactor(code string) error {
+    return models.DisableTwoFactor(account.db, account.user, code, models.LocalSource)
+}
+
+/**
+ * @brief:  Show recovery codes, they aren't shown again
+ *
+ * @arg:    codes - Recovery codes
+ **/
+func displayRecoveryCodes(codes []string) {
+    fmt.Println("Recovery codes, each logs in once without the authenticator app.")
+    fmt.Println("Keep them somewhere safe, they won't be shown again:")
+    for _, code := range codes {
+        fmt.Println("   ", code)
+    }
+    fmt.Println()
+}
+
+/**
+ * @brief:  Turn two-factor login on or off, or replace the recovery codes
+ *
+ * @arg:    account - Account to manage
+ **/
+func manageTwoFactor(account twoFactorAccount) {
+    enabled, err := account.TwoFactorEnabled()
+    checkError(err)
+
+    if !enabled {
+        fmt.Println("Two-factor login is off")
+        if DisplayOptions([]string{"Turn on", "Done"}) != 1 {
+            return
+        }
+
+        uri, err := account.BeginTwoFactor()
+        checkError(err)
+
+        qr, err := compat.QRText(uri)
+        checkError(err)
+        fmt.Println("Scan the code with an authenticator app, or enter the URI:")
+        fmt.Print(qr)
+        fmt.Printf("%s\n\n", uri)
+
+        for {
+            code, err := models.UserInput("Enter the code the app shows")
+            checkError(err)
+
+            codes, err := account.ConfirmTwoFactor(code)
+            if err == models.ErrTwoFactorInvalid {
+                fmt.Println("Wrong code, try again")
+                continue
+            }
+            checkError(err)
+
+            fmt.Println("Two-factor login is on")
+            displayRecoveryCodes(codes)
+            return
+        }
+    }
+
+    fmt.Println("Two-factor login is on")
+    switch DisplayOptions([]string{"New recovery codes", "Turn off", "Done"}) {
+    case 1:
+        code, err := models.UserInput("Enter two-factor code or recovery code")
+        checkError(err)
+
+        codes, err := account.RegenerateRecoveryCodes(code)
+        checkError(err)
+        displayRecoveryCodes(codes)
+
+    case 2:
+        code, err := models.UserInput("Enter two-factor code or recovery code")
+        checkError(err)
+
+        checkError(account.DisableTwoFactor(code))
+        fmt.Println("Two-factor login is off")
+    }
+}
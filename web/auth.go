@@ -0,0 +1,221 @@
+package web
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "strings"
+
+    "github.com/loerac/vaultDepot/models"
+)
+
+type contextKey string
+
+const (
+    userKey    contextKey = "user"
+    sessionKey contextKey = "session"
+)
+
+/**
+ * Cookie holding the remember token of the session
+ **/
+const sessionCookie = "vaultdepot_session"
+
+/**
+ * @brief:  Get the user of an authenticated request (see requireUser)
+ *
+ * @arg:    r - Request
+ *
+ * @return: User
+ **/
+func requestUser(r *http.Request) models.User {
+    return *r.Context().Value(userKey).(*models.User)
+}
+
+/**
+ * @brief:  Only let requests with an active session cookie through, with the
+ *          user and session in the request context. Others are sent to the
+ *          login page.
+ *
+ * @arg:    next - Handler of the route
+ *
+ * @return: Handler
+ **/
+func (s *Server) requireUser(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        cookie, err := r.Cookie(sessionCookie)
+        if err != nil {
+            http.Redirect(w, r, "/login", http.StatusFound)
+            return
+        }
+
+        session, user, err := models.ByRemember(s.db, cookie.Value)
+        if err == nil && user.Remote {
+            err = models.ErrSessionInvalid
+        }
+        if err == models.ErrSessionInvalid {
+            s.clearCookie(w)
+            http.Redirect(w, r, "/login", http.StatusFound)
+            return
+        } else if err != nil {
+            s.renderError(w, r, err)
+            return
+        }
+
+        ctx := context.WithValue(r.Context(), userKey, user)
+        ctx = context.WithValue(ctx, sessionKey, session)
+        next(w, r.WithContext(ctx))
+    }
+}
+
+/**
+ * @brief:  Get the address a request came from, logins are throttled per
+ *          address
+ *
+ * @arg:    r - Request
+ *
+ * @return: IP of the client
+ **/
+func remoteAddr(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+
+    return host
+}
+
+/**
+ * @brief:  Start a session for the user, keep its token in a cookie and go
+ *          to the vault
+ *
+ * @arg:    w - Response
+ * @arg:    r - Login or signup request
+ * @arg:    user - Authenticated user
+ *
+ * @return: nil on success, else error
+ **/
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user models.User) error {
+    session, err := models.CreateSession(s.db, user, "web " + remoteAddr(r))
+    if err != nil {
+        return err
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name: sessionCookie,
+        Value: session.Remember,
+        Path: "/",
+        Expires: session.ExpiresAt,
+        HttpOnly: true,
+        Secure: s.opts.HTTPS,
+        SameSite: http.SameSiteStrictMode,
+    })
+    http.Redirect(w, r, "/vault", http.StatusFound)
+
+    return nil
+}
+
+/**
+ * @brief:  Remove the session cookie
+ *
+ * @arg:    w - Response
+ **/
+func (s *Server) clearCookie(w http.ResponseWriter) {
+    http.SetCookie(w, &http.Cookie{
+        Name: sessionCookie,
+        Path: "/",
+        MaxAge: -1,
+        HttpOnly: true,
+        Secure: s.opts.HTTPS,
+        SameSite: http.SameSiteStrictMode,
+    })
+}
+
+/**
+ * GET /login
+ **/
+func (s *Server) loginForm(w http.ResponseWriter, r *http.Request) {
+    s.render(w, r, "login", http.StatusOK, page{Title: "Log in"})
+}
+
+/**
+ * POST /login
+ **/
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+    username := strings.TrimSpace(r.PostFormValue("username"))
+    user, err := models.Authenticate(s.db, models.User{
+        Username: username,
+        Password: r.PostFormValue("password"),
+        SecretKey: r.PostFormValue("secret_key"),
+        OTP: r.PostFormValue("otp"),
+    }, remoteAddr(r))
+    if err == nil {
+        err = s.startSession(w, r, *user)
+    }
+    if err != nil {
+        status, message := errorMessage(err)
+        s.render(w, r, "login", status, page{Title: "Log in", Alert: message, Data: username})
+    }
+}
+
+/**
+ * GET /signup
+ **/
+func (s *Server) signupForm(w http.ResponseWriter, r *http.Request) {
+    s.render(w, r, "signup", http.StatusOK, page{Title: "Sign up"})
+}
+
+/**
+ * POST /signup
+ **/
+func (s *Server) signup(w http.ResponseWriter, r *http.Request) {
+    user := models.User{
+        Username: strings.TrimSpace(r.PostFormValue("username")),
+        Password: r.PostFormValue("password"),
+        SecretKey: r.PostFormValue("secret_key"),
+    }
+
+    err := s.createUser(&user)
+    if err == nil {
+        err = s.startSession(w, r, user)
+    }
+    if err != nil {
+        status, message := errorMessage(err)
+        s.render(w, r, "signup", status, page{Title: "Sign up", Alert: message, Data: user.Username})
+    }
+}
+
+/**
+ * @brief:  Create an account if the username is free
+ *
+ * @arg:    user - User to create
+ *
+ * @return: nil on success, else error
+ **/
+func (s *Server) createUser(user *models.User) error {
+    if user.Username == "" {
+        return models.ErrUsernameRequired
+    }
+
+    if _, err := models.ByUsername(s.db, user.Username); err == nil {
+        return models.ErrUsernameTaken
+    } else if err != models.ErrNotFound {
+        return err
+    }
+
+    return models.CreateUser(s.db, user)
+}
+
+/**
+ * POST /logout
+ **/
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+    session := r.Context().Value(sessionKey).(*models.Session)
+    if err := models.RevokeSession(s.db, session.UserID, session.ID); err != nil && err != models.ErrNotFound {
+        s.renderError(w, r, err)
+        return
+    }
+
+    s.clearCookie(w)
+    http.Redirect(w, r, "/login", http.StatusFound)
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+    "log"
+    "net/http"
+    "strings"
+
+    "github.com/loerac/vaultDepot/models"
+)
+
+type webError string
+
+var (
+    /* Return when an import form has no file */
+    ErrFileRequired webError = "web: Choose a file to import"
+
+    /* Return when a form can't be read, e.g. a file that is too large */
+    ErrFormInvalid webError = "web: Form is not valid or the file is too large"
+)
+
+func (err webError) Error() string {
+    return string(err)
+}
+
+func (err webError) Public() string {
+    str := strings.Replace(string(err), "web: ", "", 1)
+    return str
+}
+
+/**
+ * Errors that can be shown to the user as is
+ **/
+type publicError interface {
+    error
+    Public() string
+}
+
+/**
+ * @brief:  Get the HTTP status and the message shown for an error. Errors
+ *          with a public message (see modelError.Public) are shown as is,
+ *          anything else is logged and answered with a generic message.
+ *
+ * @arg:    err - Error of a request
+ *
+ * @return: HTTP status and message
+ **/
+func errorMessage(err error) (int, string) {
+    public, ok := err.(publicError)
+    if !ok {
+        log.Println("web:", err)
+        return http.StatusInternalServerError, "Something went wrong, please try again"
+    }
+
+    switch err {
+    case models.ErrNotFound:
+        return http.StatusNotFound, public.Public()
+    case models.ErrLoginFailed, models.ErrSessionInvalid, models.ErrTwoFactorRequired, models.ErrTwoFactorInvalid:
+        return http.StatusUnauthorized, public.Public()
+    case models.ErrLoginThrottled:
+        return http.StatusTooManyRequests, public.Public()
+    case models.ErrRemoteAccount:
+        return http.StatusForbidden, public.Public()
+    }
+
+    return http.StatusBadRequest, public.Public()
+}
+
+/**
+ * @brief:  Join strings for templates
+ *
+ * @arg:    values - Strings to join
+ *
+ * @return: Comma separated values
+ **/
+func joinStrings(values []string) string {
+    return strings.Join(values, ", ")
+}
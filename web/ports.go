@@ -0,0 +1,172 @@
+package web
+
+import (
+    "io/ioutil"
+    "net/http"
+
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/loerac/vaultDepot/manager"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Row of an import that failed
+ **/
+type rowFailure struct {
+    Row   int
+    Error string
+}
+
+/**
+ * Data of the import page, Result is set once a file was imported
+ **/
+type importData struct {
+    Result  *manager.ImportResult
+    DryRun  bool
+    Failed  []rowFailure
+}
+
+/**
+ * @brief:  Get the message of an import row error, rows are the user's own
+ *          data so their parse errors are shown too
+ *
+ * @arg:    err - Error of the row
+ *
+ * @return: Message
+ **/
+func rowMessage(err error) string {
+    if public, ok := err.(publicError); ok {
+        return public.Public()
+    }
+
+    return err.Error()
+}
+
+/**
+ * GET /import
+ **/
+func (s *Server) importForm(w http.ResponseWriter, r *http.Request) {
+    s.render(w, r, "import", http.StatusOK, page{Title: "Import", Data: importData{}})
+}
+
+/**
+ * @brief:  Read the file of an import form, decrypting encrypted exports
+ *
+ * @arg:    r - Multipart request with the file and passphrase
+ *
+ * @return: CSV on success, else error
+ **/
+func importFile(r *http.Request) ([]byte, error) {
+    file, _, err := r.FormFile("file")
+    if err != nil {
+        return nil, ErrFileRequired
+    }
+    defer file.Close()
+
+    data, err := ioutil.ReadAll(file)
+    if err != nil {
+        return nil, err
+    }
+
+    if compat.IsArchive(data) {
+        return compat.OpenArchive(r.PostFormValue("passphrase"), data)
+    }
+
+    return data, nil
+}
+
+/**
+ * POST /import
+ *
+ * Nothing is imported if any row fails
+ **/
+func (s *Server) importEntries(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseMultipartForm(maxBody); err != nil {
+        s.render(w, r, "import", http.StatusBadRequest,
+            page{Title: "Import", Alert: ErrFormInvalid.Public(), Data: importData{}})
+        return
+    }
+
+    options := manager.ImportOptions{DryRun: r.PostFormValue("dry_run") != ""}
+    columnMap, err := manager.ParseColumnMap(r.PostFormValue("map"))
+    if err == nil {
+        options.ColumnMap = columnMap
+        if option := r.PostFormValue("merge"); option != "" {
+            options.Strategy, err = manager.ParseMergeStrategy(option)
+        }
+    }
+
+    var data []byte
+    if err == nil {
+        data, err = importFile(r)
+    }
+
+    var result manager.ImportResult
+    if err == nil {
+        result, err = manager.ImportCSV(s.db, requestUser(r), data, options)
+    }
+    if err != nil && err != manager.ErrImportFailed {
+        status, message := errorMessage(err)
+        s.render(w, r, "import", status, page{Title: "Import", Alert: message, Data: importData{}})
+        return
+    }
+
+    body := importData{Result: &result, DryRun: options.DryRun}
+    for _, row := range result.Failed {
+        body.Failed = append(body.Failed, rowFailure{Row: row.Line, Error: rowMessage(row.Err)})
+    }
+
+    status, alert := http.StatusOK, ""
+    if err == manager.ErrImportFailed {
+        status, alert = http.StatusUnprocessableEntity, manager.ErrImportFailed.Public()
+    }
+
+    s.render(w, r, "import", status, page{Title: "Import", Alert: alert, Data: body})
+}
+
+/**
+ * GET /export
+ **/
+func (s *Server) exportForm(w http.ResponseWriter, r *http.Request) {
+    s.render(w, r, "export", http.StatusOK, page{Title: "Export"})
+}
+
+/**
+ * POST /export
+ *
+ * Downloads a passphrase encrypted export, or a CSV if plaintext is checked
+ **/
+func (s *Server) exportEntries(w http.ResponseWriter, r *http.Request) {
+    plaintext := r.PostFormValue("plaintext") != ""
+    passphrase := r.PostFormValue("passphrase")
+    if !plaintext && len(passphrase) < 8 {
+        s.render(w, r, "export", http.StatusBadRequest,
+            page{Title: "Export", Alert: manager.ErrPassphraseTooShort.Public()})
+        return
+    }
+
+    user := requestUser(r)
+    vaults, err := models.FindAll(s.db, user.ID)
+    if err != nil && err != models.ErrNotFound {
+        s.renderError(w, r, err)
+        return
+    }
+
+    data, err := manager.ExportCSV(vaults, user)
+    if err == nil && !plaintext {
+        data, err = compat.SealArchive(passphrase, data)
+    }
+    if err != nil {
+        s.renderError(w, r, err)
+        return
+    }
+
+    filename, contentType := "vault.vdx", "application/octet-stream"
+    if plaintext {
+        filename, contentType = "vault.csv", "text/csv; charset=utf-8"
+    }
+
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Content-Disposition", "attachment; filename=\"" + filename + "\"")
+    w.Write(data)
+}
@@ -0,0 +1,315 @@
+package web
+
+import (
+    "embed"
+    "html/template"
+    "io/fs"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gorilla/csrf"
+    "github.com/gorilla/mux"
+    "github.com/loerac/vaultDepot/api"
+    "github.com/loerac/vaultDepot/compat"
+    "github.com/loerac/vaultDepot/models"
+    "github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+//go:embed templates static
+var files embed.FS
+
+/**
+ * Only our own scripts and styles, no inline code, no framing
+ **/
+const contentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self'; " +
+    "form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+
+/**
+ * Largest request body, imports included
+ **/
+const maxBody = 32 << 20
+
+/**
+ * Pages, each is rendered inside the layout
+ **/
+var pages = []string{"login", "signup", "vault", "entry", "form", "import", "export", "error"}
+
+/**
+ * Messages shown after a redirect, picked by the notice query parameter so
+ * links can't put their own text on the page
+ **/
+var notices = map[string]string{
+    "added": "Entry added",
+    "saved": "Entry saved",
+    "deleted": "Entry deleted",
+}
+
+/**
+ * Options of the web interface
+ **/
+type Options struct {
+    /* Reached over HTTPS (e.g. through a proxy): Secure cookies and strict
+     * CSRF Referer checks. Plain HTTP is only meant for localhost. */
+    HTTPS bool
+
+    /* Take the client address from the X-Forwarded-For header the proxy in
+     * front sets, logins are throttled per address */
+    TrustProxy bool
+}
+
+/**
+ * Server-rendered web interface of the vault
+ **/
+type Server struct {
+    db        *gorm.DB
+    router    *mux.Router
+    templates map[string]*template.Template
+    opts      Options
+}
+
+/**
+ * Data every page is rendered with
+ **/
+type page struct {
+    Title  string
+    User   *models.User
+    CSRF   template.HTML
+
+    /* Error to show above the page */
+    Alert  string
+
+    /* Message to show above the page */
+    Notice string
+
+    Data   interface{}
+}
+
+/**
+ * Value shown on an entry page with its copy button
+ **/
+type shownValue struct {
+    /* ID of the element holding the value */
+    ID    string
+    Value string
+}
+
+func newShownValue(id, value string) shownValue {
+    return shownValue{ID: id, Value: value}
+}
+
+/**
+ * @brief:  Create the web interface and its routes. The returned handler
+ *          checks the CSRF token of every form.
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    opts - Options of the interface
+ *
+ * @return: Handler on success, else error
+ **/
+func NewServer(db *gorm.DB, opts Options) (http.Handler, error) {
+    s := &Server{db: db, router: mux.NewRouter(), templates: map[string]*template.Template{}, opts: opts}
+
+    funcs := template.FuncMap{
+        "join": joinStrings,
+        "uris": models.FormatURIs,
+        "value": newShownValue,
+    }
+    for _, name := range pages {
+        tpl, err := template.New("layout.gohtml").Funcs(funcs).
+            ParseFS(files, "templates/layout.gohtml", "templates/" + name + ".gohtml")
+        if err != nil {
+            return nil, err
+        }
+        s.templates[name] = tpl
+    }
+
+    static, err := fs.Sub(files, "static")
+    if err != nil {
+        return nil, err
+    }
+
+    r := s.router
+    r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+    r.HandleFunc("/", s.requireUser(s.home)).Methods("GET")
+    r.HandleFunc("/login", s.loginForm).Methods("GET")
+    r.HandleFunc("/login", s.login).Methods("POST")
+    r.HandleFunc("/signup", s.signupForm).Methods("GET")
+    r.HandleFunc("/signup", s.signup).Methods("POST")
+    r.HandleFunc("/logout", s.requireUser(s.logout)).Methods("POST")
+
+    r.HandleFunc("/vault", s.requireUser(s.listEntries)).Methods("GET")
+    r.HandleFunc("/vault", s.requireUser(s.createEntry)).Methods("POST")
+    r.HandleFunc("/vault/new", s.requireUser(s.newEntry)).Methods("GET")
+    r.HandleFunc("/vault/{id:[0-9]+}", s.requireUser(s.showEntry)).Methods("GET")
+    r.HandleFunc("/vault/{id:[0-9]+}", s.requireUser(s.updateEntry)).Methods("POST")
+    r.HandleFunc("/vault/{id:[0-9]+}/edit", s.requireUser(s.editEntry)).Methods("GET")
+    r.HandleFunc("/vault/{id:[0-9]+}/delete", s.requireUser(s.deleteEntry)).Methods("POST")
+
+    r.HandleFunc("/import", s.requireUser(s.importForm)).Methods("GET")
+    r.HandleFunc("/import", s.requireUser(s.importEntries)).Methods("POST")
+    r.HandleFunc("/export", s.requireUser(s.exportForm)).Methods("GET")
+    r.HandleFunc("/export", s.requireUser(s.exportEntries)).Methods("POST")
+
+    /* Tokens only need to survive as long as the server, forms are reloaded after a restart */
+    key, err := compat.Bytes(32)
+    if err != nil {
+        return nil, err
+    }
+    protect := csrf.Protect(key,
+        csrf.Secure(opts.HTTPS),
+        csrf.HttpOnly(true),
+        csrf.SameSite(csrf.SameSiteStrictMode),
+        csrf.Path("/"),
+        csrf.ErrorHandler(http.HandlerFunc(s.csrfFailed)),
+    )
+
+    return s.headers(protect(s.router)), nil
+}
+
+/**
+ * @brief:  Serve the web interface, and the API under /api, until it fails
+ *
+ * @arg:    db - Connection to the database
+ * @arg:    addr - Address to listen on, e.g. "localhost:8080"
+ * @arg:    opts - Options of the web interface
+ *
+ * @return: Error the server stopped with
+ **/
+func ListenAndServe(db *gorm.DB, addr string, opts Options) error {
+    ui, err := NewServer(db, opts)
+    if err != nil {
+        return err
+    }
+
+    routes := http.NewServeMux()
+    routes.Handle("/api/", api.NewServer(db))
+    routes.Handle("/", ui)
+
+    var handler http.Handler = routes
+    if opts.TrustProxy {
+        handler = forwardedFor(routes)
+    }
+
+    server := &http.Server{
+        Addr: addr,
+        Handler: handler,
+        ReadTimeout: 30 * time.Second,
+        WriteTimeout: 60 * time.Second,
+        IdleTimeout: 120 * time.Second,
+    }
+
+    return server.ListenAndServe()
+}
+
+/**
+ * @brief:  Use the address the proxy in front saw as the client address. The
+ *          proxy appends it to X-Forwarded-For, what comes before is up to
+ *          the client.
+ *
+ * @arg:    next - Handler of the request
+ *
+ * @return: Handler
+ **/
+func forwardedFor(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+        if ip := net.ParseIP(strings.TrimSpace(hops[len(hops) - 1])); ip != nil {
+            r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+/**
+ * @brief:  Set the security headers of every response and limit the size
+ *          of request bodies
+ *
+ * @arg:    next - Handler of the request
+ *
+ * @return: Handler
+ **/
+func (s *Server) headers(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        header := w.Header()
+        header.Set("Content-Security-Policy", contentSecurityPolicy)
+        header.Set("X-Content-Type-Options", "nosniff")
+        header.Set("X-Frame-Options", "DENY")
+        header.Set("Referrer-Policy", "same-origin")
+        header.Set("Cache-Control", "no-store")
+        if s.opts.HTTPS {
+            header.Set("Strict-Transport-Security", "max-age=31536000")
+        } else {
+            /* Origin checks of plain HTTP requests can't require an https origin */
+            r = csrf.PlaintextHTTPRequest(r)
+        }
+
+        r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+        next.ServeHTTP(w, r)
+    })
+}
+
+/**
+ * @brief:  Render a page inside the layout
+ *
+ * @arg:    w - Response
+ * @arg:    r - Request
+ * @arg:    name - Page to render
+ * @arg:    status - HTTP status
+ * @arg:    p - Data of the page, the CSRF field and user are filled in
+ **/
+func (s *Server) render(w http.ResponseWriter, r *http.Request, name string, status int, p page) {
+    p.CSRF = csrf.TemplateField(r)
+    if user, ok := r.Context().Value(userKey).(*models.User); ok {
+        p.User = user
+    }
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.WriteHeader(status)
+    if err := s.templates[name].Execute(w, p); err != nil {
+        log.Println("web: rendering", name + ":", err)
+    }
+}
+
+/**
+ * @brief:  Show an error page
+ *
+ * @arg:    w - Response
+ * @arg:    r - Request
+ * @arg:    err - Error of the request
+ **/
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, err error) {
+    status, message := errorMessage(err)
+    s.render(w, r, "error", status, page{Title: "Error", Alert: message})
+}
+
+/**
+ * @brief:  Get the message to show after a redirect
+ *
+ * @arg:    r - Request
+ *
+ * @return: Message, empty if there is none
+ **/
+func noticeOf(r *http.Request) string {
+    return notices[r.URL.Query().Get("notice")]
+}
+
+/**
+ * @brief:  Answer a form that failed the CSRF check
+ **/
+func (s *Server) csrfFailed(w http.ResponseWriter, r *http.Request) {
+    log.Println("web: CSRF check failed:", csrf.FailureReason(r))
+    s.render(w, r, "error", http.StatusForbidden,
+        page{Title: "Error", Alert: "The form expired, go back, reload the page and try again"})
+}
+
+/**
+ * GET /
+ **/
+func (s *Server) home(w http.ResponseWriter, r *http.Request) {
+    http.Redirect(w, r, "/vault", http.StatusFound)
+}
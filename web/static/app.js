@@ -0,0 +1,29 @@
+/* Behaviour of the pages, kept out of the HTML for the content security policy */
+document.addEventListener("click", function (event) {
+    var button = event.target.closest("button[data-reveal], button[data-copy]");
+    if (!button) {
+        return;
+    }
+
+    if (button.dataset.reveal) {
+        var secret = document.getElementById(button.dataset.reveal);
+        var shown = secret.hidden;
+        secret.hidden = !shown;
+        secret.previousElementSibling.hidden = shown;
+        button.textContent = shown ? "Hide" : "Show";
+        return;
+    }
+
+    var value = document.getElementById(button.dataset.copy).textContent;
+    navigator.clipboard.writeText(value).then(function () {
+        button.textContent = "Copied";
+        setTimeout(function () { button.textContent = "Copy"; }, 1500);
+    });
+});
+
+document.addEventListener("submit", function (event) {
+    var message = event.target.dataset.confirm;
+    if (message && !window.confirm(message)) {
+        event.preventDefault();
+    }
+});
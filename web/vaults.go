@@ -0,0 +1,287 @@
+package web
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gorilla/mux"
+    "github.com/loerac/vaultDepot/models"
+)
+
+/**
+ * Item type offered when adding an entry
+ **/
+type typeOption struct {
+    Name  string
+    Label string
+}
+
+/**
+ * Data of the vault page
+ **/
+type listData struct {
+    Entries []models.Vault
+    Query   string
+    Filter  models.VaultFilter
+}
+
+/**
+ * Data of an entry page
+ **/
+type entryData struct {
+    Vault     models.Vault
+    Schema    models.ItemSchema
+
+    /* Current one-time code, if the entry has a TOTP secret */
+    Code      string
+    Remaining int
+}
+
+/**
+ * Data of the add and edit form
+ **/
+type formData struct {
+    Vault   models.Vault
+    Schema  models.ItemSchema
+    Types   []typeOption
+    Editing bool
+}
+
+/**
+ * @brief:  Get the item types that can be added
+ *
+ * @return: Item types with their labels
+ **/
+func typeOptions() []typeOption {
+    var options []typeOption
+    for _, name := range models.ItemTypes() {
+        schema, _ := models.SchemaOf(name)
+        options = append(options, typeOption{Name: name, Label: schema.Label})
+    }
+
+    return options
+}
+
+/**
+ * @brief:  Get the entry ID in the request path
+ *
+ * @arg:    r - Request of a /vault/{id} route
+ *
+ * @return: ID on success, else ErrIDInvalid
+ **/
+func entryID(r *http.Request) (uint, error) {
+    id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+    if err != nil || id == 0 {
+        return 0, models.ErrIDInvalid
+    }
+
+    return uint(id), nil
+}
+
+/**
+ * @brief:  Find the entry of the request path, owned by the user
+ *
+ * @arg:    r - Authenticated request of a /vault/{id} route
+ *
+ * @return: Decrypted vault on success, else error
+ **/
+func (s *Server) findEntry(r *http.Request) (models.Vault, error) {
+    id, err := entryID(r)
+    if err != nil {
+        return models.Vault{}, err
+    }
+
+    return models.ByID(s.db, id, requestUser(r))
+}
+
+/**
+ * @brief:  Copy a submitted form onto a vault, validation is left to
+ *          models.ValidateVaultEntry. When editing, the password, TOTP secret
+ *          and hidden fields are kept if left blank, '-' removes the TOTP
+ *          secret.
+ *
+ * @arg:    r - Request with the form
+ * @arg:    vault - Vault to fill
+ * @arg:    editing - Whether the vault already exists
+ **/
+func applyForm(r *http.Request, vault *models.Vault, editing bool) {
+    vault.Application = strings.TrimSpace(r.PostFormValue("application"))
+    vault.Email = strings.TrimSpace(r.PostFormValue("email"))
+    vault.Username = strings.TrimSpace(r.PostFormValue("username"))
+    vault.URIs = models.ParseURIs(r.PostFormValue("uris"))
+    vault.Folder = r.PostFormValue("folder")
+    vault.Tags = models.ParseTags(r.PostFormValue("tags"))
+    vault.Notes = strings.TrimSpace(r.PostFormValue("notes"))
+
+    if password := r.PostFormValue("password"); password != "" || !editing {
+        vault.Password = password
+    }
+    if totp := strings.TrimSpace(r.PostFormValue("totp")); totp != "" || !editing {
+        vault.TOTP = totp
+    }
+
+    schema, _ := models.SchemaOf(vault.ItemType())
+    payload := map[string]string{}
+    for _, field := range schema.Fields {
+        value := strings.TrimSpace(r.PostFormValue("field_" + field.Name))
+        if value == "" && editing && field.Hidden {
+            value = vault.Payload[field.Name]
+        }
+        if value != "" {
+            payload[field.Name] = value
+        }
+    }
+    vault.Payload = payload
+}
+
+/**
+ * GET /vault?q=&folder=&tag=
+ *
+ * q looks up an exact application or email (see models.FindExact)
+ **/
+func (s *Server) listEntries(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    data := listData{
+        Query: strings.TrimSpace(query.Get("q")),
+        Filter: models.VaultFilter{Folder: query.Get("folder"), Tag: query.Get("tag")},
+    }
+
+    user := requestUser(r)
+    var vaults []models.Vault
+    var err error
+    if data.Query != "" {
+        vaults, err = models.FindExact(s.db, user, data.Query)
+    } else if vaults, err = models.FindAll(s.db, user.ID); err == nil {
+        if err = models.DecryptMetadata(vaults, user); err == nil {
+            models.SortVaults(vaults)
+        }
+    }
+    if err != nil && err != models.ErrNotFound {
+        s.renderError(w, r, err)
+        return
+    }
+
+    data.Entries = models.FilterVaults(vaults, data.Filter)
+    s.render(w, r, "vault", http.StatusOK, page{Title: "Vault", Notice: noticeOf(r), Data: data})
+}
+
+/**
+ * GET /vault/{id}
+ **/
+func (s *Server) showEntry(w http.ResponseWriter, r *http.Request) {
+    vault, err := s.findEntry(r)
+    if err != nil {
+        s.renderError(w, r, err)
+        return
+    }
+
+    data := entryData{Vault: vault}
+    data.Schema, _ = models.SchemaOf(vault.ItemType())
+    if vault.TOTP != "" {
+        if data.Code, data.Remaining, err = models.CurrentCode(vault); err != nil {
+            s.renderError(w, r, err)
+            return
+        }
+    }
+
+    s.render(w, r, "entry", http.StatusOK, page{Title: vault.Application, Notice: noticeOf(r), Data: data})
+}
+
+/**
+ * GET /vault/new?type=
+ **/
+func (s *Server) newEntry(w http.ResponseWriter, r *http.Request) {
+    vault := models.Vault{Type: r.URL.Query().Get("type")}
+    schema, ok := models.SchemaOf(vault.ItemType())
+    if !ok {
+        s.renderError(w, r, models.ErrTypeInvalid)
+        return
+    }
+
+    data := formData{Vault: vault, Schema: schema, Types: typeOptions()}
+    s.render(w, r, "form", http.StatusOK, page{Title: "Add " + schema.Label, Data: data})
+}
+
+/**
+ * POST /vault
+ **/
+func (s *Server) createEntry(w http.ResponseWriter, r *http.Request) {
+    user := requestUser(r)
+    vault := models.Vault{UserID: user.ID, Type: r.PostFormValue("type")}
+    schema, ok := models.SchemaOf(vault.ItemType())
+    if !ok {
+        s.renderError(w, r, models.ErrTypeInvalid)
+        return
+    }
+
+    applyForm(r, &vault, false)
+    shown := vault
+    if err := models.CreateVaultEntry(s.db, &vault, user); err != nil {
+        status, message := errorMessage(err)
+        data := formData{Vault: shown, Schema: schema, Types: typeOptions()}
+        s.render(w, r, "form", status, page{Title: "Add " + schema.Label, Alert: message, Data: data})
+        return
+    }
+
+    http.Redirect(w, r, "/vault/" + strconv.FormatUint(uint64(vault.ID), 10) + "?notice=added", http.StatusSeeOther)
+}
+
+/**
+ * GET /vault/{id}/edit
+ **/
+func (s *Server) editEntry(w http.ResponseWriter, r *http.Request) {
+    vault, err := s.findEntry(r)
+    if err != nil {
+        s.renderError(w, r, err)
+        return
+    }
+
+    data := formData{Vault: vault, Editing: true}
+    data.Schema, _ = models.SchemaOf(vault.ItemType())
+    s.render(w, r, "form", http.StatusOK, page{Title: "Edit " + vault.Application, Data: data})
+}
+
+/**
+ * POST /vault/{id}
+ *
+ * The type and custom fields of the entry are kept
+ **/
+func (s *Server) updateEntry(w http.ResponseWriter, r *http.Request) {
+    vault, err := s.findEntry(r)
+    if err != nil {
+        s.renderError(w, r, err)
+        return
+    }
+
+    applyForm(r, &vault, true)
+    shown := vault
+    if err := models.UpdateVaultEntry(s.db, &vault, requestUser(r)); err != nil {
+        status, message := errorMessage(err)
+        data := formData{Vault: shown, Editing: true}
+        data.Schema, _ = models.SchemaOf(shown.ItemType())
+        s.render(w, r, "form", status, page{Title: "Edit " + shown.Application, Alert: message, Data: data})
+        return
+    }
+
+    http.Redirect(w, r, "/vault/" + strconv.FormatUint(uint64(vault.ID), 10) + "?notice=saved", http.StatusSeeOther)
+}
+
+/**
+ * POST /vault/{id}/delete
+ **/
+func (s *Server) deleteEntry(w http.ResponseWriter, r *http.Request) {
+    /* Only the owner may delete it */
+    vault, err := s.findEntry(r)
+    if err != nil {
+        s.renderError(w, r, err)
+        return
+    }
+
+    if err := models.DeleteID(s.db, vault.ID); err != nil {
+        s.renderError(w, r, err)
+        return
+    }
+
+    http.Redirect(w, r, "/vault?notice=deleted", http.StatusSeeOther)
+}